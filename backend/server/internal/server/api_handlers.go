@@ -82,6 +82,7 @@ func (s *Server) apiBootstrapHandler(w http.ResponseWriter, r *http.Request) {
 	s.handleNonCriticalError(s.updateUsageData(r.Context(), version, remoteIPAddr, userId, deviceId, 0, false))
 	historyEntries, err := s.db.AllHistoryEntriesForUser(r.Context(), userId)
 	checkGormError(err)
+	historyEntries = shared.FilterEntriesForClient(version, historyEntries)
 	fmt.Printf("apiBootstrapHandler: Found %d entries\n", len(historyEntries))
 	if err := json.NewEncoder(w).Encode(historyEntries); err != nil {
 		panic(err)
@@ -110,6 +111,8 @@ func (s *Server) apiQueryHandler(w http.ResponseWriter, r *http.Request) {
 	// Then retrieve
 	historyEntries, err := s.db.HistoryEntriesForDevice(r.Context(), deviceId, 5)
 	checkGormError(err)
+	// Entries that the client is too old to understand are still marked as read below, so that they expire
+	historyEntries = shared.FilterEntriesForClient(version, historyEntries)
 	fmt.Printf("apiQueryHandler: Found %d entries for %s\n", len(historyEntries), r.URL)
 	if err := json.NewEncoder(w).Encode(historyEntries); err != nil {
 		panic(err)
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/DataDog/datadog-go/statsd"
+	"github.com/ddworken/hishtory/shared"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/ext"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
@@ -125,3 +126,88 @@ func withPanicGuard(s *statsd.Client) Middleware {
 		})
 	}
 }
+
+// compressingResponseWriter compresses the response body with the given encoding. The status code is only
+// written once the handler writes the body, so that the Content-Encoding header can be added beforehand.
+type compressingResponseWriter struct {
+	http.ResponseWriter
+	encoding   string
+	statusCode int
+	writer     io.WriteCloser
+}
+
+func (w *compressingResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *compressingResponseWriter) Write(b []byte) (int, error) {
+	if w.writer == nil {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		if w.statusCode == 0 {
+			w.statusCode = http.StatusOK
+		}
+		writer, err := shared.NewCompressingWriter(w.encoding, w.ResponseWriter)
+		if err != nil {
+			return 0, err
+		}
+		w.writer = writer
+		w.Header().Set("Content-Encoding", w.encoding)
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.Header().Del("Content-Length")
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	}
+	return w.writer.Write(b)
+}
+
+// Close flushes the compressed response body, or writes the status code if the handler didn't write a body
+func (w *compressingResponseWriter) Close() error {
+	if w.writer != nil {
+		return w.writer.Close()
+	}
+	if w.statusCode != 0 {
+		w.ResponseWriter.WriteHeader(w.statusCode)
+	}
+	return nil
+}
+
+// withCompression decompresses request bodies sent with a Content-Encoding, and compresses response bodies
+// with the client's preferred encoding from its Accept-Encoding header. It also advertises the encodings
+// that the server accepts for request bodies via the Accept-Encoding response header (see RFC 7694), so
+// that clients know they can compress the payloads they upload.
+func withCompression() Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Set("Accept-Encoding", shared.AcceptEncoding)
+			if encoding := strings.ToLower(r.Header.Get("Content-Encoding")); encoding != "" && encoding != "identity" {
+				if !shared.IsSupportedEncoding(encoding) {
+					http.Error(rw, fmt.Sprintf("unsupported content encoding %q", encoding), http.StatusUnsupportedMediaType)
+					return
+				}
+				body, err := shared.NewDecompressingReader(encoding, r.Body)
+				if err != nil {
+					http.Error(rw, fmt.Sprintf("failed to decompress request body: %v", err), http.StatusBadRequest)
+					return
+				}
+				defer body.Close()
+				r.Body = http.MaxBytesReader(rw, body, shared.MaxDecompressedRequestSize)
+				r.Header.Del("Content-Encoding")
+				r.ContentLength = -1
+			}
+
+			encoding := shared.NegotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" {
+				h.ServeHTTP(rw, r)
+				return
+			}
+			crw := &compressingResponseWriter{ResponseWriter: rw, encoding: encoding}
+			h.ServeHTTP(crw, r)
+			if err := crw.Close(); err != nil {
+				fmt.Printf("failed to compress response: %v\n", err)
+			}
+		})
+	}
+}
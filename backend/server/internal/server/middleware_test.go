@@ -1,11 +1,15 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/ddworken/hishtory/shared"
 )
 
 func TestLoggerMiddleware(t *testing.T) {
@@ -211,3 +215,123 @@ func TestMergeMiddlewares(t *testing.T) {
 		})
 	}
 }
+
+func TestCompressionMiddleware(t *testing.T) {
+	payload := strings.Repeat(`{"EncryptedData":"dGVzdA=="}`, 100)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if string(body) != payload {
+			http.Error(w, "unexpected request body", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(payload))
+	})
+	wrappedHandler := withCompression()(handler)
+
+	for _, encoding := range []string{"", shared.EncodingGzip, shared.EncodingZstd} {
+		t.Run("encoding="+encoding, func(t *testing.T) {
+			body := []byte(payload)
+			if encoding != "" {
+				var err error
+				body, err = shared.Compress(encoding, body)
+				if err != nil {
+					t.Fatalf("failed to compress: %v", err)
+				}
+			}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			if encoding != "" {
+				req.Header.Set("Content-Encoding", encoding)
+				req.Header.Set("Accept-Encoding", encoding)
+			}
+			wrappedHandler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+			}
+			if got := w.Header().Get("Accept-Encoding"); got != shared.AcceptEncoding {
+				t.Errorf("expected Accept-Encoding %q, got %q", shared.AcceptEncoding, got)
+			}
+			if got := w.Header().Get("Content-Encoding"); got != encoding {
+				t.Errorf("expected Content-Encoding %q, got %q", encoding, got)
+			}
+			respBody := w.Body.Bytes()
+			if encoding != "" {
+				var err error
+				respBody, err = shared.Decompress(encoding, respBody)
+				if err != nil {
+					t.Fatalf("failed to decompress response: %v", err)
+				}
+			}
+			if string(respBody) != payload {
+				t.Errorf("unexpected response body %q", respBody)
+			}
+		})
+	}
+}
+
+func TestCompressionMiddlewareLimitsDecompressedSize(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	wrappedHandler := withCompression()(handler)
+
+	for _, size := range []int{shared.MaxDecompressedRequestSize, shared.MaxDecompressedRequestSize + 1} {
+		body, err := shared.Compress(shared.EncodingZstd, make([]byte, size))
+		if err != nil {
+			t.Fatalf("failed to compress: %v", err)
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		req.Header.Set("Content-Encoding", shared.EncodingZstd)
+		wrappedHandler.ServeHTTP(w, req)
+
+		expectedStatusCode := http.StatusOK
+		if size > shared.MaxDecompressedRequestSize {
+			expectedStatusCode = http.StatusRequestEntityTooLarge
+		}
+		if w.Code != expectedStatusCode {
+			t.Errorf("expected status %d for a %d byte body, got %d", expectedStatusCode, size, w.Code)
+		}
+	}
+}
+
+func TestCompressionMiddlewareEmptyResponse(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	withCompression()(handler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("expected no Content-Encoding, got %q", got)
+	}
+}
+
+func TestCompressionMiddlewareUnsupportedEncoding(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler shouldn't be called")
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("test"))
+	req.Header.Set("Content-Encoding", "br")
+	withCompression()(handler).ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status %d, got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+}
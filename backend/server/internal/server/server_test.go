@@ -131,6 +131,56 @@ func TestESubmitThenQuery(t *testing.T) {
 	assertNoLeakedConnections(t, DB)
 }
 
+func TestQueryHistoryNotSentToOldClients(t *testing.T) {
+	// Set up
+	s := NewServer(DB, TrackUsageData(false))
+	userId := data.UserId("qkey")
+	devId1 := uuid.Must(uuid.NewRandom()).String()
+	devId2 := uuid.Must(uuid.NewRandom()).String()
+	devId3 := uuid.Must(uuid.NewRandom()).String()
+	for _, devId := range []string{devId1, devId2, devId3} {
+		deviceReq := httptest.NewRequest(http.MethodGet, "/?device_id="+devId+"&user_id="+userId, nil)
+		s.apiRegisterHandler(httptest.NewRecorder(), deviceReq)
+	}
+
+	// Submit a history entry, a query history entry, and a control message from device 1
+	encEntry, err := data.EncryptHistoryEntry("qkey", testutils.MakeFakeHistoryEntry("ls ~/"))
+	require.NoError(t, err)
+	encQuery, err := data.EncryptQueryHistoryEntry("qkey", data.QueryHistoryEntry{Query: "ls", Timestamp: time.Now().UTC(), DeviceId: devId1, EntryId: uuid.Must(uuid.NewRandom()).String()})
+	require.NoError(t, err)
+	encMsg, err := data.EncryptControlMessage("qkey", data.ControlMessage{EntryId: uuid.Must(uuid.NewRandom()).String(), Timestamp: time.Now().UTC(), DeviceId: devId1, FromBackendType: "http", BackendConfig: []byte("{}")})
+	require.NoError(t, err)
+	reqBody, err := json.Marshal([]shared.EncHistoryEntry{encEntry, encQuery, encMsg})
+	require.NoError(t, err)
+	submitReq := httptest.NewRequest(http.MethodPost, "/?source_device_id="+devId1, bytes.NewReader(reqBody))
+	s.apiSubmitHandler(httptest.NewRecorder(), submitReq)
+
+	retrieve := func(handler http.HandlerFunc, deviceId, version string) []*shared.EncHistoryEntry {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/?device_id="+deviceId+"&user_id="+userId, nil)
+		req.Header.Set("X-Hishtory-Version", version)
+		handler(w, req)
+		require.Equal(t, 200, w.Code)
+		var entries []*shared.EncHistoryEntry
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &entries))
+		return entries
+	}
+
+	// Old clients would store the query history entry and the control message as blank history entries, so they
+	// only receive the history entry
+	entries := retrieve(s.apiQueryHandler, devId2, "v0.335")
+	require.Len(t, entries, 1)
+	require.Equal(t, encEntry.EncryptedId, entries[0].EncryptedId)
+	require.Len(t, retrieve(s.apiBootstrapHandler, devId2, "v0.335"), 1)
+
+	// While new clients receive everything
+	require.Len(t, retrieve(s.apiQueryHandler, devId3, "v0.336"), 3)
+	require.Len(t, retrieve(s.apiBootstrapHandler, devId3, "v0.Unknown"), 3)
+
+	// Assert that we aren't leaking connections
+	assertNoLeakedConnections(t, DB)
+}
+
 func TestDumpRequestAndResponse(t *testing.T) {
 	// Set up
 	s := NewServer(DB, TrackUsageData(false))
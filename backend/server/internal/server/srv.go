@@ -100,6 +100,7 @@ func (s *Server) Run(ctx context.Context, addr string) error {
 	middlewares := mergeMiddlewares(
 		withPanicGuard(s.statsd),
 		withLogging(s.statsd, os.Stdout),
+		withCompression(),
 	)
 
 	mux.Handle("/api/v1/submit", middlewares(http.HandlerFunc(s.apiSubmitHandler)))
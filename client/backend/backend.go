@@ -2,9 +2,15 @@
 // across devices. It supports multiple backend types:
 //   - HTTPBackend: syncs via the hishtory server API (default)
 //   - S3Backend: syncs directly to an S3 bucket (self-hosted option)
+//   - DirBackend: syncs via a directory shared between devices (e.g. a network drive)
+//   - WebDAVBackend: syncs via a WebDAV server (e.g. Nextcloud)
+//   - GitBackend: syncs via a git repository
+//   - MultiBackend: mirrors history across several of the above
 package backend
 
 import (
+	"context"
+
 	"github.com/ddworken/hishtory/shared"
 )
 
@@ -16,6 +22,26 @@ type SyncBackend = shared.SyncBackend
 type BackendType string
 
 const (
-	BackendTypeHTTP BackendType = "http"
-	BackendTypeS3   BackendType = "s3"
+	BackendTypeHTTP   BackendType = "http"
+	BackendTypeS3     BackendType = "s3"
+	BackendTypeDir    BackendType = "dir"
+	BackendTypeWebDAV BackendType = "webdav"
+	BackendTypeGit    BackendType = "git"
 )
+
+// ProgressFunc is called by backends that support it as Bootstrap downloads objects, with the number of
+// objects downloaded so far and the total number of objects to download.
+type ProgressFunc func(done, total int)
+
+type progressCtxKey struct{}
+
+// WithProgress returns a context that makes backends report Bootstrap progress to fn.
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressCtxKey{}, fn)
+}
+
+// progressFromContext returns the ProgressFunc set by WithProgress, or nil.
+func progressFromContext(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressCtxKey{}).(ProgressFunc)
+	return fn
+}
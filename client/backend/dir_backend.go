@@ -0,0 +1,375 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+const (
+	// How long to wait for another device to release the lock on devices.json
+	dirLockTimeout = 10 * time.Second
+	// Locks older than this were left behind by a device that crashed while holding the lock
+	dirStaleLockAge     = time.Minute
+	dirLockPollInterval = 50 * time.Millisecond
+)
+
+// DirConfig holds configuration for the directory backend.
+type DirConfig struct {
+	// Path is the absolute path of the shared directory (required)
+	Path string `json:"path"`
+}
+
+// Validate checks that required fields are set.
+func (c *DirConfig) Validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("sync directory path is required")
+	}
+	if !filepath.IsAbs(c.Path) {
+		return fmt.Errorf("sync directory path %q must be absolute", c.Path)
+	}
+	return nil
+}
+
+// DirBackend implements SyncBackend by storing data in a plain directory that is shared between devices
+// (e.g. a Syncthing folder, an NFS mount, or a Dropbox folder). It uses the same layout as S3Backend (see
+// objectLayout), with files in place of objects. Files are written to a temporary file and then renamed into
+// place so that other devices never read a partially written file, and updates to devices.json are serialized
+// with a lock file.
+type DirBackend struct {
+	path   string // the shared directory
+	userId string // derived from user secret, used as folder name
+	layout objectLayout
+}
+
+// NewDirBackend creates a new directory backend with the given configuration.
+func NewDirBackend(cfg *DirConfig, userId string) (*DirBackend, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid dir config: %w", err)
+	}
+	b := &DirBackend{
+		path:   filepath.Clean(cfg.Path),
+		userId: userId,
+	}
+	b.layout = objectLayout{store: b, name: "DirBackend"}
+	return b, nil
+}
+
+// Type returns "dir" to identify this backend type.
+func (b *DirBackend) Type() string {
+	return string(BackendTypeDir)
+}
+
+// file builds a file path from parts, rooted at the user's folder within the shared directory.
+func (b *DirBackend) file(parts ...string) string {
+	return filepath.Join(append([]string{b.path, b.userId}, parts...)...)
+}
+
+// RegisterDevice registers a new device for the user.
+func (b *DirBackend) RegisterDevice(ctx context.Context, userId, deviceId string) error {
+	existingDeviceCount := 0
+	alreadyRegistered := false
+	err := b.withDevicesLock(ctx, func() error {
+		devices, err := b.getDevices()
+		if err != nil {
+			return fmt.Errorf("failed to get devices: %w", err)
+		}
+		existingDeviceCount = len(devices.Devices)
+		for _, d := range devices.Devices {
+			if d.DeviceId == deviceId {
+				alreadyRegistered = true
+				return nil
+			}
+		}
+		devices.Devices = append(devices.Devices, DeviceInfo{
+			DeviceId:         deviceId,
+			UserId:           userId,
+			RegistrationDate: time.Now().UTC().Format(time.RFC3339),
+		})
+		if err := b.putDevices(devices); err != nil {
+			return fmt.Errorf("failed to save devices: %w", err)
+		}
+		return nil
+	})
+	if err != nil || alreadyRegistered {
+		return err
+	}
+
+	// If there are existing devices, create a dump request so they send history to the new device
+	if existingDeviceCount > 0 {
+		return b.layout.createDumpRequest(ctx, userId, deviceId)
+	}
+	return nil
+}
+
+// Bootstrap returns all history entries for a user.
+func (b *DirBackend) Bootstrap(ctx context.Context, _, _ string) ([]*shared.EncHistoryEntry, error) {
+	return b.layout.bootstrap(ctx)
+}
+
+// SubmitEntries submits new encrypted history entries.
+func (b *DirBackend) SubmitEntries(ctx context.Context, entries []*shared.EncHistoryEntry, sourceDeviceId string) (*shared.SubmitResponse, error) {
+	if len(entries) == 0 {
+		return &shared.SubmitResponse{}, nil
+	}
+	if err := b.submitEntries(ctx, entries, sourceDeviceId); err != nil {
+		return nil, err
+	}
+
+	// Check for pending dump requests and deletion requests for source device
+	resp := &shared.SubmitResponse{}
+	dumpReqs, err := b.layout.getDumpRequests(ctx, sourceDeviceId)
+	if err == nil {
+		resp.DumpRequests = dumpReqs
+	}
+	delReqs, err := b.GetDeletionRequests(ctx, b.userId, sourceDeviceId)
+	if err == nil {
+		resp.DeletionRequests = delReqs
+	}
+	return resp, nil
+}
+
+// submitEntries writes entries for all registered devices without reading anything back for sourceDeviceId.
+func (b *DirBackend) submitEntries(ctx context.Context, entries []*shared.EncHistoryEntry, sourceDeviceId string) error {
+	deviceList, err := b.getDevices()
+	if err != nil {
+		return fmt.Errorf("failed to get devices: %w", err)
+	}
+	return b.layout.submitEntries(ctx, deviceList.Devices, entries, sourceDeviceId)
+}
+
+// SubmitDump handles bulk transfer of entries to a requesting device.
+func (b *DirBackend) SubmitDump(ctx context.Context, entries []*shared.EncHistoryEntry, _, requestingDeviceId, _ string) error {
+	return b.layout.submitDump(ctx, entries, requestingDeviceId)
+}
+
+// QueryEntries retrieves new entries for a device. Like S3Backend, entries are kept until they have been
+// read readCountLimit times.
+func (b *DirBackend) QueryEntries(ctx context.Context, deviceId, _, _ string) ([]*shared.EncHistoryEntry, error) {
+	return b.layout.queryEntries(ctx, deviceId)
+}
+
+// GetDeletionRequests returns pending deletion requests for a device. Like S3Backend, requests are kept until
+// they have been read readCountLimit times.
+func (b *DirBackend) GetDeletionRequests(ctx context.Context, _, deviceId string) ([]*shared.DeletionRequest, error) {
+	return b.layout.getDeletionRequests(ctx, deviceId)
+}
+
+// AddDeletionRequest adds a deletion request to be propagated to all devices.
+func (b *DirBackend) AddDeletionRequest(ctx context.Context, request shared.DeletionRequest) error {
+	deviceList, err := b.getDevices()
+	if err != nil {
+		return fmt.Errorf("failed to get devices: %w", err)
+	}
+	return b.layout.addDeletionRequest(ctx, deviceList.Devices, request)
+}
+
+// Uninstall removes a device and its pending data.
+func (b *DirBackend) Uninstall(ctx context.Context, _, deviceId string) error {
+	err := b.withDevicesLock(ctx, func() error {
+		deviceList, err := b.getDevices()
+		if err != nil {
+			return err
+		}
+		newDevices := make([]DeviceInfo, 0, len(deviceList.Devices))
+		for _, d := range deviceList.Devices {
+			if d.DeviceId != deviceId {
+				newDevices = append(newDevices, d)
+			}
+		}
+		deviceList.Devices = newDevices
+		return b.putDevices(deviceList)
+	})
+	if err != nil {
+		return err
+	}
+	b.layout.removeDevice(ctx, deviceId)
+	return nil
+}
+
+// Ping checks that the shared directory exists, e.g. that the network drive is mounted.
+func (b *DirBackend) Ping(_ context.Context) error {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", b.path)
+	}
+	return nil
+}
+
+// Helper methods for file operations
+
+func (b *DirBackend) getDevices() (*DeviceList, error) {
+	var devices DeviceList
+	err := b.readJson(b.file("devices.json"), &devices)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &DeviceList{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &devices, nil
+}
+
+func (b *DirBackend) putDevices(devices *DeviceList) error {
+	return b.writeJson(b.file("devices.json"), devices)
+}
+
+// withDevicesLock runs fn while holding the lock file for devices.json, so that devices registering or
+// uninstalling at the same time don't overwrite each other's changes.
+func (b *DirBackend) withDevicesLock(ctx context.Context, fn func() error) error {
+	lockPath := b.file("devices.json.lock")
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0o700); err != nil {
+		return fmt.Errorf("failed to create sync directory: %w", err)
+	}
+	return withLockFile(ctx, lockPath, dirLockTimeout, dirStaleLockAge, fn)
+}
+
+// withLockFile runs fn while holding the given lock file, which is created exclusively so that it also works
+// across devices on network filesystems. Lock files older than staleAge are assumed to have been left behind
+// by a process that crashed while holding the lock.
+func withLockFile(ctx context.Context, lockPath string, timeout, staleAge time.Duration, fn func() error) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			hostname, _ := os.Hostname()
+			_, _ = fmt.Fprintf(f, "%s %d\n", hostname, os.Getpid())
+			f.Close()
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("failed to create lock file: %w", err)
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleAge {
+			hctx.GetLogger().Warnf("removing stale lock file %s", lockPath)
+			_ = os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for lock file %s (if no other device is using it, it is safe to delete it)", lockPath)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(dirLockPollInterval):
+		}
+	}
+	defer os.Remove(lockPath)
+	return fn()
+}
+
+func (b *DirBackend) readJson(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", path, err)
+	}
+	return nil
+}
+
+func (b *DirBackend) writeJson(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
+	}
+	return b.writeFile(path, data)
+}
+
+// writeFile atomically writes data to path by writing it to a temporary file in the same directory and then
+// renaming it into place.
+func (b *DirBackend) writeFile(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	// The temporary file starts with a dot so that it is skipped by listFiles
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (b *DirBackend) removeFile(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// objectStore implementation, where keys are files within the user's folder
+
+func (b *DirBackend) getObject(_ context.Context, key string) ([]byte, error) {
+	return os.ReadFile(b.file(filepath.FromSlash(key)))
+}
+
+func (b *DirBackend) putObject(_ context.Context, key string, data []byte) error {
+	return b.writeFile(b.file(filepath.FromSlash(key)), data)
+}
+
+func (b *DirBackend) listObjects(_ context.Context, prefix string) ([]string, error) {
+	files, err := b.listFiles(b.file(filepath.FromSlash(prefix)))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(files))
+	for _, f := range files {
+		rel, err := filepath.Rel(b.file(), f)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+	}
+	return keys, nil
+}
+
+func (b *DirBackend) deleteObject(_ context.Context, key string) error {
+	return os.RemoveAll(b.file(filepath.FromSlash(key)))
+}
+
+// listFiles recursively lists the JSON files within dir. Hidden files are skipped since they are either
+// in-progress writes (from writeJson or from tools like Syncthing) or otherwise not ours.
+func (b *DirBackend) listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || !strings.HasSuffix(d.Name(), ".json") {
+			return nil
+		}
+		files = append(files, p)
+		return nil
+	})
+	return files, err
+}
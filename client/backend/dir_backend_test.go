@@ -0,0 +1,237 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDirBackend(t *testing.T) *DirBackend {
+	b, err := NewDirBackend(&DirConfig{Path: t.TempDir()}, "user123")
+	require.NoError(t, err)
+	return b
+}
+
+func TestDirBackendType(t *testing.T) {
+	b := newTestDirBackend(t)
+	assert.Equal(t, "dir", b.Type())
+}
+
+func TestDirConfigValidate(t *testing.T) {
+	require.NoError(t, (&DirConfig{Path: "/mnt/shared"}).Validate())
+	require.ErrorContains(t, (&DirConfig{}).Validate(), "path is required")
+	require.ErrorContains(t, (&DirConfig{Path: "relative/dir"}).Validate(), "must be absolute")
+}
+
+func TestDirBackendRegisterDevice(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("second device creates dump request", func(t *testing.T) {
+		b := newTestDirBackend(t)
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+
+		devices, err := b.getDevices()
+		require.NoError(t, err)
+		assert.Len(t, devices.Devices, 2)
+
+		dumpRequests, err := b.layout.getDumpRequests(ctx, "device1")
+		require.NoError(t, err)
+		require.Len(t, dumpRequests, 1)
+		assert.Equal(t, "device2", dumpRequests[0].RequestingDeviceId)
+
+		// The lock file is released
+		_, err = os.Stat(b.file("devices.json.lock"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("re-registering same device is idempotent", func(t *testing.T) {
+		b := newTestDirBackend(t)
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+
+		devices, err := b.getDevices()
+		require.NoError(t, err)
+		assert.Len(t, devices.Devices, 1)
+	})
+
+	t.Run("concurrent registrations are not lost", func(t *testing.T) {
+		b := newTestDirBackend(t)
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- b.RegisterDevice(ctx, "user123", fmt.Sprintf("device%d", i))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		devices, err := b.getDevices()
+		require.NoError(t, err)
+		assert.Len(t, devices.Devices, 10)
+	})
+
+	t.Run("stale lock is removed", func(t *testing.T) {
+		b := newTestDirBackend(t)
+		lockPath := b.file("devices.json.lock")
+		require.NoError(t, os.MkdirAll(filepath.Dir(lockPath), 0o700))
+		require.NoError(t, os.WriteFile(lockPath, nil, 0o600))
+		staleTime := time.Now().Add(-2 * dirStaleLockAge)
+		require.NoError(t, os.Chtimes(lockPath, staleTime, staleTime))
+
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	})
+
+	t.Run("held lock blocks until cancelled", func(t *testing.T) {
+		b := newTestDirBackend(t)
+		lockPath := b.file("devices.json.lock")
+		require.NoError(t, os.MkdirAll(filepath.Dir(lockPath), 0o700))
+		require.NoError(t, os.WriteFile(lockPath, nil, 0o600))
+
+		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, b.RegisterDevice(ctx, "user123", "device1"), context.DeadlineExceeded)
+	})
+}
+
+func TestDirBackendSubmitAndQueryEntries(t *testing.T) {
+	ctx := context.Background()
+	b := newTestDirBackend(t)
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+
+	entries := []*shared.EncHistoryEntry{
+		{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()},
+		{EncryptedId: "entry2", DeviceId: "device1", Date: time.Now()},
+	}
+	resp, err := b.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+	require.Len(t, resp.DumpRequests, 1)
+
+	// Writes don't leave temporary files behind
+	tmpFiles, err := filepath.Glob(filepath.Join(b.file("inbox", "device2"), ".tmp-*"))
+	require.NoError(t, err)
+	assert.Empty(t, tmpFiles)
+
+	// Only the other device receives the entries
+	device1Entries, err := b.QueryEntries(ctx, "device1", "user123", "test")
+	require.NoError(t, err)
+	assert.Empty(t, device1Entries)
+
+	// Entries are kept until they have been read readCountLimit times
+	for i := 1; i <= readCountLimit; i++ {
+		device2Entries, err := b.QueryEntries(ctx, "device2", "user123", "test")
+		require.NoError(t, err)
+		require.Len(t, device2Entries, 2)
+		assert.Equal(t, "device2", device2Entries[0].DeviceId)
+		assert.Equal(t, i, device2Entries[0].ReadCount)
+	}
+	device2Entries, err := b.QueryEntries(ctx, "device2", "user123", "test")
+	require.NoError(t, err)
+	assert.Empty(t, device2Entries)
+
+	// Bootstrap returns all entries
+	bootstrapped, err := b.Bootstrap(ctx, "user123", "device3")
+	require.NoError(t, err)
+	assert.Len(t, bootstrapped, 2)
+}
+
+func TestDirBackendSubmitDump(t *testing.T) {
+	ctx := context.Background()
+	b := newTestDirBackend(t)
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+
+	entries := []*shared.EncHistoryEntry{{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()}}
+	require.NoError(t, b.SubmitDump(ctx, entries, "user123", "device2", "device1"))
+
+	dumpRequests, err := b.layout.getDumpRequests(ctx, "device1")
+	require.NoError(t, err)
+	assert.Empty(t, dumpRequests)
+	device2Entries, err := b.QueryEntries(ctx, "device2", "user123", "test")
+	require.NoError(t, err)
+	require.Len(t, device2Entries, 1)
+	assert.Equal(t, "entry1", device2Entries[0].EncryptedId)
+}
+
+func TestDirBackendAddDeletionRequest(t *testing.T) {
+	ctx := context.Background()
+	b := newTestDirBackend(t)
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+	entries := []*shared.EncHistoryEntry{
+		{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()},
+		{EncryptedId: "entry2", DeviceId: "device1", Date: time.Now()},
+	}
+	_, err := b.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+
+	delReq := shared.DeletionRequest{
+		UserId:   "user123",
+		Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{EntryId: "entry1"}}},
+	}
+	require.NoError(t, b.AddDeletionRequest(ctx, delReq))
+
+	// The deletion request is fanned out to all devices
+	for _, deviceId := range []string{"device1", "device2"} {
+		reqs, err := b.GetDeletionRequests(ctx, "user123", deviceId)
+		require.NoError(t, err)
+		require.Len(t, reqs, 1)
+		assert.Equal(t, deviceId, reqs[0].DestinationDeviceId)
+	}
+
+	// And the entry is removed from the entries store and the inboxes
+	bootstrapped, err := b.Bootstrap(ctx, "user123", "device3")
+	require.NoError(t, err)
+	require.Len(t, bootstrapped, 1)
+	assert.Equal(t, "entry2", bootstrapped[0].EncryptedId)
+	device2Entries, err := b.QueryEntries(ctx, "device2", "user123", "test")
+	require.NoError(t, err)
+	require.Len(t, device2Entries, 1)
+	assert.Equal(t, "entry2", device2Entries[0].EncryptedId)
+}
+
+func TestDirBackendUninstall(t *testing.T) {
+	ctx := context.Background()
+	b := newTestDirBackend(t)
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+	_, err := b.SubmitEntries(ctx, []*shared.EncHistoryEntry{{EncryptedId: "entry1", Date: time.Now()}}, "device1")
+	require.NoError(t, err)
+
+	require.NoError(t, b.Uninstall(ctx, "user123", "device2"))
+
+	devices, err := b.getDevices()
+	require.NoError(t, err)
+	require.Len(t, devices.Devices, 1)
+	assert.Equal(t, "device1", devices.Devices[0].DeviceId)
+	_, err = os.Stat(b.file("inbox", "device2"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	dumpRequests, err := b.layout.getDumpRequests(ctx, "device1")
+	require.NoError(t, err)
+	assert.Empty(t, dumpRequests)
+}
+
+func TestDirBackendPing(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, newTestDirBackend(t).Ping(ctx))
+
+	b, err := NewDirBackend(&DirConfig{Path: filepath.Join(t.TempDir(), "unmounted")}, "user123")
+	require.NoError(t, err)
+	require.Error(t, b.Ping(ctx))
+}
@@ -11,7 +11,7 @@ import (
 
 // Config holds the configuration needed to create a backend.
 type Config struct {
-	// BackendType is either "http" (default) or "s3"
+	// BackendType is "http" (default), "s3", "dir", "webdav", or "git"
 	BackendType string
 
 	// Version is the client version for HTTP headers (from lib.Version, can't be grabbed at runtime due to circular import)
@@ -23,14 +23,37 @@ type Config struct {
 	S3Endpoint  string
 	S3AccessKey string
 	S3Prefix    string
+	// S3Concurrency is the maximum number of parallel requests, or 0 for the default
+	S3Concurrency int
+	// S3Compression is the content encoding to compress uploaded objects with, or "" for no compression
+	S3Compression string
+
+	// DirPath is the shared directory (only used when BackendType is "dir")
+	DirPath string
+
+	// WebDAV configuration (only used when BackendType is "webdav")
+	WebDAVURL      string
+	WebDAVUsername string
+
+	// Git configuration (only used when BackendType is "git")
+	GitRemote string
+	GitBranch string
+	// GitClonePath is where the local clone of the repository is kept
+	GitClonePath string
 
 	// HTTPClient is the HTTP client to use for HTTP backends (required for offline builds)
 	HTTPClient *http.Client
+	// HTTPRequestEncoding is the content encoding that the hishtory server last advertised support for, or "" if
+	// it isn't known to support compressed request bodies
+	HTTPRequestEncoding string
 }
 
 // NewBackendFromConfig creates the appropriate sync backend based on configuration.
 // If BackendType is empty or "http", creates an HTTPBackend.
 // If BackendType is "s3", creates an S3Backend.
+// If BackendType is "dir", creates a DirBackend.
+// If BackendType is "webdav", creates a WebDAVBackend.
+// If BackendType is "git", creates a GitBackend.
 func NewBackendFromConfig(ctx context.Context, cfg Config) (SyncBackend, error) {
 	// Get userId and deviceId from context
 	conf := hctx.GetConf(ctx)
@@ -40,20 +63,38 @@ func NewBackendFromConfig(ctx context.Context, cfg Config) (SyncBackend, error)
 	switch BackendType(cfg.BackendType) {
 	case BackendTypeS3:
 		s3cfg := &S3Config{
-			Bucket:      cfg.S3Bucket,
-			Region:      cfg.S3Region,
-			Endpoint:    cfg.S3Endpoint,
-			AccessKeyID: cfg.S3AccessKey,
-			Prefix:      cfg.S3Prefix,
+			Bucket:        cfg.S3Bucket,
+			Region:        cfg.S3Region,
+			Endpoint:      cfg.S3Endpoint,
+			AccessKeyID:   cfg.S3AccessKey,
+			Prefix:        cfg.S3Prefix,
+			Concurrency:   cfg.S3Concurrency,
+			Compression:   cfg.S3Compression,
+			ClientVersion: "v0." + cfg.Version,
 			// SecretAccessKey is loaded from environment by S3Config.Validate()
 		}
 		return NewS3Backend(ctx, s3cfg, userId)
 
+	case BackendTypeDir:
+		return NewDirBackend(&DirConfig{Path: cfg.DirPath}, userId)
+
+	case BackendTypeWebDAV:
+		webdavCfg := &WebDAVConfig{
+			URL:      cfg.WebDAVURL,
+			Username: cfg.WebDAVUsername,
+			// Password is loaded from environment by WebDAVConfig.Validate()
+		}
+		return NewWebDAVBackend(webdavCfg, userId, cfg.HTTPClient)
+
+	case BackendTypeGit:
+		return NewGitBackend(&GitConfig{Remote: cfg.GitRemote, Branch: cfg.GitBranch}, userId, cfg.GitClonePath)
+
 	case BackendTypeHTTP, "":
 		// Default to HTTP backend
 		opts := []HTTPBackendOption{
 			WithVersion(cfg.Version),
 			WithAuth(deviceId, userId),
+			WithRequestEncoding(cfg.HTTPRequestEncoding),
 		}
 		if cfg.HTTPClient != nil {
 			opts = append(opts, WithHTTPClient(cfg.HTTPClient))
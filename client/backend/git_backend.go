@@ -0,0 +1,515 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+const (
+	// The number of times to retry pushing when another device pushed concurrently
+	gitMaxPushAttempts = 10
+	// How long to wait for another hishtory process on this device to finish using the local clone
+	gitLockTimeout = 30 * time.Second
+	// Git operations can be slow, so locks on the local clone are only considered stale after a long time
+	gitStaleLockAge = 10 * time.Minute
+	// How long a single git command may take, so that an unresponsive remote doesn't hang the shell hooks
+	gitCommandTimeout = 2 * time.Minute
+	gitDefaultBranch  = "main"
+	gitCommitAuthor   = "hishtory"
+)
+
+// GitConfig holds configuration for the git backend.
+type GitConfig struct {
+	// Remote is the URL or path of the git repository (required). Anything that the git binary can push to
+	// works, including local bare repositories and SSH remotes.
+	Remote string `json:"remote"`
+
+	// Branch is the branch to store history on (optional, defaults to "main")
+	Branch string `json:"branch,omitempty"`
+}
+
+// Validate checks that required fields are set.
+func (c *GitConfig) Validate() error {
+	if c.Remote == "" {
+		return fmt.Errorf("git remote is required")
+	}
+	if c.Branch == "" {
+		c.Branch = gitDefaultBranch
+	}
+	return nil
+}
+
+// GitBackend implements SyncBackend by storing data as files in a git repository. It keeps a local clone
+// using the same layout as DirBackend, and every operation that modifies it is committed and pushed. Reads
+// only pull, with read counts tracked locally. When
+// another device pushed first, the commit is rebased on top of theirs, which succeeds since almost every file
+// is only ever created. If the rebase does conflict (e.g. on devices.json), the operation is re-applied from
+// scratch on top of the latest remote state.
+type GitBackend struct {
+	remote    string
+	branch    string
+	clonePath string
+	dir       *DirBackend
+}
+
+// NewGitBackend creates a new git backend with the given configuration, which keeps its local clone in clonePath.
+func NewGitBackend(cfg *GitConfig, userId, clonePath string) (*GitBackend, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid git config: %w", err)
+	}
+	if clonePath == "" || !filepath.IsAbs(clonePath) {
+		return nil, fmt.Errorf("git clone path %q must be absolute", clonePath)
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("the git backend requires git to be installed: %w", err)
+	}
+	dir, err := NewDirBackend(&DirConfig{Path: clonePath}, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &GitBackend{
+		remote:    cfg.Remote,
+		branch:    cfg.Branch,
+		clonePath: filepath.Clean(clonePath),
+		dir:       dir,
+	}, nil
+}
+
+// Type returns "git" to identify this backend type.
+func (b *GitBackend) Type() string {
+	return string(BackendTypeGit)
+}
+
+// RegisterDevice registers a new device for the user.
+func (b *GitBackend) RegisterDevice(ctx context.Context, userId, deviceId string) error {
+	return b.update(ctx, "Register device "+deviceId, func() error {
+		return b.dir.RegisterDevice(ctx, userId, deviceId)
+	})
+}
+
+// Bootstrap returns all history entries for a user.
+func (b *GitBackend) Bootstrap(ctx context.Context, userId, deviceId string) ([]*shared.EncHistoryEntry, error) {
+	var entries []*shared.EncHistoryEntry
+	err := b.withClone(ctx, func() error {
+		if err := b.pull(ctx); err != nil {
+			return err
+		}
+		var err error
+		entries, err = b.dir.Bootstrap(ctx, userId, deviceId)
+		return err
+	})
+	return entries, err
+}
+
+// SubmitEntries submits new encrypted history entries.
+func (b *GitBackend) SubmitEntries(ctx context.Context, entries []*shared.EncHistoryEntry, sourceDeviceId string) (*shared.SubmitResponse, error) {
+	if len(entries) == 0 {
+		return &shared.SubmitResponse{}, nil
+	}
+	err := b.update(ctx, fmt.Sprintf("Submit %d entries from %s", len(entries), sourceDeviceId), func() error {
+		return b.dir.submitEntries(ctx, entries, sourceDeviceId)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Check for pending dump requests and deletion requests for source device. Like GetDeletionRequests, this
+	// only tracks read counts locally so that nothing else is committed.
+	resp := &shared.SubmitResponse{}
+	err = b.withClone(ctx, func() error {
+		dumpReqs, err := b.dir.layout.getDumpRequests(ctx, sourceDeviceId)
+		if err == nil {
+			resp.DumpRequests = dumpReqs
+		}
+		delReqs, err := b.readDeletionRequests(sourceDeviceId)
+		if err == nil {
+			resp.DeletionRequests = delReqs
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// SubmitDump handles bulk transfer of entries to a requesting device.
+func (b *GitBackend) SubmitDump(ctx context.Context, entries []*shared.EncHistoryEntry, userId, requestingDeviceId, sourceDeviceId string) error {
+	return b.update(ctx, "Submit dump for "+requestingDeviceId, func() error {
+		return b.dir.SubmitDump(ctx, entries, userId, requestingDeviceId, sourceDeviceId)
+	})
+}
+
+// QueryEntries retrieves new entries for a device. Queries only pull from the remote, and read counts are kept
+// in the local readCounts file so that they don't need to be committed and pushed.
+func (b *GitBackend) QueryEntries(ctx context.Context, deviceId, userId, queryReason string) ([]*shared.EncHistoryEntry, error) {
+	var entries []*shared.EncHistoryEntry
+	err := b.withClone(ctx, func() error {
+		if err := b.pull(ctx); err != nil {
+			return err
+		}
+		return b.readUnread(b.dir.file("inbox", deviceId), func(f string, readCount int) error {
+			var entry shared.EncHistoryEntry
+			if err := b.dir.readJson(f, &entry); err != nil {
+				return err
+			}
+			entry.ReadCount = readCount
+			entries = append(entries, &entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// GetDeletionRequests returns pending deletion requests for a device. Like QueryEntries, this doesn't modify the
+// remote.
+func (b *GitBackend) GetDeletionRequests(ctx context.Context, userId, deviceId string) ([]*shared.DeletionRequest, error) {
+	var requests []*shared.DeletionRequest
+	err := b.withClone(ctx, func() error {
+		if err := b.pull(ctx); err != nil {
+			return err
+		}
+		var err error
+		requests, err = b.readDeletionRequests(deviceId)
+		return err
+	})
+	return requests, err
+}
+
+// readDeletionRequests returns the unread deletion requests for a device from the local clone
+func (b *GitBackend) readDeletionRequests(deviceId string) ([]*shared.DeletionRequest, error) {
+	var requests []*shared.DeletionRequest
+	err := b.readUnread(b.dir.file("deletions", deviceId), func(f string, readCount int) error {
+		var req shared.DeletionRequest
+		if err := b.dir.readJson(f, &req); err != nil {
+			return err
+		}
+		req.ReadCount = readCount
+		requests = append(requests, &req)
+		return nil
+	})
+	return requests, err
+}
+
+// AddDeletionRequest adds a deletion request to be propagated to all devices.
+func (b *GitBackend) AddDeletionRequest(ctx context.Context, request shared.DeletionRequest) error {
+	return b.update(ctx, "Add deletion request", func() error {
+		return b.dir.AddDeletionRequest(ctx, request)
+	})
+}
+
+// Uninstall removes a device and its pending data.
+func (b *GitBackend) Uninstall(ctx context.Context, userId, deviceId string) error {
+	return b.update(ctx, "Uninstall device "+deviceId, func() error {
+		return b.dir.Uninstall(ctx, userId, deviceId)
+	})
+}
+
+// Ping checks that the remote repository is reachable.
+func (b *GitBackend) Ping(ctx context.Context) error {
+	_, err := runGit(ctx, "", "ls-remote", "--heads", b.remote)
+	return err
+}
+
+// Helper methods for git operations
+
+// withClone runs fn while holding the lock on the local clone, creating the clone if it doesn't exist yet.
+func (b *GitBackend) withClone(ctx context.Context, fn func() error) error {
+	if err := os.MkdirAll(filepath.Dir(b.clonePath), 0o700); err != nil {
+		return fmt.Errorf("failed to create directory for git clone: %w", err)
+	}
+	return withLockFile(ctx, b.clonePath+".lock", gitLockTimeout, gitStaleLockAge, func() error {
+		if err := b.ensureClone(ctx); err != nil {
+			return fmt.Errorf("failed to create git clone: %w", err)
+		}
+		return fn()
+	})
+}
+
+func (b *GitBackend) ensureClone(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Join(b.clonePath, ".git")); err == nil {
+		return nil
+	}
+	// Use init rather than clone so that empty remotes are handled in the same way as any other remote
+	if _, err := runGit(ctx, "", "init", "--quiet", b.clonePath); err != nil {
+		return err
+	}
+	if _, err := b.git(ctx, "symbolic-ref", "HEAD", "refs/heads/"+b.branch); err != nil {
+		return err
+	}
+	_, err := b.git(ctx, "remote", "add", "origin", b.remote)
+	return err
+}
+
+// update applies op to the local clone and then commits and pushes the result
+func (b *GitBackend) update(ctx context.Context, message string, op func() error) error {
+	return b.withClone(ctx, func() error {
+		if err := b.pull(ctx); err != nil {
+			return err
+		}
+		// Files that have been fully read are removed along with this update rather than in commits of their own
+		op := func() error {
+			if err := op(); err != nil {
+				return err
+			}
+			return b.removeReadFiles()
+		}
+		if err := b.applyAndCommit(ctx, message, op); err != nil {
+			return err
+		}
+		for attempt := 1; ; attempt++ {
+			if ahead, err := b.isAheadOfRemote(ctx); err != nil || !ahead {
+				return err
+			}
+			pushErr := b.push(ctx)
+			if pushErr == nil {
+				return nil
+			}
+			if attempt >= gitMaxPushAttempts {
+				return pushErr
+			}
+			// Back off with jitter so that devices pushing at the same time don't keep colliding
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt*(10+rand.Intn(100))) * time.Millisecond):
+			}
+			// The push was most likely rejected because another device pushed first, so rebase on top of it
+			if err := b.fetch(ctx); err != nil {
+				// The remote is unreachable. The commit stays in the local clone and is pushed with the next update.
+				return pushErr
+			}
+			if err := b.rebase(ctx); err != nil {
+				hctx.GetLogger().Infof("GitBackend: rebase failed, re-applying %q on top of the remote: %v", message, err)
+				if err := b.resetToRemote(ctx); err != nil {
+					return err
+				}
+				if err := b.applyAndCommit(ctx, message, op); err != nil {
+					return err
+				}
+			}
+		}
+	})
+}
+
+// readCountsPath is where read counts for this device's inbox entries and deletion requests are stored. It is
+// next to the local clone rather than inside it so that it is never committed.
+func (b *GitBackend) readCountsPath() string {
+	return b.clonePath + ".readcounts.json"
+}
+
+// getReadCounts returns how many times each file in the local clone has been read, keyed by its path relative to
+// the clone
+func (b *GitBackend) getReadCounts() (map[string]int, error) {
+	counts := make(map[string]int)
+	if err := b.dir.readJson(b.readCountsPath(), &counts); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// readUnread calls fn with each file in dir that has been read fewer than readCountLimit times, along with its
+// updated read count
+func (b *GitBackend) readUnread(dir string, fn func(f string, readCount int) error) error {
+	files, err := b.dir.listFiles(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	counts, err := b.getReadCounts()
+	if err != nil {
+		return fmt.Errorf("failed to read git read counts: %w", err)
+	}
+	// Forget about files in dir that no longer exist so that the read counts don't grow forever
+	relDir, err := filepath.Rel(b.clonePath, dir)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool)
+	for _, f := range files {
+		rel, err := filepath.Rel(b.clonePath, f)
+		if err != nil {
+			return err
+		}
+		present[rel] = true
+		if counts[rel] >= readCountLimit {
+			continue
+		}
+		if err := fn(f, counts[rel]+1); err != nil {
+			hctx.GetLogger().Warnf("GitBackend: failed to read %s: %v", f, err)
+			continue
+		}
+		counts[rel]++
+	}
+	for rel := range counts {
+		if strings.HasPrefix(rel, relDir+string(filepath.Separator)) && !present[rel] {
+			delete(counts, rel)
+		}
+	}
+	return b.dir.writeJson(b.readCountsPath(), counts)
+}
+
+// removeReadFiles deletes the files in the local clone that have been read readCountLimit times
+func (b *GitBackend) removeReadFiles() error {
+	counts, err := b.getReadCounts()
+	if err != nil {
+		return fmt.Errorf("failed to read git read counts: %w", err)
+	}
+	for rel, count := range counts {
+		if count < readCountLimit {
+			continue
+		}
+		if err := b.dir.removeFile(filepath.Join(b.clonePath, rel)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *GitBackend) applyAndCommit(ctx context.Context, message string, op func() error) error {
+	if err := op(); err != nil {
+		// Discard any partially applied changes
+		_, _ = b.git(ctx, "reset", "--hard", "--quiet")
+		_, _ = b.git(ctx, "clean", "-fdq")
+		return err
+	}
+	if _, err := b.git(ctx, "add", "--all"); err != nil {
+		return err
+	}
+	status, err := b.git(ctx, "status", "--porcelain")
+	if err != nil || status == "" {
+		return err
+	}
+	_, err = b.git(ctx, "commit", "--quiet", "--no-verify", "--no-gpg-sign", "-m", message)
+	return err
+}
+
+// pull brings the local clone up to date with the remote, rebasing any local commits that haven't been pushed
+// yet. If they conflict, they are dropped since they would have been retried anyway.
+func (b *GitBackend) pull(ctx context.Context) error {
+	if err := b.fetch(ctx); err != nil {
+		return err
+	}
+	if err := b.rebase(ctx); err != nil {
+		hctx.GetLogger().Warnf("GitBackend: dropping unpushed local commits that conflict with the remote: %v", err)
+		return b.resetToRemote(ctx)
+	}
+	return nil
+}
+
+func (b *GitBackend) fetch(ctx context.Context) error {
+	_, err := b.git(ctx, "fetch", "--quiet", "origin")
+	return err
+}
+
+func (b *GitBackend) remoteRef() string {
+	return "refs/remotes/origin/" + b.branch
+}
+
+func (b *GitBackend) hasRemoteBranch(ctx context.Context) bool {
+	_, err := b.git(ctx, "rev-parse", "--verify", "--quiet", b.remoteRef())
+	return err == nil
+}
+
+func (b *GitBackend) hasLocalCommits(ctx context.Context) bool {
+	_, err := b.git(ctx, "rev-parse", "--verify", "--quiet", "HEAD")
+	return err == nil
+}
+
+func (b *GitBackend) rebase(ctx context.Context) error {
+	if !b.hasRemoteBranch(ctx) {
+		// Nothing has been pushed to the remote yet
+		return nil
+	}
+	if !b.hasLocalCommits(ctx) {
+		return b.resetToRemote(ctx)
+	}
+	_, err := b.git(ctx, "rebase", "--quiet", "--no-autostash", b.remoteRef())
+	if err != nil {
+		_, _ = b.git(ctx, "rebase", "--abort")
+	}
+	return err
+}
+
+func (b *GitBackend) resetToRemote(ctx context.Context) error {
+	if !b.hasRemoteBranch(ctx) {
+		return nil
+	}
+	if _, err := b.git(ctx, "reset", "--hard", "--quiet", b.remoteRef()); err != nil {
+		return err
+	}
+	_, err := b.git(ctx, "clean", "-fdq")
+	return err
+}
+
+func (b *GitBackend) isAheadOfRemote(ctx context.Context) (bool, error) {
+	if !b.hasLocalCommits(ctx) {
+		return false, nil
+	}
+	if !b.hasRemoteBranch(ctx) {
+		return true, nil
+	}
+	count, err := b.git(ctx, "rev-list", "--count", b.remoteRef()+"..HEAD")
+	if err != nil {
+		return false, err
+	}
+	return count != "0", nil
+}
+
+func (b *GitBackend) push(ctx context.Context) error {
+	_, err := b.git(ctx, "push", "--quiet", "origin", "HEAD:refs/heads/"+b.branch)
+	return err
+}
+
+func (b *GitBackend) git(ctx context.Context, args ...string) (string, error) {
+	return runGit(ctx, b.clonePath, args...)
+}
+
+// runGit runs git non-interactively in dir and returns its trimmed stdout. Commands that take longer than
+// gitCommandTimeout are killed.
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	// Don't wait forever for subprocesses (e.g. ssh) that still hold the output pipes after git is killed
+	cmd.WaitDelay = time.Second
+	cmd.Env = gitEnv()
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("git %s didn't finish: %w", args[0], ctx.Err())
+		}
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return "", fmt.Errorf("failed to run git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// gitEnv returns the environment to run git with. Git fails rather than prompting for credentials, since it runs
+// in the background of the shell hooks. Commits are made by hishtory rather than the user, and shouldn't depend on
+// their git config.
+func gitEnv() []string {
+	env := append(os.Environ(), "GIT_TERMINAL_PROMPT=0",
+		"GIT_AUTHOR_NAME="+gitCommitAuthor, "GIT_AUTHOR_EMAIL="+gitCommitAuthor+"@localhost",
+		"GIT_COMMITTER_NAME="+gitCommitAuthor, "GIT_COMMITTER_EMAIL="+gitCommitAuthor+"@localhost")
+	// GIT_TERMINAL_PROMPT doesn't apply to ssh, which prompts on /dev/tty for passphrases and unknown host keys
+	if os.Getenv("GIT_SSH_COMMAND") == "" && os.Getenv("GIT_SSH") == "" {
+		env = append(env, "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	}
+	return env
+}
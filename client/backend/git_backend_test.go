@@ -0,0 +1,223 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestGitRemote creates a local bare repository to sync via
+func newTestGitRemote(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	remote := filepath.Join(t.TempDir(), "remote.git")
+	_, err := runGit(context.Background(), "", "init", "--bare", "--quiet", remote)
+	require.NoError(t, err)
+	return remote
+}
+
+// newTestGitBackend creates a git backend with its own local clone, simulating a separate device
+func newTestGitBackend(t *testing.T, remote string) *GitBackend {
+	b, err := NewGitBackend(&GitConfig{Remote: remote}, "user123", filepath.Join(t.TempDir(), "clone"))
+	require.NoError(t, err)
+	return b
+}
+
+func TestGitBackendPing(t *testing.T) {
+	ctx := context.Background()
+	b := newTestGitBackend(t, newTestGitRemote(t))
+	assert.Equal(t, "git", b.Type())
+	require.NoError(t, b.Ping(ctx))
+
+	b = newTestGitBackend(t, filepath.Join(t.TempDir(), "missing.git"))
+	require.Error(t, b.Ping(ctx))
+}
+
+func TestRunGitNonInteractive(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	// ssh is run in batch mode so that it never prompts, unless the user configured how to run ssh
+	t.Setenv("GIT_SSH", "")
+	t.Setenv("GIT_SSH_COMMAND", "")
+	assert.Contains(t, gitEnv(), "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+	t.Setenv("GIT_SSH_COMMAND", "ssh -i ~/.ssh/hishtory")
+	assert.NotContains(t, gitEnv(), "GIT_SSH_COMMAND=ssh -o BatchMode=yes")
+
+	// Commands that hang (e.g. on an unresponsive remote) are killed
+	hangingSsh := filepath.Join(t.TempDir(), "ssh")
+	require.NoError(t, os.WriteFile(hangingSsh, []byte("#!/bin/sh\nsleep 60\n"), 0o700))
+	t.Setenv("GIT_SSH_COMMAND", hangingSsh)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := runGit(ctx, "", "ls-remote", "ssh://git@example.invalid/repo.git")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 10*time.Second)
+}
+
+func TestGitBackendSyncsBetweenDevices(t *testing.T) {
+	ctx := context.Background()
+	remote := newTestGitRemote(t)
+	device1 := newTestGitBackend(t, remote)
+	device2 := newTestGitBackend(t, remote)
+
+	require.NoError(t, device1.RegisterDevice(ctx, "user123", "device1"))
+	entries := []*shared.EncHistoryEntry{{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()}}
+	_, err := device1.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+
+	// A new device bootstraps from the entries pushed by the first device
+	require.NoError(t, device2.RegisterDevice(ctx, "user123", "device2"))
+	bootstrapped, err := device2.Bootstrap(ctx, "user123", "device2")
+	require.NoError(t, err)
+	require.Len(t, bootstrapped, 1)
+	assert.Equal(t, "entry1", bootstrapped[0].EncryptedId)
+
+	// The first device sees the dump request, and entries flow to the second device
+	entries = []*shared.EncHistoryEntry{{EncryptedId: "entry2", DeviceId: "device1", Date: time.Now()}}
+	resp, err := device1.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+	require.Len(t, resp.DumpRequests, 1)
+	assert.Equal(t, "device2", resp.DumpRequests[0].RequestingDeviceId)
+	received, err := device2.QueryEntries(ctx, "device2", "user123", "test")
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	assert.Equal(t, "entry2", received[0].EncryptedId)
+
+	// Read counts are tracked locally, so queries don't push anything
+	head, err := runGit(ctx, remote, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	for i := 2; i <= readCountLimit; i++ {
+		received, err = device2.QueryEntries(ctx, "device2", "user123", "test")
+		require.NoError(t, err)
+		require.Len(t, received, 1)
+		assert.Equal(t, i, received[0].ReadCount)
+	}
+	received, err = device2.QueryEntries(ctx, "device2", "user123", "test")
+	require.NoError(t, err)
+	require.Empty(t, received)
+	newHead, err := runGit(ctx, remote, "rev-parse", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, head, newHead)
+
+	// Fully read entries are removed from the remote along with the next update
+	require.NoError(t, device2.withClone(ctx, func() error { return device2.pull(ctx) }))
+	inbox, err := device2.dir.listFiles(device2.dir.file("inbox", "device2"))
+	require.NoError(t, err)
+	require.Len(t, inbox, 1)
+
+	// Deletion requests propagate too
+	delReq := shared.DeletionRequest{
+		UserId:   "user123",
+		Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{EntryId: "entry1"}}},
+	}
+	require.NoError(t, device2.AddDeletionRequest(ctx, delReq))
+	inbox, err = device2.dir.listFiles(device2.dir.file("inbox", "device2"))
+	require.NoError(t, err)
+	require.Empty(t, inbox)
+	reqs, err := device1.GetDeletionRequests(ctx, "user123", "device1")
+	require.NoError(t, err)
+	require.Len(t, reqs, 1)
+	bootstrapped, err = device1.Bootstrap(ctx, "user123", "device1")
+	require.NoError(t, err)
+	require.Len(t, bootstrapped, 1)
+	assert.Equal(t, "entry2", bootstrapped[0].EncryptedId)
+
+	// Submitting entries also returns deletion requests, without committing their read counts
+	entries = []*shared.EncHistoryEntry{{EncryptedId: "entry3", DeviceId: "device1", Date: time.Now()}}
+	resp, err = device1.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+	require.Len(t, resp.DeletionRequests, 1)
+	assert.Equal(t, 2, resp.DeletionRequests[0].ReadCount)
+	deletions, err := device1.dir.listFiles(device1.dir.file("deletions", "device1"))
+	require.NoError(t, err)
+	require.Len(t, deletions, 1)
+	var committed shared.DeletionRequest
+	require.NoError(t, device1.dir.readJson(deletions[0], &committed))
+	assert.Equal(t, 0, committed.ReadCount)
+	status, err := device1.git(ctx, "status", "--porcelain")
+	require.NoError(t, err)
+	assert.Empty(t, status)
+
+	// And uninstalling removes the device
+	require.NoError(t, device2.Uninstall(ctx, "user123", "device2"))
+	require.NoError(t, device1.withClone(ctx, func() error { return device1.pull(ctx) }))
+	devices, err := device1.dir.getDevices()
+	require.NoError(t, err)
+	require.Len(t, devices.Devices, 1)
+	assert.Equal(t, "device1", devices.Devices[0].DeviceId)
+}
+
+func TestGitBackendConflictingPushes(t *testing.T) {
+	ctx := context.Background()
+	remote := newTestGitRemote(t)
+	require.NoError(t, newTestGitBackend(t, remote).RegisterDevice(ctx, "user123", "device0"))
+
+	// Every device modifies devices.json and writes new files at the same time, so pushes are rejected and
+	// either rebased or re-applied
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 1; i <= 5; i++ {
+		b := newTestGitBackend(t, remote)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			deviceId := fmt.Sprintf("device%d", i)
+			if err := b.RegisterDevice(ctx, "user123", deviceId); err != nil {
+				errs <- err
+				return
+			}
+			entries := []*shared.EncHistoryEntry{{EncryptedId: "entry" + deviceId, DeviceId: deviceId, Date: time.Now()}}
+			_, err := b.SubmitEntries(ctx, entries, deviceId)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	b := newTestGitBackend(t, remote)
+	bootstrapped, err := b.Bootstrap(ctx, "user123", "device6")
+	require.NoError(t, err)
+	assert.Len(t, bootstrapped, 5)
+	devices, err := b.dir.getDevices()
+	require.NoError(t, err)
+	assert.Len(t, devices.Devices, 6)
+}
+
+func TestGitBackendUnpushedCommits(t *testing.T) {
+	ctx := context.Background()
+	remote := newTestGitRemote(t)
+	b := newTestGitBackend(t, remote)
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+
+	// While the remote is unreachable, updates fail
+	require.NoError(t, os.Rename(remote, remote+".offline"))
+	entries := []*shared.EncHistoryEntry{{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()}}
+	_, err := b.SubmitEntries(ctx, entries, "device1")
+	require.Error(t, err)
+	require.Error(t, b.Ping(ctx))
+
+	// And once it is back, they succeed
+	require.NoError(t, os.Rename(remote+".offline", remote))
+	_, err = b.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+	bootstrapped, err := newTestGitBackend(t, remote).Bootstrap(ctx, "user123", "device2")
+	require.NoError(t, err)
+	assert.Len(t, bootstrapped, 1)
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	"github.com/ddworken/hishtory/shared"
@@ -22,8 +23,13 @@ type HTTPBackend struct {
 	version   string
 	deviceId  string
 	userId    string
+	// The content encoding to compress request bodies with, once the server has advertised support for it
+	requestEncoding atomic.Value
 }
 
+// minCompressedRequestSize is the size below which request bodies aren't worth compressing
+const minCompressedRequestSize = 1024
+
 // HTTPBackendOption is a functional option for configuring HTTPBackend
 type HTTPBackendOption func(*HTTPBackend)
 
@@ -56,6 +62,14 @@ func WithAuth(deviceId, userId string) HTTPBackendOption {
 	}
 }
 
+// WithRequestEncoding sets the content encoding to compress request bodies with until the server says otherwise,
+// e.g. the one that it advertised to an earlier process
+func WithRequestEncoding(encoding string) HTTPBackendOption {
+	return func(b *HTTPBackend) {
+		b.requestEncoding.Store(encoding)
+	}
+}
+
 // NewHTTPBackend creates a new HTTP backend with the given options.
 func NewHTTPBackend(opts ...HTTPBackendOption) *HTTPBackend {
 	b := &HTTPBackend{
@@ -217,7 +231,7 @@ func (b *HTTPBackend) apiGet(ctx context.Context, path string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to GET %s%s: status_code=%d", b.serverURL, path, resp.StatusCode)
 	}
 
-	return io.ReadAll(resp.Body)
+	return b.readBody(resp)
 }
 
 // apiPost performs a POST request to the server.
@@ -225,12 +239,26 @@ func (b *HTTPBackend) apiPost(ctx context.Context, path, contentType string, bod
 	if os.Getenv("HISHTORY_SIMULATE_NETWORK_ERROR") != "" {
 		return nil, fmt.Errorf("simulated network error: dial tcp: lookup api.hishtory.dev")
 	}
+	encoding := b.RequestEncoding()
+	// The server rejects compressed bodies that decompress to more than shared.MaxDecompressedRequestSize
+	if encoding != "" && len(body) >= minCompressedRequestSize && len(body) <= shared.MaxDecompressedRequestSize {
+		compressed, err := shared.Compress(encoding, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress POST body: %w", err)
+		}
+		body = compressed
+	} else {
+		encoding = ""
+	}
 	req, err := http.NewRequestWithContext(ctx, "POST", b.serverURL+path, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create POST request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
 	b.setHeaders(req)
 
 	resp, err := b.client.Do(req)
@@ -243,12 +271,37 @@ func (b *HTTPBackend) apiPost(ctx context.Context, path, contentType string, bod
 		return nil, fmt.Errorf("failed to POST %s%s: status_code=%d", b.serverURL, path, resp.StatusCode)
 	}
 
-	return io.ReadAll(resp.Body)
+	return b.readBody(resp)
+}
+
+// RequestEncoding returns the content encoding that request bodies are compressed with, which is the one that the
+// server advertised support for in its latest response.
+func (b *HTTPBackend) RequestEncoding() string {
+	encoding, _ := b.requestEncoding.Load().(string)
+	return encoding
+}
+
+// readBody reads and decompresses the response body. Since old servers don't support compressed request
+// bodies, it also records whether the server advertised support for them via the Accept-Encoding header.
+func (b *HTTPBackend) readBody(resp *http.Response) ([]byte, error) {
+	b.requestEncoding.Store(shared.NegotiateEncoding(resp.Header.Get("Accept-Encoding")))
+	encoding := resp.Header.Get("Content-Encoding")
+	if encoding == "" {
+		return io.ReadAll(resp.Body)
+	}
+	body, err := shared.NewDecompressingReader(encoding, resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress response from %s: %w", resp.Request.URL, err)
+	}
+	defer body.Close()
+	return io.ReadAll(body)
 }
 
 // setHeaders sets common headers on the request.
 func (b *HTTPBackend) setHeaders(req *http.Request) {
 	req.Header.Set("X-Hishtory-Version", "v0."+b.version)
+	// Note that setting this disables the transparent gzip support in net/http, so readBody handles decompression
+	req.Header.Set("Accept-Encoding", shared.AcceptEncoding)
 	if b.deviceId != "" {
 		req.Header.Set("X-Hishtory-Device-Id", b.deviceId)
 	}
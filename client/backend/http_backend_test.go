@@ -3,6 +3,8 @@ package backend
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -273,6 +275,68 @@ func TestHTTPBackendHeaders(t *testing.T) {
 	assert.Equal(t, "test-user", receivedHeaders.Get("X-Hishtory-User-Id"))
 }
 
+func TestHTTPBackendCompression(t *testing.T) {
+	var entries []*shared.EncHistoryEntry
+	for i := 0; i < 50; i++ {
+		entries = append(entries, &shared.EncHistoryEntry{EncryptedData: []byte("encrypted"), DeviceId: "device1", EncryptedId: fmt.Sprintf("id%d", i)})
+	}
+	var advertiseCompression bool
+	var receivedEncodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, shared.AcceptEncoding, r.Header.Get("Accept-Encoding"))
+		encoding := r.Header.Get("Content-Encoding")
+		receivedEncodings = append(receivedEncodings, encoding)
+		if r.Method == "POST" {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			if encoding != "" {
+				body, err = shared.Decompress(encoding, body)
+				require.NoError(t, err)
+			}
+			var received []*shared.EncHistoryEntry
+			require.NoError(t, json.Unmarshal(body, &received))
+			assert.Len(t, received, len(entries))
+		}
+
+		if advertiseCompression {
+			w.Header().Set("Accept-Encoding", shared.AcceptEncoding)
+		}
+		respBody, err := json.Marshal(entries)
+		require.NoError(t, err)
+		if r.URL.Path == "/api/v1/bootstrap" {
+			respBody, err = shared.Compress(shared.EncodingZstd, respBody)
+			require.NoError(t, err)
+			w.Header().Set("Content-Encoding", shared.EncodingZstd)
+		}
+		_, _ = w.Write(respBody)
+	}))
+	defer server.Close()
+	b := NewHTTPBackend(WithServerURL(server.URL))
+	ctx := context.Background()
+
+	// Request bodies aren't compressed until the server advertises support for it
+	require.NoError(t, b.SubmitDump(ctx, entries, "user1", "device2", "device1"))
+	bootstrapped, err := b.Bootstrap(ctx, "user1", "device1")
+	require.NoError(t, err)
+	assert.Len(t, bootstrapped, len(entries))
+	require.NoError(t, b.SubmitDump(ctx, entries, "user1", "device2", "device1"))
+
+	advertiseCompression = true
+	require.NoError(t, b.Ping(ctx))
+	require.NoError(t, b.SubmitDump(ctx, entries, "user1", "device2", "device1"))
+	assert.Equal(t, []string{"", "", "", "", shared.EncodingZstd}, receivedEncodings)
+
+	// A new backend can compress its first request if the encoding is already known from an earlier process
+	b = NewHTTPBackend(WithServerURL(server.URL), WithRequestEncoding(b.RequestEncoding()))
+	require.NoError(t, b.SubmitDump(ctx, entries, "user1", "device2", "device1"))
+	assert.Equal(t, shared.EncodingZstd, receivedEncodings[len(receivedEncodings)-1])
+
+	// Bodies that the server would refuse to decompress are sent uncompressed
+	entries = []*shared.EncHistoryEntry{{EncryptedData: make([]byte, shared.MaxDecompressedRequestSize), DeviceId: "device1", EncryptedId: "large"}}
+	require.NoError(t, b.SubmitDump(ctx, entries, "user1", "device2", "device1"))
+	assert.Equal(t, "", receivedEncodings[len(receivedEncodings)-1])
+}
+
 func TestHTTPBackendWithCustomClient(t *testing.T) {
 	customClient := &http.Client{Timeout: 5 * time.Second}
 	b := NewHTTPBackend(WithHTTPClient(customClient))
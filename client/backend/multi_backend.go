@@ -0,0 +1,217 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// MultiBackend mirrors history across several backends, e.g. the hishtory server plus an S3 bucket as an
+// archive. Writes go to every backend and reads are merged and deduplicated by EncryptedId. An operation only
+// fails if it fails for every backend, so syncing keeps working while one of the backends is down. Entries that
+// fail to be submitted to some of the backends are reported to the OnMissedSubmit callback so that they can be
+// uploaded to them later.
+type MultiBackend struct {
+	// The first backend is the primary one, which Type reports
+	backends       []SyncBackend
+	onMissedSubmit func(i int, entries []*shared.EncHistoryEntry)
+}
+
+var _ SyncBackend = (*MultiBackend)(nil)
+
+// NewMultiBackend creates a MultiBackend that mirrors history across the given backends.
+func NewMultiBackend(primary SyncBackend, mirrors ...SyncBackend) *MultiBackend {
+	return &MultiBackend{backends: append([]SyncBackend{primary}, mirrors...)}
+}
+
+// OnMissedSubmit sets fn to be called with the index of each backend that SubmitEntries failed for, when
+// SubmitEntries still succeeded because another backend was available.
+func (b *MultiBackend) OnMissedSubmit(fn func(i int, entries []*shared.EncHistoryEntry)) {
+	b.onMissedSubmit = fn
+}
+
+// Backends returns the backends that b syncs via: the mirrored backends if b is a MultiBackend, and otherwise
+// b itself.
+func Backends(b SyncBackend) []SyncBackend {
+	if m, ok := b.(*MultiBackend); ok {
+		return m.backends
+	}
+	return []SyncBackend{b}
+}
+
+// forEachBackend calls fn concurrently for every backend. Failures are logged, and an error is only returned if
+// fn failed for every backend.
+func (b *MultiBackend) forEachBackend(op string, fn func(i int, backend SyncBackend) error) error {
+	errs := make([]error, len(b.backends))
+	var wg sync.WaitGroup
+	for i, backend := range b.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, backend)
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			errs[i] = fmt.Errorf("%s backend: %w", b.backends[i].Type(), err)
+			hctx.GetLogger().Warnf("MultiBackend.%s: %v", op, errs[i])
+		}
+	}
+	if failed == len(b.backends) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// mergeEntries merges the entries returned by each backend, skipping duplicates of entries returned by an
+// earlier backend
+func mergeEntries(results [][]*shared.EncHistoryEntry) []*shared.EncHistoryEntry {
+	seen := make(map[string]bool)
+	var merged []*shared.EncHistoryEntry
+	for _, entries := range results {
+		for _, entry := range entries {
+			if seen[entry.EncryptedId] {
+				continue
+			}
+			seen[entry.EncryptedId] = true
+			merged = append(merged, entry)
+		}
+	}
+	return merged
+}
+
+// RegisterDevice registers the device with every backend.
+func (b *MultiBackend) RegisterDevice(ctx context.Context, userId, deviceId string) error {
+	return b.forEachBackend("RegisterDevice", func(_ int, backend SyncBackend) error {
+		return backend.RegisterDevice(ctx, userId, deviceId)
+	})
+}
+
+// Bootstrap returns the entries stored in any of the backends.
+func (b *MultiBackend) Bootstrap(ctx context.Context, userId, deviceId string) ([]*shared.EncHistoryEntry, error) {
+	results := make([][]*shared.EncHistoryEntry, len(b.backends))
+	err := b.forEachBackend("Bootstrap", func(i int, backend SyncBackend) error {
+		var err error
+		results[i], err = backend.Bootstrap(ctx, userId, deviceId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeEntries(results), nil
+}
+
+// SubmitEntries submits the entries to every backend, and returns the dump requests and deletion requests from
+// all of them.
+func (b *MultiBackend) SubmitEntries(ctx context.Context, entries []*shared.EncHistoryEntry, sourceDeviceId string) (*shared.SubmitResponse, error) {
+	responses := make([]*shared.SubmitResponse, len(b.backends))
+	failed := make([]bool, len(b.backends))
+	err := b.forEachBackend("SubmitEntries", func(i int, backend SyncBackend) error {
+		var err error
+		responses[i], err = backend.SubmitEntries(ctx, entries, sourceDeviceId)
+		failed[i] = err != nil
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if b.onMissedSubmit != nil {
+		for i := range b.backends {
+			if failed[i] {
+				b.onMissedSubmit(i, entries)
+			}
+		}
+	}
+
+	merged := &shared.SubmitResponse{}
+	requestingDevices := make(map[string]bool)
+	for _, resp := range responses {
+		if resp == nil {
+			continue
+		}
+		// A device that registered with several backends only needs a single dump, since SubmitDump sends it
+		// to every backend
+		for _, dumpRequest := range resp.DumpRequests {
+			if !requestingDevices[dumpRequest.RequestingDeviceId] {
+				requestingDevices[dumpRequest.RequestingDeviceId] = true
+				merged.DumpRequests = append(merged.DumpRequests, dumpRequest)
+			}
+		}
+		merged.DeletionRequests = append(merged.DeletionRequests, resp.DeletionRequests...)
+	}
+	return merged, nil
+}
+
+// SubmitDump sends the dump to every backend.
+func (b *MultiBackend) SubmitDump(ctx context.Context, entries []*shared.EncHistoryEntry, userId, requestingDeviceId, sourceDeviceId string) error {
+	return b.forEachBackend("SubmitDump", func(_ int, backend SyncBackend) error {
+		return backend.SubmitDump(ctx, entries, userId, requestingDeviceId, sourceDeviceId)
+	})
+}
+
+// QueryEntries returns the new entries from all backends.
+func (b *MultiBackend) QueryEntries(ctx context.Context, deviceId, userId, queryReason string) ([]*shared.EncHistoryEntry, error) {
+	results := make([][]*shared.EncHistoryEntry, len(b.backends))
+	err := b.forEachBackend("QueryEntries", func(i int, backend SyncBackend) error {
+		var err error
+		results[i], err = backend.QueryEntries(ctx, deviceId, userId, queryReason)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeEntries(results), nil
+}
+
+// GetDeletionRequests returns the pending deletion requests from all backends. Requests that were sent to
+// several backends are returned once per backend, which is harmless since deletions are idempotent.
+func (b *MultiBackend) GetDeletionRequests(ctx context.Context, userId, deviceId string) ([]*shared.DeletionRequest, error) {
+	results := make([][]*shared.DeletionRequest, len(b.backends))
+	err := b.forEachBackend("GetDeletionRequests", func(i int, backend SyncBackend) error {
+		var err error
+		results[i], err = backend.GetDeletionRequests(ctx, userId, deviceId)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var merged []*shared.DeletionRequest
+	for _, requests := range results {
+		merged = append(merged, requests...)
+	}
+	return merged, nil
+}
+
+// AddDeletionRequest sends the deletion request to every backend.
+func (b *MultiBackend) AddDeletionRequest(ctx context.Context, request shared.DeletionRequest) error {
+	return b.forEachBackend("AddDeletionRequest", func(_ int, backend SyncBackend) error {
+		return backend.AddDeletionRequest(ctx, request)
+	})
+}
+
+// Uninstall removes the device from every backend.
+func (b *MultiBackend) Uninstall(ctx context.Context, userId, deviceId string) error {
+	return b.forEachBackend("Uninstall", func(_ int, backend SyncBackend) error {
+		return backend.Uninstall(ctx, userId, deviceId)
+	})
+}
+
+// Ping checks that at least one of the backends is reachable.
+func (b *MultiBackend) Ping(ctx context.Context) error {
+	return b.forEachBackend("Ping", func(_ int, backend SyncBackend) error {
+		return backend.Ping(ctx)
+	})
+}
+
+// Type returns the type of the primary backend.
+func (b *MultiBackend) Type() string {
+	return b.backends[0].Type()
+}
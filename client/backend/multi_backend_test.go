@@ -0,0 +1,119 @@
+package backend
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUnavailableDirBackend returns a DirBackend whose directory can't be created, to simulate a backend that is down
+func newUnavailableDirBackend(t *testing.T) *DirBackend {
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o600))
+	b, err := NewDirBackend(&DirConfig{Path: filepath.Join(file, "hishtory")}, "user123")
+	require.NoError(t, err)
+	return b
+}
+
+func TestMultiBackendMirrorsWrites(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestDirBackend(t)
+	mirror := newTestDirBackend(t)
+	b := NewMultiBackend(primary, mirror)
+	assert.Equal(t, "dir", b.Type())
+	assert.Equal(t, []SyncBackend{primary, mirror}, Backends(b))
+	assert.Equal(t, []SyncBackend{primary}, Backends(primary))
+
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+	entries := []*shared.EncHistoryEntry{{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()}}
+	resp, err := b.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+	// device2 registered with both backends, but only needs a single dump
+	require.Len(t, resp.DumpRequests, 1)
+	assert.Equal(t, "device2", resp.DumpRequests[0].RequestingDeviceId)
+
+	for _, backend := range []SyncBackend{primary, mirror} {
+		bootstrapped, err := backend.Bootstrap(ctx, "user123", "device2")
+		require.NoError(t, err)
+		require.Len(t, bootstrapped, 1)
+		assert.Equal(t, "entry1", bootstrapped[0].EncryptedId)
+	}
+}
+
+func TestMultiBackendDedupesReads(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestDirBackend(t)
+	mirror := newTestDirBackend(t)
+	b := NewMultiBackend(primary, mirror)
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+
+	// entry1 was mirrored, while entry2 was submitted by a device that only syncs via the primary backend
+	_, err := b.SubmitEntries(ctx, []*shared.EncHistoryEntry{{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()}}, "device1")
+	require.NoError(t, err)
+	_, err = primary.SubmitEntries(ctx, []*shared.EncHistoryEntry{{EncryptedId: "entry2", DeviceId: "device1", Date: time.Now()}}, "device1")
+	require.NoError(t, err)
+
+	queried, err := b.QueryEntries(ctx, "device2", "user123", "")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"entry1", "entry2"}, encryptedIds(queried))
+	bootstrapped, err := b.Bootstrap(ctx, "user123", "device3")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"entry1", "entry2"}, encryptedIds(bootstrapped))
+}
+
+func TestMultiBackendToleratesUnavailableBackend(t *testing.T) {
+	ctx := context.Background()
+	available := newTestDirBackend(t)
+	unavailable := newUnavailableDirBackend(t)
+	require.Error(t, unavailable.Ping(ctx))
+
+	for _, b := range []*MultiBackend{NewMultiBackend(available, unavailable), NewMultiBackend(unavailable, available)} {
+		require.NoError(t, b.Ping(ctx))
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+		_, err := b.SubmitEntries(ctx, []*shared.EncHistoryEntry{{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()}}, "device1")
+		require.NoError(t, err)
+		bootstrapped, err := b.Bootstrap(ctx, "user123", "device2")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"entry1"}, encryptedIds(bootstrapped))
+		require.NoError(t, b.AddDeletionRequest(ctx, shared.DeletionRequest{
+			UserId:   "user123",
+			Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{EntryId: "entry1"}}},
+		}))
+		require.NoError(t, b.Uninstall(ctx, "user123", "device2"))
+	}
+
+	// Entries that only reached some of the backends are reported so that they can be uploaded again
+	var missed []int
+	b := NewMultiBackend(available, unavailable)
+	b.OnMissedSubmit(func(i int, entries []*shared.EncHistoryEntry) {
+		missed = append(missed, i)
+		assert.Equal(t, []string{"entry2"}, encryptedIds(entries))
+	})
+	_, err := b.SubmitEntries(ctx, []*shared.EncHistoryEntry{{EncryptedId: "entry2", DeviceId: "device1", Date: time.Now()}}, "device1")
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, missed)
+
+	// Operations fail once every backend is down
+	b = NewMultiBackend(unavailable, newUnavailableDirBackend(t))
+	require.Error(t, b.Ping(ctx))
+	_, err = b.SubmitEntries(ctx, []*shared.EncHistoryEntry{{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()}}, "device1")
+	require.Error(t, err)
+}
+
+func encryptedIds(entries []*shared.EncHistoryEntry) []string {
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.EncryptedId)
+	}
+	return ids
+}
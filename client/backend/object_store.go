@@ -0,0 +1,279 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// objectStore is the minimal storage interface needed to keep a user's history in the same layout as
+// S3Backend. Keys are slash-separated paths relative to the user's folder.
+type objectStore interface {
+	// getObject returns the contents of the object at key
+	getObject(ctx context.Context, key string) ([]byte, error)
+	// putObject atomically writes the object at key, so that other devices never read a partially written object
+	putObject(ctx context.Context, key string, data []byte) error
+	// listObjects recursively lists the keys of the JSON objects under prefix, skipping hidden objects
+	listObjects(ctx context.Context, prefix string) ([]string, error)
+	// deleteObject deletes the object at key, or everything under key if it is a folder. Deleting an object
+	// that doesn't exist isn't an error.
+	deleteObject(ctx context.Context, key string) error
+}
+
+// objectLayout implements the parts of SyncBackend that use the entries, inbox, deletions and dump_requests
+// folders on top of an objectStore. devices.json is left to each backend, since how concurrent updates to it
+// are serialized depends on the store.
+type objectLayout struct {
+	store objectStore
+	name  string // the name of the backend, for log messages
+}
+
+func (l objectLayout) getJson(ctx context.Context, key string, v any) error {
+	data, err := l.store.getObject(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", key, err)
+	}
+	return nil
+}
+
+func (l objectLayout) putJson(ctx context.Context, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", path.Base(key), err)
+	}
+	return l.store.putObject(ctx, key, data)
+}
+
+func (l objectLayout) inboxKey(deviceId string, entry *shared.EncHistoryEntry) string {
+	return path.Join("inbox", deviceId, entry.Date.Format("20060102T150405Z")+"_"+entry.EncryptedId+".json")
+}
+
+func (l objectLayout) dumpRequestKey(requestingDeviceId string) string {
+	return path.Join("dump_requests", requestingDeviceId+".json")
+}
+
+// bootstrap returns all history entries for the user.
+func (l objectLayout) bootstrap(ctx context.Context) ([]*shared.EncHistoryEntry, error) {
+	keys, err := l.store.listObjects(ctx, "entries")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list entries: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var entries []*shared.EncHistoryEntry
+	for _, key := range keys {
+		var entry shared.EncHistoryEntry
+		if err := l.getJson(ctx, key, &entry); err != nil {
+			hctx.GetLogger().Warnf("%s.Bootstrap: failed to read entry %s: %v", l.name, key, err)
+			continue
+		}
+		if seen[entry.EncryptedId] {
+			continue
+		}
+		seen[entry.EncryptedId] = true
+		entries = append(entries, &entry)
+	}
+	return entries, nil
+}
+
+// submitEntries writes entries to the entries folder and to the inbox of every device other than sourceDeviceId.
+func (l objectLayout) submitEntries(ctx context.Context, devices []DeviceInfo, entries []*shared.EncHistoryEntry, sourceDeviceId string) error {
+	if len(devices) == 0 {
+		return fmt.Errorf("no devices registered for user")
+	}
+	for _, entry := range entries {
+		// Write to entries/ (master copy)
+		if err := l.putJson(ctx, path.Join("entries", entry.Date.Format("2006-01-02"), entry.EncryptedId+".json"), entry); err != nil {
+			return fmt.Errorf("failed to write entry: %w", err)
+		}
+
+		// Write to each device's inbox (except source device)
+		for _, device := range devices {
+			if device.DeviceId == sourceDeviceId {
+				continue
+			}
+			entryCopy := *entry
+			entryCopy.DeviceId = device.DeviceId
+			entryCopy.IsFromSameDevice = false
+			if err := l.putJson(ctx, l.inboxKey(device.DeviceId, entry), &entryCopy); err != nil {
+				return fmt.Errorf("failed to write inbox entry: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// submitDump writes entries to the inbox of requestingDeviceId and then clears its dump request.
+func (l objectLayout) submitDump(ctx context.Context, entries []*shared.EncHistoryEntry, requestingDeviceId string) error {
+	for _, entry := range entries {
+		entryCopy := *entry
+		entryCopy.DeviceId = requestingDeviceId
+		if err := l.putJson(ctx, l.inboxKey(requestingDeviceId, entry), &entryCopy); err != nil {
+			return fmt.Errorf("failed to write inbox entry: %w", err)
+		}
+	}
+	return l.store.deleteObject(ctx, l.dumpRequestKey(requestingDeviceId))
+}
+
+// createDumpRequest asks the other devices to send their history to requestingDeviceId.
+func (l objectLayout) createDumpRequest(ctx context.Context, userId, requestingDeviceId string) error {
+	dumpReq := &shared.DumpRequest{
+		UserId:             userId,
+		RequestingDeviceId: requestingDeviceId,
+		RequestTime:        time.Now().UTC(),
+	}
+	if err := l.putJson(ctx, l.dumpRequestKey(requestingDeviceId), dumpReq); err != nil {
+		return fmt.Errorf("failed to create dump request: %w", err)
+	}
+	return nil
+}
+
+// getDumpRequests returns the dump requests from devices other than sourceDeviceId.
+func (l objectLayout) getDumpRequests(ctx context.Context, sourceDeviceId string) ([]*shared.DumpRequest, error) {
+	keys, err := l.store.listObjects(ctx, "dump_requests")
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []*shared.DumpRequest
+	for _, key := range keys {
+		// Skip dump requests from the source device itself
+		if strings.Contains(path.Base(key), sourceDeviceId) {
+			continue
+		}
+		var req shared.DumpRequest
+		if err := l.getJson(ctx, key, &req); err != nil {
+			continue
+		}
+		requests = append(requests, &req)
+	}
+	return requests, nil
+}
+
+// queryEntries returns the entries in the inbox of deviceId. Like S3Backend, entries are kept until they have
+// been read readCountLimit times.
+func (l objectLayout) queryEntries(ctx context.Context, deviceId string) ([]*shared.EncHistoryEntry, error) {
+	entries, err := readAndCount(ctx, l, path.Join("inbox", deviceId), "entry", func(e *shared.EncHistoryEntry) *int { return &e.ReadCount })
+	if err != nil {
+		return nil, fmt.Errorf("failed to list inbox: %w", err)
+	}
+	return entries, nil
+}
+
+// getDeletionRequests returns the pending deletion requests for deviceId. Like queryEntries, requests are kept
+// until they have been read readCountLimit times.
+func (l objectLayout) getDeletionRequests(ctx context.Context, deviceId string) ([]*shared.DeletionRequest, error) {
+	return readAndCount(ctx, l, path.Join("deletions", deviceId), "request", func(r *shared.DeletionRequest) *int { return &r.ReadCount })
+}
+
+// readAndCount reads every object under prefix and increments its read count, which is returned by readCount.
+// Objects are deleted once they have been read readCountLimit times.
+func readAndCount[T any](ctx context.Context, l objectLayout, prefix, kind string, readCount func(*T) *int) ([]*T, error) {
+	keys, err := l.store.listObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*T
+	for _, key := range keys {
+		var v T
+		if err := l.getJson(ctx, key, &v); err != nil {
+			hctx.GetLogger().Warnf("%s: failed to read %s %s: %v", l.name, kind, key, err)
+			continue
+		}
+		count := readCount(&v)
+		if *count >= readCountLimit {
+			_ = l.store.deleteObject(ctx, key)
+			continue
+		}
+		*count++
+		results = append(results, &v)
+		if *count >= readCountLimit {
+			_ = l.store.deleteObject(ctx, key)
+		} else {
+			_ = l.putJson(ctx, key, &v)
+		}
+	}
+	return results, nil
+}
+
+// addDeletionRequest writes request to the deletions folder of every device, and deletes the entries that it
+// refers to from the entries folder and every device's inbox.
+func (l objectLayout) addDeletionRequest(ctx context.Context, devices []DeviceInfo, request shared.DeletionRequest) error {
+	for _, device := range devices {
+		reqCopy := request
+		reqCopy.DestinationDeviceId = device.DeviceId
+		reqCopy.ReadCount = 0
+
+		entryId := ""
+		if len(request.Messages.Ids) > 0 {
+			entryId = request.Messages.Ids[0].EntryId
+		}
+		key := path.Join("deletions", device.DeviceId, fmt.Sprintf("%d_%s.json", time.Now().UnixNano(), entryId))
+		if err := l.putJson(ctx, key, &reqCopy); err != nil {
+			return fmt.Errorf("failed to write deletion request: %w", err)
+		}
+	}
+
+	idsToDelete := make(map[string]bool)
+	for _, msg := range request.Messages.Ids {
+		if msg.EntryId != "" {
+			idsToDelete[msg.EntryId] = true
+		}
+	}
+	if len(idsToDelete) == 0 {
+		return nil
+	}
+
+	// Delete the entries from the main entries store, where objects are named [entryId].json
+	keys, err := l.store.listObjects(ctx, "entries")
+	if err != nil {
+		return fmt.Errorf("failed to list entries for deletion: %w", err)
+	}
+	for _, key := range keys {
+		if idsToDelete[strings.TrimSuffix(path.Base(key), ".json")] {
+			if err := l.store.deleteObject(ctx, key); err != nil {
+				return fmt.Errorf("failed to delete entry: %w", err)
+			}
+		}
+	}
+
+	// And from all device inboxes, where objects are named [date]_[entryId].json. This is best effort.
+	for _, device := range devices {
+		keys, err := l.store.listObjects(ctx, path.Join("inbox", device.DeviceId))
+		if err != nil {
+			hctx.GetLogger().Warnf("%s.AddDeletionRequest: failed to list inbox for device %s: %v", l.name, device.DeviceId, err)
+			continue
+		}
+		for _, key := range keys {
+			_, name, _ := strings.Cut(strings.TrimSuffix(path.Base(key), ".json"), "_")
+			if idsToDelete[name] {
+				if err := l.store.deleteObject(ctx, key); err != nil {
+					hctx.GetLogger().Warnf("%s.AddDeletionRequest: failed to delete inbox entry %s: %v", l.name, key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// removeDevice deletes the pending data of a device that was uninstalled. This is best effort.
+func (l objectLayout) removeDevice(ctx context.Context, deviceId string) {
+	_ = l.store.deleteObject(ctx, path.Join("inbox", deviceId))
+	_ = l.store.deleteObject(ctx, path.Join("deletions", deviceId))
+	keys, _ := l.store.listObjects(ctx, "dump_requests")
+	for _, key := range keys {
+		if strings.Contains(path.Base(key), deviceId) {
+			_ = l.store.deleteObject(ctx, key)
+		}
+	}
+}
@@ -7,8 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"path"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ddworken/hishtory/client/hctx"
@@ -19,12 +22,26 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 // readCountLimit is the number of times an entry can be read before it is deleted.
 // This matches the HTTP backend behavior (see backend/server/internal/server/api_handlers.go).
 const readCountLimit = 5
 
+// The default maximum number of parallel requests, e.g. when downloading entries during Bootstrap
+const s3DefaultConcurrency = 16
+
+// The number of times to retry a conditional write when another device modified the object concurrently
+const s3MaxConditionalWriteAttempts = 10
+
+// s3EncodingMetadataKey is the user metadata key that marks objects compressed with the given content encoding
+const s3EncodingMetadataKey = "hishtory-encoding"
+
+// The first client version that decompresses objects. Older clients fail to parse compressed objects, so
+// objects are only compressed when every device that reads them runs at least this version.
+var s3MinCompressionVersion = shared.ParsedVersion{MajorVersion: 0, MinorVersion: 336}
+
 // s3API defines the S3 operations used by S3Backend.
 // This interface allows for dependency injection of mock clients in tests.
 type s3API interface {
@@ -43,6 +60,10 @@ type S3Backend struct {
 	bucket string
 	prefix string // optional path prefix within bucket
 	userId string // derived from user secret, used as folder name
+
+	concurrency int    // maximum number of parallel requests, defaults to s3DefaultConcurrency if unset
+	compression string // content encoding to compress written objects with, or "" to write them uncompressed
+	version     string // the client version recorded for this device in devices.json
 }
 
 // NewS3Backend creates a new S3 backend with the given configuration.
@@ -83,6 +104,10 @@ func NewS3Backend(ctx context.Context, cfg *S3Config, userId string) (*S3Backend
 		bucket: cfg.Bucket,
 		prefix: strings.TrimSuffix(cfg.Prefix, "/"),
 		userId: userId,
+
+		concurrency: cfg.Concurrency,
+		compression: cfg.Compression,
+		version:     cfg.ClientVersion,
 	}, nil
 }
 
@@ -103,38 +128,31 @@ func (b *S3Backend) key(parts ...string) string {
 }
 
 // RegisterDevice registers a new device for the user.
-//
-// Known limitation: This function uses a read-modify-write pattern on devices.json
-// which is not atomic. If two devices register simultaneously, one registration could
-// be lost. In practice, this is rare since device registration typically happens once
-// during initial setup. If a device fails to register, re-running the setup will
-// succeed. A proper fix would require S3 conditional writes (ETags) or an external
-// locking mechanism (e.g., DynamoDB), which adds significant complexity.
+// devices.json is updated with a conditional write so that devices registering at the same time
+// don't overwrite each other's registrations.
 func (b *S3Backend) RegisterDevice(ctx context.Context, userId, deviceId string) error {
-	// Get current devices list
-	devices, err := b.getDevices(ctx)
-	if err != nil && !isNotFoundError(err) {
-		return fmt.Errorf("failed to get devices: %w", err)
-	}
-
-	// Check if device already exists
-	existingDeviceCount := len(devices.Devices)
-	for _, d := range devices.Devices {
-		if d.DeviceId == deviceId {
-			// Device already registered
-			return nil
+	existingDeviceCount := 0
+	var existingDevices []DeviceInfo
+	err := b.updateDevices(ctx, func(devices *DeviceList) bool {
+		existingDeviceCount = len(devices.Devices)
+		existingDevices = slices.Clone(devices.Devices)
+		for i, d := range devices.Devices {
+			if d.DeviceId == deviceId {
+				// Device already registered, so just make sure that its version is up to date
+				existingDeviceCount = 0
+				devices.Devices[i].Version = b.version
+				return d.Version != b.version
+			}
 		}
-	}
-
-	// Add new device
-	devices.Devices = append(devices.Devices, DeviceInfo{
-		DeviceId:         deviceId,
-		UserId:           userId,
-		RegistrationDate: time.Now().UTC().Format(time.RFC3339),
+		devices.Devices = append(devices.Devices, DeviceInfo{
+			DeviceId:         deviceId,
+			UserId:           userId,
+			RegistrationDate: time.Now().UTC().Format(time.RFC3339),
+			Version:          b.version,
+		})
+		return true
 	})
-
-	// Save updated devices list
-	if err := b.putDevices(ctx, devices); err != nil {
+	if err != nil {
 		return fmt.Errorf("failed to save devices: %w", err)
 	}
 
@@ -145,7 +163,7 @@ func (b *S3Backend) RegisterDevice(ctx context.Context, userId, deviceId string)
 			RequestingDeviceId: deviceId,
 			RequestTime:        time.Now().UTC(),
 		}
-		if err := b.createDumpRequest(ctx, dumpReq); err != nil {
+		if err := b.createDumpRequest(ctx, dumpReq, existingDevices); err != nil {
 			return fmt.Errorf("failed to create dump request: %w", err)
 		}
 	}
@@ -153,37 +171,65 @@ func (b *S3Backend) RegisterDevice(ctx context.Context, userId, deviceId string)
 	return nil
 }
 
-// Bootstrap returns all history entries for a user.
+// Bootstrap returns all history entries for a user. Objects are downloaded in parallel, and progress is
+// reported to the ProgressFunc set by WithProgress.
 func (b *S3Backend) Bootstrap(ctx context.Context, _, _ string) ([]*shared.EncHistoryEntry, error) {
 	entriesPrefix := b.key("entries") + "/"
 	objects, err := b.listObjects(ctx, entriesPrefix)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list entries: %w", err)
 	}
+	// And the entries that GC packed into segments
+	segmentObjects, err := b.listObjects(ctx, b.key("segments")+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %w", err)
+	}
+	keys := objectKeys(append(objects, segmentObjects...))
+	contents, err := b.getObjects(ctx, keys, progressFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
 
-	// Download and parse each entry, deduplicating by EncryptedId
+	// Parse each entry, deduplicating by EncryptedId
 	seen := make(map[string]bool)
 	var entries []*shared.EncHistoryEntry
-
-	for _, obj := range objects {
-		data, err := b.getObject(ctx, *obj.Key)
-		if err != nil {
-			hctx.GetLogger().Warnf("S3Backend.Bootstrap: failed to read entry %s: %v", *obj.Key, err)
+	addEntry := func(entry *shared.EncHistoryEntry) {
+		// Deduplicate (same logic as server's AllHistoryEntriesForUser)
+		if seen[entry.EncryptedId] {
+			return
+		}
+		seen[entry.EncryptedId] = true
+		entries = append(entries, entry)
+	}
+	for i, data := range contents {
+		if data == nil && i >= len(objects) {
+			// A segment holds many entries, so retry it and fail rather than returning a partial history
+			data, err = b.retrySegment(ctx, keys[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		if data == nil {
 			continue
 		}
-
-		var entry shared.EncHistoryEntry
-		if err := json.Unmarshal(data, &entry); err != nil {
-			hctx.GetLogger().Warnf("S3Backend.Bootstrap: failed to unmarshal entry %s: %v", *obj.Key, err)
+		if i >= len(objects) {
+			var segment s3Segment
+			if err := json.Unmarshal(data, &segment); err != nil {
+				hctx.GetLogger().Warnf("S3Backend.Bootstrap: failed to unmarshal segment %s: %v", keys[i], err)
+				continue
+			}
+			for _, entry := range segment.Entries {
+				addEntry(entry)
+			}
 			continue
 		}
 
-		// Deduplicate (same logic as server's AllHistoryEntriesForUser)
-		if seen[entry.EncryptedId] {
+		var entry shared.EncHistoryEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			hctx.GetLogger().Warnf("S3Backend.Bootstrap: failed to unmarshal entry %s: %v", keys[i], err)
 			continue
 		}
-		seen[entry.EncryptedId] = true
-		entries = append(entries, &entry)
+		addEntry(&entry)
 	}
 
 	return entries, nil
@@ -203,26 +249,39 @@ func (b *S3Backend) SubmitEntries(ctx context.Context, entries []*shared.EncHist
 	if len(deviceList.Devices) == 0 {
 		return nil, fmt.Errorf("no devices registered for user")
 	}
+	if i := slices.IndexFunc(deviceList.Devices, func(d DeviceInfo) bool { return d.DeviceId == sourceDeviceId }); i >= 0 && deviceList.Devices[i].Version != b.version {
+		// Clients that predate recording versions drop the field when they rewrite devices.json, so record it again
+		if err := b.recordDeviceVersion(ctx, sourceDeviceId); err != nil {
+			hctx.GetLogger().Warnf("S3Backend: failed to record the version of device %s: %v", sourceDeviceId, err)
+		}
+	}
 
-	// For each entry, write to main entries store and each device's inbox
+	// Write each entry to the main entries store (master copy). Old clients read every entry in it when they
+	// bootstrap, so entries that they can't process are only stored once every registered device supports them.
 	for _, entry := range entries {
-		// Write to entries/ (master copy)
+		if slices.ContainsFunc(deviceList.Devices, func(d DeviceInfo) bool { return !shared.ClientSupportsEntry(d.Version, entry) }) {
+			continue
+		}
 		entryKey := b.key("entries", entry.Date.Format("2006-01-02"), entry.EncryptedId+".json")
 		entryData, err := json.Marshal(entry)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal entry: %w", err)
 		}
 
-		if err := b.putObject(ctx, entryKey, entryData); err != nil {
+		if err := b.putObject(ctx, entryKey, entryData, b.encodingFor(deviceList.Devices...)); err != nil {
 			return nil, fmt.Errorf("failed to write entry: %w", err)
 		}
+	}
 
-		// Write to each device's inbox (except source device)
-		for _, device := range deviceList.Devices {
-			if device.DeviceId == sourceDeviceId {
-				continue // Don't send to the device that created the entry
+	// And to each device's inbox (except source device)
+	err = b.fanOut(ctx, deviceList, func(device DeviceInfo) error {
+		if device.DeviceId == sourceDeviceId {
+			return nil // Don't send to the device that created the entry
+		}
+		for _, entry := range entries {
+			if !shared.ClientSupportsEntry(device.Version, entry) {
+				continue
 			}
-
 			entryCopy := *entry
 			entryCopy.DeviceId = device.DeviceId
 			entryCopy.IsFromSameDevice = false
@@ -230,12 +289,16 @@ func (b *S3Backend) SubmitEntries(ctx context.Context, entries []*shared.EncHist
 			inboxKey := b.key("inbox", device.DeviceId, entry.Date.Format("20060102T150405Z")+"_"+entry.EncryptedId+".json")
 			inboxData, err := json.Marshal(&entryCopy)
 			if err != nil {
-				return nil, fmt.Errorf("failed to marshal inbox entry: %w", err)
+				return fmt.Errorf("failed to marshal inbox entry: %w", err)
 			}
-			if err := b.putObject(ctx, inboxKey, inboxData); err != nil {
-				return nil, fmt.Errorf("failed to write inbox entry: %w", err)
+			if err := b.putObject(ctx, inboxKey, inboxData, b.encodingFor(device)); err != nil {
+				return fmt.Errorf("failed to write inbox entry: %w", err)
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Check for pending dump requests and deletion requests for source device
@@ -256,6 +319,17 @@ func (b *S3Backend) SubmitEntries(ctx context.Context, entries []*shared.EncHist
 
 // SubmitDump handles bulk transfer of entries to a requesting device.
 func (b *S3Backend) SubmitDump(ctx context.Context, entries []*shared.EncHistoryEntry, _, requestingDeviceId, sourceDeviceId string) error {
+	deviceList, err := b.getDevices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get devices: %w", err)
+	}
+	// A device that isn't registered is treated as running an old version, so its entries aren't compressed
+	requestingDevice := DeviceInfo{DeviceId: requestingDeviceId}
+	if i := slices.IndexFunc(deviceList.Devices, func(d DeviceInfo) bool { return d.DeviceId == requestingDeviceId }); i >= 0 {
+		requestingDevice = deviceList.Devices[i]
+	}
+	encoding := b.encodingFor(requestingDevice)
+
 	// Write all entries to requesting device's inbox
 	for _, entry := range entries {
 		entryCopy := *entry
@@ -266,7 +340,7 @@ func (b *S3Backend) SubmitDump(ctx context.Context, entries []*shared.EncHistory
 		if err != nil {
 			return fmt.Errorf("failed to marshal entry: %w", err)
 		}
-		if err := b.putObject(ctx, inboxKey, data); err != nil {
+		if err := b.putObject(ctx, inboxKey, data, encoding); err != nil {
 			return fmt.Errorf("failed to write inbox entry: %w", err)
 		}
 	}
@@ -285,26 +359,30 @@ func (b *S3Backend) QueryEntries(ctx context.Context, deviceId, _, _ string) ([]
 	if err != nil {
 		return nil, fmt.Errorf("failed to list inbox: %w", err)
 	}
+	keys := objectKeys(objects)
+	contents, err := b.getObjects(ctx, keys, nil)
+	if err != nil {
+		return nil, err
+	}
 
 	var entries []*shared.EncHistoryEntry
-
-	for _, obj := range objects {
-		data, err := b.getObject(ctx, *obj.Key)
-		if err != nil {
-			hctx.GetLogger().Warnf("S3Backend.QueryEntries: failed to read entry %s: %v", *obj.Key, err)
+	var keysToDelete []string
+	updates := make(map[string][]byte)
+	for i, data := range contents {
+		if data == nil {
 			continue
 		}
 
 		var entry shared.EncHistoryEntry
 		if err := json.Unmarshal(data, &entry); err != nil {
-			hctx.GetLogger().Warnf("S3Backend.QueryEntries: failed to unmarshal entry %s: %v", *obj.Key, err)
+			hctx.GetLogger().Warnf("S3Backend.QueryEntries: failed to unmarshal entry %s: %v", keys[i], err)
 			continue
 		}
 
 		// Skip entries that have already been read enough times
 		if entry.ReadCount >= readCountLimit {
 			// Clean up: delete entries that have exceeded the read count
-			_ = b.deleteObject(ctx, *obj.Key)
+			keysToDelete = append(keysToDelete, keys[i])
 			continue
 		}
 
@@ -314,15 +392,16 @@ func (b *S3Backend) QueryEntries(ctx context.Context, deviceId, _, _ string) ([]
 
 		// Update the entry in S3 with incremented read count, or delete if limit reached
 		if entry.ReadCount >= readCountLimit {
-			_ = b.deleteObject(ctx, *obj.Key)
+			keysToDelete = append(keysToDelete, keys[i])
 		} else {
 			// Write back with updated read count
 			updatedData, err := json.Marshal(&entry)
 			if err == nil {
-				_ = b.putObject(ctx, *obj.Key, updatedData)
+				updates[keys[i]] = updatedData
 			}
 		}
 	}
+	b.applyUpdates(ctx, "QueryEntries", updates, keysToDelete)
 
 	return entries, nil
 }
@@ -337,25 +416,30 @@ func (b *S3Backend) GetDeletionRequests(ctx context.Context, _, deviceId string)
 	if err != nil {
 		return nil, err
 	}
+	keys := objectKeys(objects)
+	contents, err := b.getObjects(ctx, keys, nil)
+	if err != nil {
+		return nil, err
+	}
 
 	var requests []*shared.DeletionRequest
-	for _, obj := range objects {
-		data, err := b.getObject(ctx, *obj.Key)
-		if err != nil {
-			hctx.GetLogger().Warnf("S3Backend.GetDeletionRequests: failed to read request %s: %v", *obj.Key, err)
+	var keysToDelete []string
+	updates := make(map[string][]byte)
+	for i, data := range contents {
+		if data == nil {
 			continue
 		}
 
 		var req shared.DeletionRequest
 		if err := json.Unmarshal(data, &req); err != nil {
-			hctx.GetLogger().Warnf("S3Backend.GetDeletionRequests: failed to unmarshal request %s: %v", *obj.Key, err)
+			hctx.GetLogger().Warnf("S3Backend.GetDeletionRequests: failed to unmarshal request %s: %v", keys[i], err)
 			continue
 		}
 
 		// Skip requests that have already been read enough times
 		if req.ReadCount >= readCountLimit {
 			// Clean up: delete requests that have exceeded the read count
-			_ = b.deleteObject(ctx, *obj.Key)
+			keysToDelete = append(keysToDelete, keys[i])
 			continue
 		}
 
@@ -365,15 +449,16 @@ func (b *S3Backend) GetDeletionRequests(ctx context.Context, _, deviceId string)
 
 		// Update the request in S3 with incremented read count, or delete if limit reached
 		if req.ReadCount >= readCountLimit {
-			_ = b.deleteObject(ctx, *obj.Key)
+			keysToDelete = append(keysToDelete, keys[i])
 		} else {
 			// Write back with updated read count
 			updatedData, err := json.Marshal(&req)
 			if err == nil {
-				_ = b.putObject(ctx, *obj.Key, updatedData)
+				updates[keys[i]] = updatedData
 			}
 		}
 	}
+	b.applyUpdates(ctx, "GetDeletionRequests", updates, keysToDelete)
 
 	return requests, nil
 }
@@ -387,7 +472,7 @@ func (b *S3Backend) AddDeletionRequest(ctx context.Context, request shared.Delet
 	}
 
 	// Create deletion request for each device
-	for _, device := range deviceList.Devices {
+	writeDeletionRequest := func(device DeviceInfo) error {
 		reqCopy := request
 		reqCopy.DestinationDeviceId = device.DeviceId
 		reqCopy.ReadCount = 0
@@ -401,9 +486,15 @@ func (b *S3Backend) AddDeletionRequest(ctx context.Context, request shared.Delet
 		if err != nil {
 			return fmt.Errorf("failed to marshal deletion request: %w", err)
 		}
-		if err := b.putObject(ctx, key, data); err != nil {
+		if err := b.putObject(ctx, key, data, b.encodingFor(device)); err != nil {
 			return fmt.Errorf("failed to write deletion request: %w", err)
 		}
+		return nil
+	}
+	for _, device := range deviceList.Devices {
+		if err := writeDeletionRequest(device); err != nil {
+			return err
+		}
 	}
 
 	// Also delete the entries from the main entries store
@@ -442,6 +533,26 @@ func (b *S3Backend) AddDeletionRequest(ctx context.Context, request shared.Delet
 				return fmt.Errorf("failed to delete entries: %w", err)
 			}
 		}
+
+		// And from segments. This must happen after deleting the individual entries, since GC may be packing
+		// them into a new segment concurrently (see compactEntries).
+		segments, err := b.getSegments(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get segments for deletion: %w", err)
+		}
+		for key, segment := range segments {
+			if !slices.ContainsFunc(segment.Entries, func(e *shared.EncHistoryEntry) bool { return idsToDelete[e.EncryptedId] }) {
+				continue
+			}
+			err := b.updateSegment(ctx, key, func(s *s3Segment) bool {
+				n := len(s.Entries)
+				s.Entries = slices.DeleteFunc(s.Entries, func(e *shared.EncHistoryEntry) bool { return idsToDelete[e.EncryptedId] })
+				return len(s.Entries) != n
+			})
+			if err != nil {
+				return fmt.Errorf("failed to delete entries from segment: %w", err)
+			}
+		}
 	}
 
 	// Also delete entries from all device inboxes
@@ -474,26 +585,32 @@ func (b *S3Backend) AddDeletionRequest(ctx context.Context, request shared.Delet
 		}
 	}
 
-	return nil
+	// Devices that registered while this was running may have bootstrapped before the entries were
+	// deleted, so they also need the deletion request. Devices that register after this point can't
+	// bootstrap the deleted entries.
+	return b.fanOut(ctx, deviceList, func(device DeviceInfo) error {
+		if slices.ContainsFunc(deviceList.Devices, func(d DeviceInfo) bool { return d.DeviceId == device.DeviceId }) {
+			return nil
+		}
+		return writeDeletionRequest(device)
+	})
 }
 
 // Uninstall removes a device and its pending data.
 func (b *S3Backend) Uninstall(ctx context.Context, _, deviceId string) error {
 	// Remove device from devices list
-	deviceList, err := b.getDevices(ctx)
-	if err != nil {
-		return err
-	}
-
-	newDevices := make([]DeviceInfo, 0, len(deviceList.Devices))
-	for _, d := range deviceList.Devices {
-		if d.DeviceId != deviceId {
-			newDevices = append(newDevices, d)
+	err := b.updateDevices(ctx, func(devices *DeviceList) bool {
+		newDevices := make([]DeviceInfo, 0, len(devices.Devices))
+		for _, d := range devices.Devices {
+			if d.DeviceId != deviceId {
+				newDevices = append(newDevices, d)
+			}
 		}
-	}
-	deviceList.Devices = newDevices
-
-	if err := b.putDevices(ctx, deviceList); err != nil {
+		changed := len(newDevices) != len(devices.Devices)
+		devices.Devices = newDevices
+		return changed
+	})
+	if err != nil {
 		return err
 	}
 
@@ -534,37 +651,106 @@ func (b *S3Backend) Ping(ctx context.Context) error {
 // Helper methods for S3 operations
 
 func (b *S3Backend) getDevices(ctx context.Context) (*DeviceList, error) {
+	devices, _, err := b.getDevicesWithETag(ctx)
+	return devices, err
+}
+
+// getDevicesWithETag returns the registered devices and the ETag of devices.json, which is empty if
+// devices.json doesn't exist yet.
+func (b *S3Backend) getDevicesWithETag(ctx context.Context) (*DeviceList, string, error) {
 	key := b.key("devices.json")
-	data, err := b.getObject(ctx, key)
+	data, etag, err := b.getObjectWithETag(ctx, key)
 	if err != nil {
 		if isNotFoundError(err) {
-			return &DeviceList{}, nil
+			return &DeviceList{}, "", nil
 		}
-		return nil, err
+		return nil, "", err
 	}
 
 	var devices DeviceList
 	if err := json.Unmarshal(data, &devices); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal devices: %w", err)
+		return nil, "", fmt.Errorf("failed to unmarshal devices: %w", err)
 	}
-	return &devices, nil
+	return &devices, etag, nil
 }
 
-func (b *S3Backend) putDevices(ctx context.Context, devices *DeviceList) error {
-	data, err := json.Marshal(devices)
-	if err != nil {
-		return fmt.Errorf("failed to marshal devices: %w", err)
+// updateDevices applies update to devices.json with a conditional write, retrying if another device
+// modified it concurrently. update returns whether it modified the device list.
+func (b *S3Backend) updateDevices(ctx context.Context, update func(*DeviceList) bool) error {
+	for attempt := 1; ; attempt++ {
+		devices, etag, err := b.getDevicesWithETag(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get devices: %w", err)
+		}
+		if !update(devices) {
+			return nil
+		}
+		data, err := json.Marshal(devices)
+		if err != nil {
+			return fmt.Errorf("failed to marshal devices: %w", err)
+		}
+		// devices.json is never compressed, since every client reads it to find out which devices can decompress
+		// objects
+		err = b.putObjectIfUnchanged(ctx, b.key("devices.json"), data, etag, "")
+		if !isPreconditionFailedError(err) || attempt >= s3MaxConditionalWriteAttempts {
+			return err
+		}
+		hctx.GetLogger().Infof("S3Backend: devices.json was modified concurrently, retrying (attempt %d)", attempt)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt*(10+rand.Intn(100))) * time.Millisecond):
+		}
 	}
-	return b.putObject(ctx, b.key("devices.json"), data)
 }
 
-func (b *S3Backend) createDumpRequest(ctx context.Context, req *shared.DumpRequest) error {
+// recordDeviceVersion updates the version that devices.json records for the given device
+func (b *S3Backend) recordDeviceVersion(ctx context.Context, deviceId string) error {
+	return b.updateDevices(ctx, func(devices *DeviceList) bool {
+		for i, d := range devices.Devices {
+			if d.DeviceId == deviceId && d.Version != b.version {
+				devices.Devices[i].Version = b.version
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// fanOut calls write for each of the given devices, and then for any devices that registered in the
+// meantime. Without this, a device that registers concurrently could miss the write while also
+// bootstrapping before it happened.
+func (b *S3Backend) fanOut(ctx context.Context, devices *DeviceList, write func(DeviceInfo) error) error {
+	done := make(map[string]bool)
+	for {
+		for _, device := range devices.Devices {
+			if done[device.DeviceId] {
+				continue
+			}
+			if err := write(device); err != nil {
+				return err
+			}
+			done[device.DeviceId] = true
+		}
+		latest, err := b.getDevices(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get devices: %w", err)
+		}
+		if !slices.ContainsFunc(latest.Devices, func(d DeviceInfo) bool { return !done[d.DeviceId] }) {
+			return nil
+		}
+		devices = latest
+	}
+}
+
+// createDumpRequest writes a dump request, which is read by the given devices
+func (b *S3Backend) createDumpRequest(ctx context.Context, req *shared.DumpRequest, devices []DeviceInfo) error {
 	key := b.key("dump_requests", req.RequestingDeviceId+".json")
 	data, err := json.Marshal(req)
 	if err != nil {
 		return err
 	}
-	return b.putObject(ctx, key, data)
+	return b.putObject(ctx, key, data, b.encodingFor(devices...))
 }
 
 func (b *S3Backend) getDumpRequests(ctx context.Context, sourceDeviceId string) ([]*shared.DumpRequest, error) {
@@ -602,27 +788,185 @@ func (b *S3Backend) deleteDumpRequest(ctx context.Context, deviceId string) erro
 }
 
 func (b *S3Backend) getObject(ctx context.Context, key string) ([]byte, error) {
+	data, _, err := b.getObjectWithETag(ctx, key)
+	return data, err
+}
+
+func (b *S3Backend) getObjectWithETag(ctx context.Context, key string) ([]byte, string, error) {
 	result, err := b.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(b.bucket),
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer result.Body.Close()
-	return io.ReadAll(result.Body)
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	// Objects are decompressed regardless of b.compression, since other devices may be configured differently
+	if encoding := result.Metadata[s3EncodingMetadataKey]; encoding != "" {
+		data, err = shared.Decompress(encoding, data)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decompress %s: %w", key, err)
+		}
+	}
+	return data, aws.ToString(result.ETag), nil
+}
+
+// encodingFor returns the content encoding to compress objects that are read by the given devices with. This is
+// b.compression if all of them support compression, and otherwise "" so that the objects aren't compressed.
+func (b *S3Backend) encodingFor(readers ...DeviceInfo) string {
+	if b.compression == "" {
+		return ""
+	}
+	for _, d := range readers {
+		if !shared.ClientVersionAtLeast(d.Version, s3MinCompressionVersion) {
+			return ""
+		}
+	}
+	return b.compression
 }
 
-func (b *S3Backend) putObject(ctx context.Context, key string, data []byte) error {
-	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+// newPutObjectInput returns the input to write data to the given key, compressed with encoding unless it is ""
+func (b *S3Backend) newPutObjectInput(key string, data []byte, encoding string) (*s3.PutObjectInput, error) {
+	input := &s3.PutObjectInput{
 		Bucket:      aws.String(b.bucket),
 		Key:         aws.String(key),
-		Body:        bytes.NewReader(data),
 		ContentType: aws.String("application/json"),
+	}
+	if encoding != "" {
+		compressed, err := shared.Compress(encoding, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress %s: %w", key, err)
+		}
+		data = compressed
+		input.Metadata = map[string]string{s3EncodingMetadataKey: encoding}
+	}
+	input.Body = bytes.NewReader(data)
+	return input, nil
+}
+
+// putObject writes an object, compressed with encoding unless it is "". Use encodingFor to pick an encoding that
+// every device that reads the object supports.
+func (b *S3Backend) putObject(ctx context.Context, key string, data []byte, encoding string) error {
+	input, err := b.newPutObjectInput(key, data, encoding)
+	if err != nil {
+		return err
+	}
+	_, err = b.client.PutObject(ctx, input)
+	return err
+}
+
+// putObjectIfUnchanged writes an object only if its ETag still matches etag, or if it doesn't exist when
+// etag is empty. Returns an error for which isPreconditionFailedError is true if the object was modified.
+func (b *S3Backend) putObjectIfUnchanged(ctx context.Context, key string, data []byte, etag, encoding string) error {
+	input, err := b.newPutObjectInput(key, data, encoding)
+	if err != nil {
+		return err
+	}
+	if etag != "" {
+		input.IfMatch = aws.String(etag)
+	} else {
+		input.IfNoneMatch = aws.String("*")
+	}
+	_, err = b.client.PutObject(ctx, input)
+	if isNotImplementedError(err) {
+		// Some S3-compatible services don't support conditional writes
+		hctx.GetLogger().Warnf("S3Backend: conditional writes are not supported, writing %s unconditionally", key)
+		return b.putObject(ctx, key, data, encoding)
+	}
+	return err
+}
+
+// getObjects downloads the given objects in parallel, calling onProgress (if non-nil) as they complete. Objects
+// that can't be read are logged and returned as nil, so that one bad object doesn't prevent syncing.
+func (b *S3Backend) getObjects(ctx context.Context, keys []string, onProgress ProgressFunc) ([][]byte, error) {
+	contents := make([][]byte, len(keys))
+	var mu sync.Mutex
+	done := 0
+	err := b.forEachParallel(ctx, len(keys), func(i int) {
+		data, err := b.getObject(ctx, keys[i])
+		if err != nil {
+			if ctx.Err() == nil {
+				hctx.GetLogger().Warnf("S3Backend: failed to read %s: %v", keys[i], err)
+			}
+		} else {
+			contents[i] = data
+		}
+		if onProgress != nil {
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			onProgress(done, len(keys))
+		}
 	})
+	if err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// applyUpdates writes back the given objects in parallel and deletes the given keys in batches. Failures are
+// only logged, since the objects are re-read on the next sync.
+func (b *S3Backend) applyUpdates(ctx context.Context, caller string, updates map[string][]byte, keysToDelete []string) {
+	keys := make([]string, 0, len(updates))
+	for key := range updates {
+		keys = append(keys, key)
+	}
+	// The updated objects are in this device's own inbox or deletions, so only this device reads them
+	encoding := b.encodingFor(DeviceInfo{Version: b.version})
+	_ = b.forEachParallel(ctx, len(keys), func(i int) {
+		if err := b.putObject(ctx, keys[i], updates[keys[i]], encoding); err != nil {
+			hctx.GetLogger().Warnf("S3Backend.%s: failed to update %s: %v", caller, keys[i], err)
+		}
+	})
+	if err := b.deleteObjects(ctx, keysToDelete); err != nil {
+		hctx.GetLogger().Warnf("S3Backend.%s: failed to delete %d objects: %v", caller, len(keysToDelete), err)
+	}
+}
+
+// forEachParallel calls fn for each index in [0, n) using up to b.concurrency goroutines. Returns ctx.Err() if
+// ctx is cancelled before all calls have started.
+func (b *S3Backend) forEachParallel(ctx context.Context, n int, fn func(i int)) error {
+	workers := b.concurrency
+	if workers <= 0 {
+		workers = s3DefaultConcurrency
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	var err error
+	for i := 0; i < n && err == nil; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	close(indexes)
+	wg.Wait()
 	return err
 }
 
+func objectKeys(objects []types.Object) []string {
+	keys := make([]string, len(objects))
+	for i, obj := range objects {
+		keys[i] = aws.ToString(obj.Key)
+	}
+	return keys
+}
+
 func (b *S3Backend) deleteObject(ctx context.Context, key string) error {
 	_, err := b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(b.bucket),
@@ -682,6 +1026,23 @@ func (b *S3Backend) listObjects(ctx context.Context, prefix string) ([]types.Obj
 	return objects, nil
 }
 
+// isPreconditionFailedError checks if the error is due to a conditional write failing because the
+// object was modified concurrently.
+func isPreconditionFailedError(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		// S3 returns ConditionalRequestConflict if a conflicting write is still in progress
+		return apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "ConditionalRequestConflict"
+	}
+	return false
+}
+
+// isNotImplementedError checks if the error is due to the service not supporting a feature.
+func isNotImplementedError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented"
+}
+
 // isNotFoundError checks if the error is an S3 NoSuchKey error.
 func isNotFoundError(err error) bool {
 	if err == nil {
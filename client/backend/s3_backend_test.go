@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -18,59 +19,122 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 // MockS3Client implements the s3API interface for testing.
 type MockS3Client struct {
-	mu      sync.Mutex
-	objects map[string][]byte // key -> data
+	mu       sync.Mutex
+	objects  map[string][]byte            // key -> data
+	etags    map[string]string            // key -> etag
+	metadata map[string]map[string]string // key -> user metadata
+	version  int
 
 	// For tracking calls and simulating errors
 	headBucketCalled bool
 	headBucketErr    error
+
+	// getDelay simulates network latency for GetObject, and maxGetsInFlight records the peak number of
+	// concurrent GetObject calls
+	getDelay        time.Duration
+	getsInFlight    int
+	maxGetsInFlight int
+	// The number of DeleteObject and DeleteObjects calls
+	deleteCalls int
+	// failGets is the number of upcoming GetObject calls that fail for each key
+	failGets map[string]int
+
+	// beforePut is called at the start of each PutObject, outside of the lock, to simulate
+	// another device acting concurrently
+	beforePut func(key string)
 }
 
 func NewMockS3Client() *MockS3Client {
 	return &MockS3Client{
-		objects: make(map[string][]byte),
+		objects:  make(map[string][]byte),
+		etags:    make(map[string]string),
+		metadata: make(map[string]map[string]string),
+		failGets: make(map[string]int),
 	}
 }
 
 func (m *MockS3Client) GetObject(ctx context.Context, input *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if m.getDelay > 0 {
+		m.mu.Lock()
+		m.getsInFlight++
+		m.maxGetsInFlight = max(m.maxGetsInFlight, m.getsInFlight)
+		m.mu.Unlock()
+		time.Sleep(m.getDelay)
+		defer func() {
+			m.mu.Lock()
+			m.getsInFlight--
+			m.mu.Unlock()
+		}()
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	key := aws.ToString(input.Key)
+	if m.failGets[key] > 0 {
+		m.failGets[key]--
+		return nil, fmt.Errorf("simulated failure to get %s", key)
+	}
 	data, ok := m.objects[key]
 	if !ok {
 		return nil, &types.NoSuchKey{}
 	}
 	return &s3.GetObjectOutput{
-		Body: io.NopCloser(bytes.NewReader(data)),
+		Body:     io.NopCloser(bytes.NewReader(data)),
+		ETag:     aws.String(m.etags[key]),
+		Metadata: m.metadata[key],
 	}, nil
 }
 
 func (m *MockS3Client) PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	key := aws.ToString(input.Key)
+	if m.beforePut != nil {
+		m.beforePut(key)
+	}
+	etag, err := m.putObject(key, input)
+	if err != nil {
+		return nil, err
+	}
+	return &s3.PutObjectOutput{ETag: aws.String(etag)}, nil
+}
+
+func (m *MockS3Client) putObject(key string, input *s3.PutObjectInput) (string, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	key := aws.ToString(input.Key)
 	data, err := io.ReadAll(input.Body)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	_, exists := m.objects[key]
+	if input.IfMatch != nil && (!exists || aws.ToString(input.IfMatch) != m.etags[key]) {
+		return "", &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold"}
+	}
+	if aws.ToString(input.IfNoneMatch) == "*" && exists {
+		return "", &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold"}
 	}
+	m.version++
 	m.objects[key] = data
-	return &s3.PutObjectOutput{}, nil
+	m.metadata[key] = input.Metadata
+	m.etags[key] = fmt.Sprintf("\"%d\"", m.version)
+	return m.etags[key], nil
 }
 
 func (m *MockS3Client) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, opts ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.deleteCalls++
 	key := aws.ToString(input.Key)
 	delete(m.objects, key)
+	delete(m.etags, key)
+	delete(m.metadata, key)
 	return &s3.DeleteObjectOutput{}, nil
 }
 
@@ -78,9 +142,12 @@ func (m *MockS3Client) DeleteObjects(ctx context.Context, input *s3.DeleteObject
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.deleteCalls++
 	for _, obj := range input.Delete.Objects {
 		key := aws.ToString(obj.Key)
 		delete(m.objects, key)
+		delete(m.etags, key)
+		delete(m.metadata, key)
 	}
 	return &s3.DeleteObjectsOutput{}, nil
 }
@@ -101,7 +168,7 @@ func (m *MockS3Client) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV
 	var objects []types.Object
 	for key := range m.objects {
 		if strings.HasPrefix(key, prefix) {
-			objects = append(objects, types.Object{Key: aws.String(key)})
+			objects = append(objects, types.Object{Key: aws.String(key), Size: aws.Int64(int64(len(m.objects[key])))})
 		}
 	}
 	return &s3.ListObjectsV2Output{
@@ -242,6 +309,25 @@ func TestS3ConfigValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "with compression",
+			config: S3Config{
+				Bucket:      "my-bucket",
+				Region:      "us-east-1",
+				Compression: "zstd",
+			},
+			wantErr: false,
+		},
+		{
+			name: "unsupported compression",
+			config: S3Config{
+				Bucket:      "my-bucket",
+				Region:      "us-east-1",
+				Compression: "brotli",
+			},
+			wantErr: true,
+			errMsg:  "S3 compression must be",
+		},
 	}
 
 	for _, tt := range tests {
@@ -310,6 +396,80 @@ func TestS3BackendRegisterDevice(t *testing.T) {
 		require.NoError(t, err)
 		assert.Len(t, devices.Devices, 1)
 	})
+
+	t.Run("concurrent registrations are not lost", func(t *testing.T) {
+		b := NewTestableS3Backend("user123", "")
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device0"))
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 20)
+		for i := 1; i <= 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- b.RegisterDevice(ctx, "user123", fmt.Sprintf("device%d", i))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		devices, err := b.getDevices(ctx)
+		require.NoError(t, err)
+		assert.Len(t, devices.Devices, 21)
+		dumpRequests, err := b.getDumpRequests(ctx, "device0")
+		require.NoError(t, err)
+		assert.Len(t, dumpRequests, 20)
+	})
+
+	t.Run("stale writes are rejected", func(t *testing.T) {
+		b := NewTestableS3Backend("user123", "")
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+		_, etag, err := b.getDevicesWithETag(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, etag)
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+
+		err = b.putObjectIfUnchanged(ctx, b.key("devices.json"), []byte(`{"devices":[]}`), etag, "")
+		assert.True(t, isPreconditionFailedError(err))
+		err = b.putObjectIfUnchanged(ctx, b.key("devices.json"), []byte(`{"devices":[]}`), "", "")
+		assert.True(t, isPreconditionFailedError(err))
+
+		devices, err := b.getDevices(ctx)
+		require.NoError(t, err)
+		assert.Len(t, devices.Devices, 2)
+	})
+
+	t.Run("registration during uninstall is not lost", func(t *testing.T) {
+		b := NewTestableS3Backend("user123", "")
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+
+		// device3 registers after device2 has read devices.json but before it writes it back
+		registerDuringPut(t, b, "devices.json", "device3")
+		require.NoError(t, b.Uninstall(ctx, "user123", "device2"))
+
+		devices, err := b.getDevices(ctx)
+		require.NoError(t, err)
+		require.Len(t, devices.Devices, 2)
+		assert.Equal(t, "device1", devices.Devices[0].DeviceId)
+		assert.Equal(t, "device3", devices.Devices[1].DeviceId)
+	})
+}
+
+// registerDuringPut registers deviceId with another backend the first time an object whose key
+// ends with keySuffix is written, simulating a device that registers concurrently.
+func registerDuringPut(t *testing.T, b *S3Backend, keySuffix, deviceId string) {
+	mock := b.client.(*MockS3Client)
+	other := &S3Backend{client: mock, bucket: b.bucket, prefix: b.prefix, userId: b.userId}
+	mock.beforePut = func(key string) {
+		if strings.HasSuffix(key, keySuffix) {
+			mock.beforePut = nil
+			require.NoError(t, other.RegisterDevice(context.Background(), b.userId, deviceId))
+		}
+	}
 }
 
 func TestS3BackendSubmitEntries(t *testing.T) {
@@ -354,6 +514,62 @@ func TestS3BackendSubmitEntries(t *testing.T) {
 		}
 	})
 
+	t.Run("fans out to devices that register concurrently", func(t *testing.T) {
+		b := NewTestableS3Backend("user123", "")
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+
+		// device2 registers after the device list was read but before the entry was fanned out
+		registerDuringPut(t, b, "entry1.json", "device2")
+		entries := []*shared.EncHistoryEntry{{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()}}
+		_, err := b.SubmitEntries(ctx, entries, "device1")
+		require.NoError(t, err)
+
+		device2Entries, err := b.QueryEntries(ctx, "device2", "user123", "test")
+		require.NoError(t, err)
+		require.Len(t, device2Entries, 1)
+		assert.Equal(t, "entry1", device2Entries[0].EncryptedId)
+	})
+
+	t.Run("query history is only sent to devices that support it", func(t *testing.T) {
+		b := NewTestableS3Backend("user123", "")
+		b.version = "v0.336"
+		oldClient := &S3Backend{client: b.client, bucket: b.bucket, userId: b.userId}
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+		require.NoError(t, oldClient.RegisterDevice(ctx, "user123", "device2"))
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device3"))
+
+		entries := []*shared.EncHistoryEntry{
+			{EncryptedId: "entry1", Date: time.Now()},
+			{EncryptedId: shared.QueryHistoryIdPrefix + "query1", Date: time.Now()},
+		}
+		_, err := b.SubmitEntries(ctx, entries, "device1")
+		require.NoError(t, err)
+
+		device2Entries, err := oldClient.QueryEntries(ctx, "device2", "user123", "test")
+		require.NoError(t, err)
+		require.Len(t, device2Entries, 1)
+		assert.Equal(t, "entry1", device2Entries[0].EncryptedId)
+		device3Entries, err := b.QueryEntries(ctx, "device3", "user123", "test")
+		require.NoError(t, err)
+		require.Len(t, device3Entries, 2)
+
+		// And it isn't stored for bootstrapping, since device2 would read it when reinstalling
+		bootstrapEntries, err := oldClient.Bootstrap(ctx, "user123", "device2")
+		require.NoError(t, err)
+		require.Len(t, bootstrapEntries, 1)
+
+		// Once device2 is upgraded, it records its new version and receives query history
+		_, err = b.SubmitEntries(ctx, []*shared.EncHistoryEntry{{EncryptedId: "entry2", Date: time.Now()}}, "device2")
+		require.NoError(t, err)
+		_, err = b.SubmitEntries(ctx, []*shared.EncHistoryEntry{{EncryptedId: shared.QueryHistoryIdPrefix + "query2", Date: time.Now()}}, "device1")
+		require.NoError(t, err)
+		device2Entries, err = b.QueryEntries(ctx, "device2", "user123", "test")
+		require.NoError(t, err)
+		assert.True(t, slices.ContainsFunc(device2Entries, func(e *shared.EncHistoryEntry) bool {
+			return e.EncryptedId == shared.QueryHistoryIdPrefix+"query2"
+		}))
+	})
+
 	t.Run("empty entries returns early", func(t *testing.T) {
 		b := NewTestableS3Backend("user123", "")
 
@@ -410,7 +626,7 @@ func TestS3BackendQueryEntries(t *testing.T) {
 		}
 		entryData, _ := json.Marshal(entry)
 		inboxKey := b.key("inbox", "device1", "20240115T103000Z_entry1.json")
-		require.NoError(t, b.putObject(ctx, inboxKey, entryData))
+		require.NoError(t, b.putObject(ctx, inboxKey, entryData, ""))
 
 		// First query should return the entry with ReadCount=1
 		entries, err := b.QueryEntries(ctx, "device1", "user123", "test")
@@ -467,7 +683,7 @@ func TestS3BackendQueryEntries(t *testing.T) {
 		}
 		entryData, _ := json.Marshal(entry)
 		inboxKey := b.key("inbox", "device1", "20240115T103000Z_entry1.json")
-		require.NoError(t, b.putObject(ctx, inboxKey, entryData))
+		require.NoError(t, b.putObject(ctx, inboxKey, entryData, ""))
 
 		// Query should not return the entry (it's at limit)
 		entries, err := b.QueryEntries(ctx, "device1", "user123", "test")
@@ -478,6 +694,22 @@ func TestS3BackendQueryEntries(t *testing.T) {
 		_, err = b.getObject(ctx, inboxKey)
 		assert.Error(t, err)
 	})
+
+	t.Run("batches deletes", func(t *testing.T) {
+		b := NewTestableS3Backend("user123", "")
+		for i := 0; i < 2500; i++ {
+			entry := &shared.EncHistoryEntry{EncryptedId: fmt.Sprintf("entry%d", i), ReadCount: readCountLimit - 1}
+			data, _ := json.Marshal(entry)
+			require.NoError(t, b.putObject(ctx, b.key("inbox", "device1", fmt.Sprintf("20240115T103000Z_entry%d.json", i)), data, ""))
+		}
+
+		entries, err := b.QueryEntries(ctx, "device1", "user123", "test")
+		require.NoError(t, err)
+		assert.Len(t, entries, 2500)
+		// All entries reached the read count limit, and were deleted in batches of 1000
+		assert.Equal(t, 3, b.client.(*MockS3Client).deleteCalls)
+		assert.Equal(t, 0, countObjects(t, b, "inbox"))
+	})
 }
 
 func TestS3BackendBootstrap(t *testing.T) {
@@ -495,7 +727,7 @@ func TestS3BackendBootstrap(t *testing.T) {
 		for _, entry := range entries {
 			data, _ := json.Marshal(entry)
 			key := b.key("entries", entry.Date.Format("2006-01-02"), entry.EncryptedId+".json")
-			require.NoError(t, b.putObject(ctx, key, data))
+			require.NoError(t, b.putObject(ctx, key, data, ""))
 		}
 
 		result, err := b.Bootstrap(ctx, "user123", "device1")
@@ -512,13 +744,159 @@ func TestS3BackendBootstrap(t *testing.T) {
 
 		key1 := b.key("entries", "2024-01-15", "entry1.json")
 		key2 := b.key("entries", "2024-01-16", "entry1.json")
-		require.NoError(t, b.putObject(ctx, key1, data))
-		require.NoError(t, b.putObject(ctx, key2, data))
+		require.NoError(t, b.putObject(ctx, key1, data, ""))
+		require.NoError(t, b.putObject(ctx, key2, data, ""))
 
 		result, err := b.Bootstrap(ctx, "user123", "device1")
 		require.NoError(t, err)
 		assert.Len(t, result, 1) // Should deduplicate
 	})
+
+	t.Run("downloads in parallel at scale", func(t *testing.T) {
+		b := NewTestableS3Backend("user123", "")
+		b.concurrency = 8
+		const numEntries = 20000
+		for i := 0; i < numEntries; i++ {
+			entry := &shared.EncHistoryEntry{EncryptedId: fmt.Sprintf("entry%d", i), DeviceId: "device1", Date: time.Now()}
+			data, _ := json.Marshal(entry)
+			require.NoError(t, b.putObject(ctx, b.key("entries", "2024-01-15", entry.EncryptedId+".json"), data, ""))
+		}
+		mock := b.client.(*MockS3Client)
+		mock.getDelay = 50 * time.Microsecond
+
+		var progress []int
+		progressCtx := WithProgress(ctx, func(done, total int) {
+			assert.Equal(t, numEntries, total)
+			progress = append(progress, done)
+		})
+		result, err := b.Bootstrap(progressCtx, "user123", "device1")
+		require.NoError(t, err)
+		assert.Len(t, result, numEntries)
+		assert.Equal(t, 8, mock.maxGetsInFlight)
+		require.Len(t, progress, numEntries)
+		assert.Equal(t, numEntries, progress[numEntries-1])
+	})
+
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		b := NewTestableS3Backend("user123", "")
+		b.concurrency = 2
+		for i := 0; i < 100; i++ {
+			require.NoError(t, b.putObject(ctx, b.key("entries", "2024-01-15", fmt.Sprintf("entry%d.json", i)), []byte("{}"), ""))
+		}
+		cancelCtx, cancel := context.WithCancel(ctx)
+		progressCtx := WithProgress(cancelCtx, func(done, total int) {
+			if done == 10 {
+				cancel()
+			}
+		})
+		_, err := b.Bootstrap(progressCtx, "user123", "device1")
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestS3BackendCompression(t *testing.T) {
+	ctx := context.Background()
+	uncompressed := NewTestableS3Backend("user123", "")
+	entry := &shared.EncHistoryEntry{EncryptedId: "entry1", EncryptedData: bytes.Repeat([]byte("a"), 1000), DeviceId: "device1", Date: time.Now()}
+	data, err := json.Marshal(entry)
+	require.NoError(t, err)
+
+	for _, encoding := range []string{shared.EncodingGzip, shared.EncodingZstd} {
+		t.Run(encoding, func(t *testing.T) {
+			// Share the bucket with a device that doesn't compress objects
+			b := NewTestableS3Backend("user123", "")
+			b.client = uncompressed.client
+			b.compression = encoding
+			mock := b.client.(*MockS3Client)
+			key := b.key("entries", "2024-01-15", encoding+".json")
+			require.NoError(t, b.putObject(ctx, key, data, encoding))
+			assert.Equal(t, encoding, mock.metadata[key][s3EncodingMetadataKey])
+			assert.Less(t, len(mock.objects[key]), len(data))
+
+			for _, reader := range []*S3Backend{b, uncompressed} {
+				read, err := reader.getObject(ctx, key)
+				require.NoError(t, err)
+				assert.Equal(t, data, read)
+			}
+		})
+	}
+
+	// Objects written without compression are still readable by a device that compresses objects
+	key := uncompressed.key("entries", "2024-01-15", "uncompressed.json")
+	require.NoError(t, uncompressed.putObject(ctx, key, data, ""))
+	assert.Empty(t, uncompressed.client.(*MockS3Client).metadata[key])
+	b := NewTestableS3Backend("user123", "")
+	b.client = uncompressed.client
+	b.compression = shared.EncodingZstd
+	read, err := b.getObject(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+
+	// Conditional writes are compressed too
+	segmentKey := b.key("segments", "1.json")
+	require.NoError(t, b.putObjectIfUnchanged(ctx, segmentKey, data, "", b.compression))
+	assert.Equal(t, b.compression, b.client.(*MockS3Client).metadata[segmentKey][s3EncodingMetadataKey])
+	read, err = uncompressed.getObject(ctx, segmentKey)
+	require.NoError(t, err)
+	assert.Equal(t, data, read)
+}
+
+func TestS3BackendCompressionWithOldDevices(t *testing.T) {
+	ctx := context.Background()
+	b := NewTestableS3Backend("user123", "")
+	b.compression = shared.EncodingZstd
+	b.version = "v0.336"
+	mock := b.client.(*MockS3Client)
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	// device2 runs a version of hishtory that predates compression
+	oldDevice := NewTestableS3Backend("user123", "")
+	oldDevice.client = b.client
+	oldDevice.version = "v0.335"
+	require.NoError(t, oldDevice.RegisterDevice(ctx, "user123", "device2"))
+	newDevice := NewTestableS3Backend("user123", "")
+	newDevice.client = b.client
+	newDevice.version = "v0.336"
+	require.NoError(t, newDevice.RegisterDevice(ctx, "user123", "device3"))
+
+	// Objects that the old device reads aren't compressed, since it would fail to parse them
+	isCompressed := func(key string) bool {
+		_, ok := mock.metadata[key][s3EncodingMetadataKey]
+		return ok
+	}
+	requireParseable := func(key string) {
+		require.Contains(t, mock.objects, key)
+		require.False(t, isCompressed(key), key)
+		require.True(t, json.Valid(mock.objects[key]), key)
+	}
+	entry := &shared.EncHistoryEntry{EncryptedId: "entry1", EncryptedData: bytes.Repeat([]byte("a"), 1000), DeviceId: "device1", Date: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)}
+	_, err := b.SubmitEntries(ctx, []*shared.EncHistoryEntry{entry}, "device1")
+	require.NoError(t, err)
+	requireParseable(b.key("devices.json"))
+	requireParseable(b.key("dump_requests", "device2.json"))
+	requireParseable(b.key("entries", "2024-01-15", "entry1.json"))
+	requireParseable(b.key("inbox", "device2", "20240115T103000Z_entry1.json"))
+	require.NoError(t, b.AddDeletionRequest(ctx, shared.DeletionRequest{UserId: "user123", Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{EntryId: "entry2"}}}}))
+	deletions, err := b.listObjects(ctx, b.key("deletions", "device2")+"/")
+	require.NoError(t, err)
+	require.Len(t, deletions, 1)
+	requireParseable(*deletions[0].Key)
+
+	// While objects that only newer devices read are compressed
+	assert.True(t, isCompressed(b.key("inbox", "device3", "20240115T103000Z_entry1.json")))
+	deletions, err = b.listObjects(ctx, b.key("deletions", "device3")+"/")
+	require.NoError(t, err)
+	require.Len(t, deletions, 1)
+	assert.True(t, isCompressed(*deletions[0].Key))
+
+	// And once the old device is upgraded, everything other than devices.json is compressed
+	oldDevice.version = "v0.336"
+	require.NoError(t, oldDevice.RegisterDevice(ctx, "user123", "device2"))
+	entry.EncryptedId = "entry3"
+	_, err = b.SubmitEntries(ctx, []*shared.EncHistoryEntry{entry}, "device1")
+	require.NoError(t, err)
+	assert.True(t, isCompressed(b.key("entries", "2024-01-15", "entry3.json")))
+	assert.True(t, isCompressed(b.key("inbox", "device2", "20240115T103000Z_entry3.json")))
+	requireParseable(b.key("devices.json"))
 }
 
 func TestS3BackendPing(t *testing.T) {
@@ -568,7 +946,7 @@ func TestS3BackendAddDeletionRequest(t *testing.T) {
 			entry := &shared.EncHistoryEntry{EncryptedId: e.id, DeviceId: "device1", Date: time.Now()}
 			data, _ := json.Marshal(entry)
 			key := b.key("entries", e.date, e.id+".json")
-			require.NoError(t, b.putObject(ctx, key, data))
+			require.NoError(t, b.putObject(ctx, key, data, ""))
 		}
 
 		// Delete entry1 and entry3
@@ -616,6 +994,24 @@ func TestS3BackendAddDeletionRequest(t *testing.T) {
 		assert.Len(t, reqs2, 1)
 	})
 
+	t.Run("fans out to devices that register concurrently", func(t *testing.T) {
+		b := NewTestableS3Backend("user123", "")
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+
+		// device2 registers after the device list was read but before the request was fanned out
+		registerDuringPut(t, b, ".json", "device2")
+		delReq := shared.DeletionRequest{
+			UserId:   "user123",
+			Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{EntryId: "entry1"}}},
+		}
+		require.NoError(t, b.AddDeletionRequest(ctx, delReq))
+
+		reqs, err := b.GetDeletionRequests(ctx, "user123", "device2")
+		require.NoError(t, err)
+		require.Len(t, reqs, 1)
+		assert.Equal(t, "device2", reqs[0].DestinationDeviceId)
+	})
+
 	t.Run("handles batch deletion of many entries", func(t *testing.T) {
 		b := NewTestableS3Backend("user123", "")
 
@@ -631,7 +1027,7 @@ func TestS3BackendAddDeletionRequest(t *testing.T) {
 			entry := &shared.EncHistoryEntry{EncryptedId: entryId, DeviceId: "device1", Date: time.Now()}
 			data, _ := json.Marshal(entry)
 			key := b.key("entries", "2024-01-15", entryId+".json")
-			require.NoError(t, b.putObject(ctx, key, data))
+			require.NoError(t, b.putObject(ctx, key, data, ""))
 
 			// Delete every other entry
 			if i%2 == 0 {
@@ -3,6 +3,8 @@ package backend
 import (
 	"fmt"
 	"os"
+
+	"github.com/ddworken/hishtory/shared"
 )
 
 // S3Config holds configuration for the S3 backend.
@@ -25,6 +27,17 @@ type S3Config struct {
 
 	// Prefix is an optional path prefix within the bucket (e.g., "hishtory/")
 	Prefix string `json:"prefix,omitempty"`
+
+	// Concurrency is the maximum number of parallel requests (optional, defaults to s3DefaultConcurrency)
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Compression is the content encoding ("gzip" or "zstd") to compress uploaded objects with (optional, defaults
+	// to no compression). Objects are marked with their encoding, so devices can read them regardless of this setting.
+	Compression string `json:"compression,omitempty"`
+
+	// ClientVersion is the version of this client (e.g. "v0.336"), which is recorded in devices.json so that other
+	// devices know which entries this device is able to process. Set by the client rather than stored in the config.
+	ClientVersion string `json:"-"`
 }
 
 // Validate checks that required fields are set and loads the secret from environment.
@@ -35,6 +48,12 @@ func (c *S3Config) Validate() error {
 	if c.Region == "" {
 		return fmt.Errorf("S3 region is required")
 	}
+	if c.Concurrency < 0 {
+		return fmt.Errorf("S3 concurrency must not be negative")
+	}
+	if c.Compression != "" && !shared.IsSupportedEncoding(c.Compression) {
+		return fmt.Errorf("S3 compression must be %q or %q", shared.EncodingGzip, shared.EncodingZstd)
+	}
 
 	// Load secret from environment if not already set
 	if c.SecretAccessKey == "" {
@@ -54,6 +73,10 @@ type DeviceInfo struct {
 	DeviceId         string `json:"device_id"`
 	UserId           string `json:"user_id"`
 	RegistrationDate string `json:"registration_date"`
+	// Version is the client version that the device last synced with. It is only recorded by the S3 backend,
+	// since that is the only self-hosted backend supported by clients that predate query history syncing. Empty
+	// for devices that are running one of those older clients.
+	Version string `json:"version,omitempty"`
 }
 
 // DeviceList is the structure stored in devices.json.
@@ -0,0 +1,372 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// Entries are packed into segments once their date is at least this old, so that recent entries which are
+	// still being written by other devices aren't compacted
+	s3CompactionMinAge = 7 * 24 * time.Hour
+	// The maximum number of entries in a single segment object
+	s3SegmentMaxEntries = 5000
+	// How often MaybeGC runs GC
+	s3GCInterval = 24 * time.Hour
+)
+
+// The first client version whose Bootstrap reads segments. Older clients would silently skip compacted entries
+// when they bootstrap, so GC doesn't compact anything while such a device is registered.
+var s3MinSegmentsVersion = shared.ParsedVersion{MajorVersion: 0, MinorVersion: 336}
+
+// s3Segment is a segment object, which packs many entries from the entries store into a single object
+type s3Segment struct {
+	Entries []*shared.EncHistoryEntry `json:"entries"`
+}
+
+// s3GCState is stored in gc.json to record when GC last ran
+type s3GCState struct {
+	LastRun time.Time `json:"last_run"`
+}
+
+// GCStats summarizes the work done by S3Backend.GC
+type GCStats struct {
+	EntriesCompacted int
+	SegmentsWritten  int
+	ObjectsDeleted   int
+	// The number of bytes deleted minus the number of bytes written
+	BytesReclaimed int64
+}
+
+// GC compacts old entries into segment objects and deletes objects that are no longer needed: the inboxes,
+// deletion requests, and dump requests of devices that are no longer registered, and inbox entries and deletion
+// requests that have already been read readCountLimit times. It is safe to run GC concurrently with other
+// devices syncing.
+//
+// Entries are only compacted once every registered device runs a version that reads segments. A device that
+// is set up afterwards with an older version of hishtory won't bootstrap the compacted entries.
+func (b *S3Backend) GC(ctx context.Context) (*GCStats, error) {
+	stats := &GCStats{}
+	if err := b.compactEntries(ctx, stats); err != nil {
+		return stats, fmt.Errorf("failed to compact entries: %w", err)
+	}
+	if err := b.pruneDevices(ctx, stats); err != nil {
+		return stats, fmt.Errorf("failed to prune device objects: %w", err)
+	}
+	return stats, nil
+}
+
+// MaybeGC runs GC if no device has run it in the last s3GCInterval. Returns nil stats if GC didn't run.
+func (b *S3Backend) MaybeGC(ctx context.Context) (*GCStats, error) {
+	key := b.key("gc.json")
+	var state s3GCState
+	data, etag, err := b.getObjectWithETag(ctx, key)
+	if err != nil && !isNotFoundError(err) {
+		return nil, fmt.Errorf("failed to read GC state: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			hctx.GetLogger().Warnf("S3Backend.MaybeGC: ignoring invalid GC state: %v", err)
+		}
+	}
+	if time.Since(state.LastRun) < s3GCInterval {
+		return nil, nil
+	}
+
+	// Claim this run so that other devices don't run GC at the same time
+	state.LastRun = time.Now().UTC()
+	data, err = json.Marshal(&state)
+	if err != nil {
+		return nil, err
+	}
+	// gc.json is tiny, so it is never compressed
+	err = b.putObjectIfUnchanged(ctx, key, data, etag, "")
+	if isPreconditionFailedError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write GC state: %w", err)
+	}
+	return b.GC(ctx)
+}
+
+// compactEntries packs entries older than s3CompactionMinAge into segments and deletes the individual entries.
+func (b *S3Backend) compactEntries(ctx context.Context, stats *GCStats) error {
+	devices, err := b.getDevices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get devices: %w", err)
+	}
+	for _, d := range devices.Devices {
+		if !shared.ClientVersionAtLeast(d.Version, s3MinSegmentsVersion) {
+			hctx.GetLogger().Infof("S3Backend.GC: not compacting entries since device %s runs hishtory %q which doesn't support segments", d.DeviceId, d.Version)
+			return nil
+		}
+	}
+
+	entriesPrefix := b.key("entries") + "/"
+	objects, err := b.listObjects(ctx, entriesPrefix)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().UTC().Add(-s3CompactionMinAge).Format("2006-01-02")
+	var keys []string
+	sizes := make(map[string]int64)
+	for _, obj := range objects {
+		// Key format is: [prefix]/[userId]/entries/[date]/[entryId].json
+		date := path.Base(path.Dir(*obj.Key))
+		if date < cutoff {
+			keys = append(keys, *obj.Key)
+			sizes[*obj.Key] = aws.ToInt64(obj.Size)
+		}
+	}
+	slices.Sort(keys)
+
+	for start := 0; start < len(keys); start += s3SegmentMaxEntries {
+		batch := keys[start:min(start+s3SegmentMaxEntries, len(keys))]
+		// Entries that can't be read are left as individual objects
+		contents, err := b.getObjects(ctx, batch, nil)
+		if err != nil {
+			return err
+		}
+		segment := &s3Segment{}
+		segmentKeys := make(map[string]string)
+		for i, data := range contents {
+			if data == nil {
+				continue
+			}
+			key := batch[i]
+			var entry shared.EncHistoryEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				hctx.GetLogger().Warnf("S3Backend.GC: failed to unmarshal entry %s: %v", key, err)
+				continue
+			}
+			segment.Entries = append(segment.Entries, &entry)
+			segmentKeys[entry.EncryptedId] = key
+		}
+		if len(segment.Entries) == 0 {
+			continue
+		}
+
+		segmentKey := b.key("segments", fmt.Sprintf("%d.json", time.Now().UnixNano()))
+		data, err := json.Marshal(segment)
+		if err != nil {
+			return fmt.Errorf("failed to marshal segment: %w", err)
+		}
+		// Segments are only read by clients that support segments, which also support compression
+		if err := b.putObjectIfUnchanged(ctx, segmentKey, data, "", b.compression); err != nil {
+			return fmt.Errorf("failed to write segment: %w", err)
+		}
+
+		// An entry that was deleted after it was read must not be resurrected by the segment. AddDeletionRequest
+		// deletes individual entries before it reads segments, so any entry that is deleted after this check
+		// is removed from the segment by AddDeletionRequest.
+		remaining, err := b.listObjects(ctx, entriesPrefix)
+		if err != nil {
+			return fmt.Errorf("failed to list entries: %w", err)
+		}
+		stillExists := make(map[string]bool)
+		for _, obj := range remaining {
+			stillExists[*obj.Key] = true
+		}
+		err = b.updateSegment(ctx, segmentKey, func(s *s3Segment) bool {
+			n := len(s.Entries)
+			s.Entries = slices.DeleteFunc(s.Entries, func(e *shared.EncHistoryEntry) bool {
+				return !stillExists[segmentKeys[e.EncryptedId]]
+			})
+			return len(s.Entries) != n
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update segment: %w", err)
+		}
+
+		var compacted []string
+		for _, key := range segmentKeys {
+			if stillExists[key] {
+				compacted = append(compacted, key)
+			}
+		}
+		if len(compacted) == 0 {
+			// updateSegment deleted the now empty segment
+			continue
+		}
+		if err := b.deleteObjects(ctx, compacted); err != nil {
+			return fmt.Errorf("failed to delete compacted entries: %w", err)
+		}
+		stats.SegmentsWritten++
+		stats.EntriesCompacted += len(compacted)
+		stats.ObjectsDeleted += len(compacted)
+		for _, key := range compacted {
+			stats.BytesReclaimed += sizes[key]
+		}
+		stats.BytesReclaimed -= int64(len(data))
+	}
+	return nil
+}
+
+// pruneDevices deletes inboxes, deletion requests, and dump requests of devices that are no longer registered,
+// e.g. because they were uninstalled before a concurrent SubmitEntries finished fanning out. For registered
+// devices, it deletes the inbox entries and deletion requests that were fully read but not cleaned up because
+// the device's delete failed.
+func (b *S3Backend) pruneDevices(ctx context.Context, stats *GCStats) error {
+	var objects []types.Object
+	for _, dir := range []string{"inbox", "deletions", "dump_requests"} {
+		dirObjects, err := b.listObjects(ctx, b.key(dir)+"/")
+		if err != nil {
+			return err
+		}
+		objects = append(objects, dirObjects...)
+	}
+
+	// Devices are registered before anything is written for them, so reading the devices after listing means
+	// that objects of a device that registered concurrently aren't deleted
+	devices, err := b.getDevices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get devices: %w", err)
+	}
+	registered := make(map[string]bool)
+	for _, d := range devices.Devices {
+		registered[d.DeviceId] = true
+	}
+
+	userPrefix := b.key() + "/"
+	var toDelete []string
+	var live []types.Object
+	for _, obj := range objects {
+		// Key format is: [prefix]/[userId]/[dir]/[deviceId]/... or [prefix]/[userId]/dump_requests/[deviceId].json
+		parts := strings.Split(strings.TrimPrefix(*obj.Key, userPrefix), "/")
+		if len(parts) < 2 {
+			continue
+		}
+		deviceId := strings.TrimSuffix(parts[1], ".json")
+		if !registered[deviceId] {
+			toDelete = append(toDelete, *obj.Key)
+			stats.BytesReclaimed += aws.ToInt64(obj.Size)
+		} else if parts[0] != "dump_requests" {
+			live = append(live, obj)
+		}
+	}
+
+	// Inbox entries and deletion requests of registered devices are normally deleted by the device once it has
+	// read them readCountLimit times, so only those that it failed to delete are left
+	keys := objectKeys(live)
+	contents, err := b.getObjects(ctx, keys, nil)
+	if err != nil {
+		return err
+	}
+	for i, data := range contents {
+		if data == nil {
+			continue
+		}
+		var item struct {
+			ReadCount int `json:"read_count"`
+		}
+		if err := json.Unmarshal(data, &item); err != nil {
+			hctx.GetLogger().Warnf("S3Backend.GC: failed to unmarshal %s: %v", keys[i], err)
+			continue
+		}
+		if item.ReadCount >= readCountLimit {
+			toDelete = append(toDelete, keys[i])
+			stats.BytesReclaimed += aws.ToInt64(live[i].Size)
+		}
+	}
+
+	if err := b.deleteObjects(ctx, toDelete); err != nil {
+		return err
+	}
+	stats.ObjectsDeleted += len(toDelete)
+	return nil
+}
+
+// getSegments returns all segment objects, keyed by their S3 key.
+func (b *S3Backend) getSegments(ctx context.Context) (map[string]*s3Segment, error) {
+	objects, err := b.listObjects(ctx, b.key("segments")+"/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list segments: %w", err)
+	}
+	keys := objectKeys(objects)
+	contents, err := b.getObjects(ctx, keys, nil)
+	if err != nil {
+		return nil, err
+	}
+	segments := make(map[string]*s3Segment)
+	for i, data := range contents {
+		if data == nil {
+			// Deletions must not skip a segment that exists, so retry and fail if it still can't be read
+			data, err = b.retrySegment(ctx, keys[i])
+			if err != nil {
+				return nil, err
+			}
+			if data == nil {
+				continue
+			}
+		}
+		var segment s3Segment
+		if err := json.Unmarshal(data, &segment); err != nil {
+			hctx.GetLogger().Warnf("S3Backend: failed to unmarshal segment %s: %v", keys[i], err)
+			continue
+		}
+		segments[keys[i]] = &segment
+	}
+	return segments, nil
+}
+
+// retrySegment reads a segment that getObjects failed to read. Returns nil if the segment was deleted in the
+// meantime, e.g. because it became empty.
+func (b *S3Backend) retrySegment(ctx context.Context, key string) ([]byte, error) {
+	data, err := b.getObject(ctx, key)
+	if isNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read segment %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// updateSegment applies update to a segment with a conditional write, retrying if it was modified concurrently.
+// update returns whether it modified the segment. Segments that become empty are deleted.
+func (b *S3Backend) updateSegment(ctx context.Context, key string, update func(*s3Segment) bool) error {
+	for attempt := 1; ; attempt++ {
+		data, etag, err := b.getObjectWithETag(ctx, key)
+		if isNotFoundError(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var segment s3Segment
+		if err := json.Unmarshal(data, &segment); err != nil {
+			return fmt.Errorf("failed to unmarshal segment %s: %w", key, err)
+		}
+		if !update(&segment) {
+			return nil
+		}
+		if len(segment.Entries) == 0 {
+			return b.deleteObject(ctx, key)
+		}
+		data, err = json.Marshal(&segment)
+		if err != nil {
+			return fmt.Errorf("failed to marshal segment: %w", err)
+		}
+		err = b.putObjectIfUnchanged(ctx, key, data, etag, b.compression)
+		if !isPreconditionFailedError(err) || attempt >= s3MaxConditionalWriteAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt*10) * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestGCBackend creates a test backend for a client version that supports segments
+func newTestGCBackend() *S3Backend {
+	b := NewTestableS3Backend("user123", "")
+	b.version = "v0.336"
+	return b
+}
+
+func putTestEntry(t *testing.T, b *S3Backend, id string, date time.Time) {
+	entry := &shared.EncHistoryEntry{EncryptedId: id, DeviceId: "device1", Date: date, EncryptedData: []byte(strings.Repeat("x", 100))}
+	data, err := json.Marshal(entry)
+	require.NoError(t, err)
+	require.NoError(t, b.putObject(context.Background(), b.key("entries", date.Format("2006-01-02"), id+".json"), data, ""))
+}
+
+func countObjects(t *testing.T, b *S3Backend, dir string) int {
+	objects, err := b.listObjects(context.Background(), b.key(dir)+"/")
+	require.NoError(t, err)
+	return len(objects)
+}
+
+func TestS3BackendGCCompactsOldEntries(t *testing.T) {
+	ctx := context.Background()
+	b := newTestGCBackend()
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	for i := 0; i < 20; i++ {
+		putTestEntry(t, b, fmt.Sprintf("old%d", i), old.Add(time.Duration(i)*time.Hour))
+	}
+	putTestEntry(t, b, "recent", time.Now())
+
+	stats, err := b.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 20, stats.EntriesCompacted)
+	assert.Equal(t, 1, stats.SegmentsWritten)
+	assert.Equal(t, 20, stats.ObjectsDeleted)
+
+	// Only recent entries are left as individual objects, but Bootstrap still returns everything
+	assert.Equal(t, 1, countObjects(t, b, "entries"))
+	assert.Equal(t, 1, countObjects(t, b, "segments"))
+	bootstrapped, err := b.Bootstrap(ctx, "user123", "device2")
+	require.NoError(t, err)
+	assert.Len(t, bootstrapped, 21)
+
+	// Segments that fail to be read are retried, and Bootstrap fails rather than returning a partial history
+	segments, err := b.listObjects(ctx, b.key("segments")+"/")
+	require.NoError(t, err)
+	mock := b.client.(*MockS3Client)
+	mock.failGets[*segments[0].Key] = 1
+	bootstrapped, err = b.Bootstrap(ctx, "user123", "device2")
+	require.NoError(t, err)
+	assert.Len(t, bootstrapped, 21)
+	mock.failGets[*segments[0].Key] = 2
+	_, err = b.Bootstrap(ctx, "user123", "device2")
+	require.Error(t, err)
+
+	// GC is idempotent
+	stats, err = b.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, GCStats{}, *stats)
+}
+
+func TestS3BackendGCDeletionFromSegments(t *testing.T) {
+	ctx := context.Background()
+	b := newTestGCBackend()
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	putTestEntry(t, b, "entry1", old)
+	putTestEntry(t, b, "entry2", old)
+	_, err := b.GC(ctx)
+	require.NoError(t, err)
+
+	delReq := shared.DeletionRequest{
+		UserId:   "user123",
+		Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{EntryId: "entry1"}}},
+	}
+	require.NoError(t, b.AddDeletionRequest(ctx, delReq))
+	bootstrapped, err := b.Bootstrap(ctx, "user123", "device2")
+	require.NoError(t, err)
+	require.Len(t, bootstrapped, 1)
+	assert.Equal(t, "entry2", bootstrapped[0].EncryptedId)
+
+	// Segments that become empty are deleted
+	delReq.Messages.Ids[0].EntryId = "entry2"
+	require.NoError(t, b.AddDeletionRequest(ctx, delReq))
+	assert.Equal(t, 0, countObjects(t, b, "segments"))
+}
+
+func TestS3BackendGCConcurrentDeletion(t *testing.T) {
+	ctx := context.Background()
+	b := newTestGCBackend()
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	putTestEntry(t, b, "entry1", old)
+	putTestEntry(t, b, "entry2", old)
+
+	// entry1 is deleted after GC read it but before the segment was written, so it must not be resurrected
+	mock := b.client.(*MockS3Client)
+	mock.beforePut = func(key string) {
+		if strings.Contains(key, "/segments/") {
+			mock.beforePut = nil
+			delReq := shared.DeletionRequest{
+				UserId:   "user123",
+				Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{EntryId: "entry1"}}},
+			}
+			require.NoError(t, b.AddDeletionRequest(ctx, delReq))
+		}
+	}
+	stats, err := b.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.EntriesCompacted)
+
+	bootstrapped, err := b.Bootstrap(ctx, "user123", "device2")
+	require.NoError(t, err)
+	require.Len(t, bootstrapped, 1)
+	assert.Equal(t, "entry2", bootstrapped[0].EncryptedId)
+}
+
+func TestS3BackendGCPrunesUninstalledDevices(t *testing.T) {
+	ctx := context.Background()
+	b := newTestGCBackend()
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+	entries := []*shared.EncHistoryEntry{{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()}}
+	_, err := b.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+
+	// Simulate device3 being uninstalled while another device was still fanning out to it
+	require.NoError(t, b.putObject(ctx, b.key("inbox", "device3", "20240101T000000Z_entry1.json"), []byte("{}"), ""))
+	require.NoError(t, b.putObject(ctx, b.key("deletions", "device3", "1_entry1.json"), []byte("{}"), ""))
+	require.NoError(t, b.createDumpRequest(ctx, &shared.DumpRequest{UserId: "user123", RequestingDeviceId: "device3"}, nil))
+
+	stats, err := b.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.ObjectsDeleted)
+	assert.Positive(t, stats.BytesReclaimed)
+	assert.Equal(t, 1, countObjects(t, b, "inbox"))
+	assert.Equal(t, 0, countObjects(t, b, "deletions"))
+	dumpRequests, err := b.getDumpRequests(ctx, "device1")
+	require.NoError(t, err)
+	require.Len(t, dumpRequests, 1)
+	assert.Equal(t, "device2", dumpRequests[0].RequestingDeviceId)
+}
+
+func TestS3BackendGCPrunesReadMessages(t *testing.T) {
+	ctx := context.Background()
+	b := newTestGCBackend()
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+
+	// Simulate device1 failing to delete messages after reading them for the last time
+	putMessage := func(key string, readCount int) {
+		data, err := json.Marshal(&shared.EncHistoryEntry{EncryptedId: "entry1", ReadCount: readCount})
+		require.NoError(t, err)
+		require.NoError(t, b.putObject(ctx, key, data, ""))
+	}
+	putMessage(b.key("inbox", "device1", "20240101T000000Z_entry1.json"), readCountLimit)
+	putMessage(b.key("inbox", "device1", "20240101T000000Z_entry2.json"), readCountLimit-1)
+	putMessage(b.key("deletions", "device1", "1_entry1.json"), readCountLimit)
+
+	stats, err := b.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.ObjectsDeleted)
+	assert.Equal(t, 1, countObjects(t, b, "inbox"))
+	assert.Equal(t, 0, countObjects(t, b, "deletions"))
+}
+
+func TestS3BackendGCSkipsCompactionForOldDevices(t *testing.T) {
+	ctx := context.Background()
+	b := newTestGCBackend()
+	oldClient := &S3Backend{client: b.client, bucket: b.bucket, userId: b.userId}
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, oldClient.RegisterDevice(ctx, "user123", "device2"))
+	putTestEntry(t, b, "entry1", time.Now().Add(-30*24*time.Hour))
+
+	// device2 wouldn't see entries in segments, so nothing is compacted
+	stats, err := b.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, GCStats{}, *stats)
+	assert.Equal(t, 0, countObjects(t, b, "segments"))
+
+	// Until it is upgraded
+	_, err = b.SubmitEntries(ctx, []*shared.EncHistoryEntry{{EncryptedId: "entry2", Date: time.Now()}}, "device2")
+	require.NoError(t, err)
+	stats, err = b.GC(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.EntriesCompacted)
+}
+
+func TestS3BackendMaybeGC(t *testing.T) {
+	ctx := context.Background()
+	b := newTestGCBackend()
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	putTestEntry(t, b, "entry1", time.Now().Add(-30*24*time.Hour))
+
+	stats, err := b.MaybeGC(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, stats)
+	assert.Equal(t, 1, stats.EntriesCompacted)
+
+	// GC already ran recently
+	putTestEntry(t, b, "entry2", time.Now().Add(-30*24*time.Hour))
+	stats, err = b.MaybeGC(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, stats)
+
+	// Until the interval has passed
+	state, err := json.Marshal(&s3GCState{LastRun: time.Now().Add(-2 * s3GCInterval)})
+	require.NoError(t, err)
+	require.NoError(t, b.putObject(ctx, b.key("gc.json"), state, ""))
+	stats, err = b.MaybeGC(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, stats)
+	assert.Equal(t, 1, stats.EntriesCompacted)
+}
@@ -0,0 +1,501 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+)
+
+// The number of times to retry updating devices.json when another device modified it concurrently
+const webDAVMaxDevicesUpdateAttempts = 10
+
+var (
+	errWebDAVNotFound           = errors.New("webdav: not found")
+	errWebDAVPreconditionFailed = errors.New("webdav: precondition failed")
+)
+
+// WebDAVConfig holds configuration for the WebDAV backend.
+type WebDAVConfig struct {
+	// URL is the URL of the WebDAV collection to store history in (required), e.g.
+	// https://cloud.example.com/remote.php/dav/files/alice/hishtory for Nextcloud
+	URL string `json:"url"`
+
+	// Username is the username for HTTP basic auth (optional)
+	Username string `json:"username,omitempty"`
+
+	// Password is loaded from environment variable HISHTORY_WEBDAV_PASSWORD
+	// Never stored in config file for security
+	Password string `json:"-"`
+}
+
+// Validate checks that required fields are set and loads the password from environment.
+func (c *WebDAVConfig) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("WebDAV URL is required")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("failed to parse WebDAV URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("WebDAV URL %q must be an http or https URL", c.URL)
+	}
+
+	// Load password from environment if not already set
+	if c.Password == "" {
+		c.Password = os.Getenv("HISHTORY_WEBDAV_PASSWORD")
+	}
+	if c.Username != "" && c.Password == "" {
+		return fmt.Errorf("WebDAV username provided but password is missing (set HISHTORY_WEBDAV_PASSWORD)")
+	}
+	return nil
+}
+
+// WebDAVBackend implements SyncBackend on top of a WebDAV server (e.g. Nextcloud), using the same layout as
+// S3Backend (see objectLayout). Updates to devices.json use If-Match/If-None-Match preconditions when the server
+// returns ETags, so that devices registering at the same time don't overwrite each other's changes.
+type WebDAVBackend struct {
+	client   *http.Client
+	baseURL  *url.URL
+	username string
+	password string
+	userId   string // derived from user secret, used as folder name
+	layout   objectLayout
+}
+
+// NewWebDAVBackend creates a new WebDAV backend with the given configuration.
+func NewWebDAVBackend(cfg *WebDAVConfig, userId string, client *http.Client) (*WebDAVBackend, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid WebDAV config: %w", err)
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(cfg.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse WebDAV URL: %w", err)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	b := &WebDAVBackend{
+		client:   client,
+		baseURL:  baseURL,
+		username: cfg.Username,
+		password: cfg.Password,
+		userId:   userId,
+	}
+	b.layout = objectLayout{store: b, name: "WebDAVBackend"}
+	return b, nil
+}
+
+// Type returns "webdav" to identify this backend type.
+func (b *WebDAVBackend) Type() string {
+	return string(BackendTypeWebDAV)
+}
+
+// key builds a path from parts, relative to the base URL and including the userId.
+func (b *WebDAVBackend) key(parts ...string) string {
+	return path.Join(append([]string{b.userId}, parts...)...)
+}
+
+// RegisterDevice registers a new device for the user.
+func (b *WebDAVBackend) RegisterDevice(ctx context.Context, userId, deviceId string) error {
+	existingDeviceCount := 0
+	err := b.updateDevices(ctx, func(devices *DeviceList) bool {
+		existingDeviceCount = len(devices.Devices)
+		for _, d := range devices.Devices {
+			if d.DeviceId == deviceId {
+				// Device already registered
+				existingDeviceCount = 0
+				return false
+			}
+		}
+		devices.Devices = append(devices.Devices, DeviceInfo{
+			DeviceId:         deviceId,
+			UserId:           userId,
+			RegistrationDate: time.Now().UTC().Format(time.RFC3339),
+		})
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save devices: %w", err)
+	}
+
+	// If there are existing devices, create a dump request so they send history to the new device
+	if existingDeviceCount > 0 {
+		return b.layout.createDumpRequest(ctx, userId, deviceId)
+	}
+	return nil
+}
+
+// Bootstrap returns all history entries for a user.
+func (b *WebDAVBackend) Bootstrap(ctx context.Context, _, _ string) ([]*shared.EncHistoryEntry, error) {
+	return b.layout.bootstrap(ctx)
+}
+
+// SubmitEntries submits new encrypted history entries.
+func (b *WebDAVBackend) SubmitEntries(ctx context.Context, entries []*shared.EncHistoryEntry, sourceDeviceId string) (*shared.SubmitResponse, error) {
+	if len(entries) == 0 {
+		return &shared.SubmitResponse{}, nil
+	}
+	deviceList, _, err := b.getDevices(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+	if err := b.layout.submitEntries(ctx, deviceList.Devices, entries, sourceDeviceId); err != nil {
+		return nil, err
+	}
+
+	// Check for pending dump requests and deletion requests for source device
+	resp := &shared.SubmitResponse{}
+	dumpReqs, err := b.layout.getDumpRequests(ctx, sourceDeviceId)
+	if err == nil {
+		resp.DumpRequests = dumpReqs
+	}
+	delReqs, err := b.GetDeletionRequests(ctx, b.userId, sourceDeviceId)
+	if err == nil {
+		resp.DeletionRequests = delReqs
+	}
+	return resp, nil
+}
+
+// SubmitDump handles bulk transfer of entries to a requesting device.
+func (b *WebDAVBackend) SubmitDump(ctx context.Context, entries []*shared.EncHistoryEntry, _, requestingDeviceId, _ string) error {
+	return b.layout.submitDump(ctx, entries, requestingDeviceId)
+}
+
+// QueryEntries retrieves new entries for a device. Like S3Backend, entries are kept until they have been
+// read readCountLimit times.
+func (b *WebDAVBackend) QueryEntries(ctx context.Context, deviceId, _, _ string) ([]*shared.EncHistoryEntry, error) {
+	return b.layout.queryEntries(ctx, deviceId)
+}
+
+// GetDeletionRequests returns pending deletion requests for a device. Like S3Backend, requests are kept until
+// they have been read readCountLimit times.
+func (b *WebDAVBackend) GetDeletionRequests(ctx context.Context, _, deviceId string) ([]*shared.DeletionRequest, error) {
+	return b.layout.getDeletionRequests(ctx, deviceId)
+}
+
+// AddDeletionRequest adds a deletion request to be propagated to all devices.
+func (b *WebDAVBackend) AddDeletionRequest(ctx context.Context, request shared.DeletionRequest) error {
+	deviceList, _, err := b.getDevices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get devices: %w", err)
+	}
+	return b.layout.addDeletionRequest(ctx, deviceList.Devices, request)
+}
+
+// Uninstall removes a device and its pending data.
+func (b *WebDAVBackend) Uninstall(ctx context.Context, _, deviceId string) error {
+	err := b.updateDevices(ctx, func(devices *DeviceList) bool {
+		newDevices := make([]DeviceInfo, 0, len(devices.Devices))
+		for _, d := range devices.Devices {
+			if d.DeviceId != deviceId {
+				newDevices = append(newDevices, d)
+			}
+		}
+		changed := len(newDevices) != len(devices.Devices)
+		devices.Devices = newDevices
+		return changed
+	})
+	if err != nil {
+		return err
+	}
+	// Deleting a collection deletes everything within it
+	b.layout.removeDevice(ctx, deviceId)
+	return nil
+}
+
+// Ping checks that the WebDAV collection is accessible.
+func (b *WebDAVBackend) Ping(ctx context.Context) error {
+	_, err := b.propfind(ctx, "", "0")
+	return err
+}
+
+// Helper methods for WebDAV operations
+
+// webDAVDevicesVersion identifies the version of devices.json that was read, so that it is only overwritten if
+// it hasn't changed since
+type webDAVDevicesVersion struct {
+	exists bool
+	// The ETag of devices.json, which is empty if it doesn't exist or the server doesn't return ETags
+	etag string
+}
+
+// getDevices returns the registered devices along with the version of devices.json that they were read from
+func (b *WebDAVBackend) getDevices(ctx context.Context) (*DeviceList, webDAVDevicesVersion, error) {
+	data, etag, err := b.getFile(ctx, b.key("devices.json"))
+	if errors.Is(err, errWebDAVNotFound) {
+		return &DeviceList{}, webDAVDevicesVersion{}, nil
+	}
+	if err != nil {
+		return nil, webDAVDevicesVersion{}, err
+	}
+	var devices DeviceList
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return nil, webDAVDevicesVersion{}, fmt.Errorf("failed to unmarshal devices: %w", err)
+	}
+	return &devices, webDAVDevicesVersion{exists: true, etag: etag}, nil
+}
+
+// updateDevices applies update to devices.json, retrying if another device modified it concurrently. update
+// returns whether it modified the device list.
+func (b *WebDAVBackend) updateDevices(ctx context.Context, update func(*DeviceList) bool) error {
+	for attempt := 1; ; attempt++ {
+		devices, version, err := b.getDevices(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get devices: %w", err)
+		}
+		if !update(devices) {
+			return nil
+		}
+		data, err := json.Marshal(devices)
+		if err != nil {
+			return fmt.Errorf("failed to marshal devices: %w", err)
+		}
+		header := http.Header{}
+		switch {
+		case version.etag != "":
+			header.Set("If-Match", version.etag)
+		case !version.exists:
+			header.Set("If-None-Match", "*")
+		default:
+			// The server doesn't support ETags, so concurrent updates can't be detected
+			hctx.GetLogger().Infof("WebDAVBackend: server didn't return an ETag for devices.json, updating it without a precondition")
+		}
+		err = b.putFile(ctx, b.key("devices.json"), data, header)
+		if !errors.Is(err, errWebDAVPreconditionFailed) || attempt >= webDAVMaxDevicesUpdateAttempts {
+			return err
+		}
+		hctx.GetLogger().Infof("WebDAVBackend: devices.json was modified concurrently, retrying (attempt %d)", attempt)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(10+rand.Intn(100)) * time.Millisecond):
+		}
+	}
+}
+
+func (b *WebDAVBackend) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	u := b.baseURL.JoinPath(key)
+	if strings.HasSuffix(key, "/") && !strings.HasSuffix(u.Path, "/") {
+		// Collections are addressed with a trailing slash
+		u.Path += "/"
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+	return b.client.Do(req)
+}
+
+// objectStore implementation, where keys are files within the user's collection
+
+func (b *WebDAVBackend) getObject(ctx context.Context, key string) ([]byte, error) {
+	data, _, err := b.getFile(ctx, b.key(key))
+	return data, err
+}
+
+func (b *WebDAVBackend) putObject(ctx context.Context, key string, data []byte) error {
+	return b.putFile(ctx, b.key(key), data, nil)
+}
+
+func (b *WebDAVBackend) listObjects(ctx context.Context, prefix string) ([]string, error) {
+	files, err := b.listFiles(ctx, b.key(prefix))
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(files))
+	for _, f := range files {
+		keys = append(keys, strings.TrimPrefix(f, b.key()+"/"))
+	}
+	return keys, nil
+}
+
+func (b *WebDAVBackend) deleteObject(ctx context.Context, key string) error {
+	return b.deleteFile(ctx, b.key(key))
+}
+
+// getFile returns the contents and the ETag of a file
+func (b *WebDAVBackend) getFile(ctx context.Context, key string) ([]byte, string, error) {
+	resp, err := b.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", fmt.Errorf("GET %s: %w", key, errWebDAVNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("GET %s: unexpected status %s", key, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("ETag"), nil
+}
+
+// putFile writes a file, creating its parent collections if they don't exist yet. WebDAV servers write the
+// file atomically, so other devices never read a partially written file.
+func (b *WebDAVBackend) putFile(ctx context.Context, key string, data []byte, header http.Header) error {
+	for attempt := 0; ; attempt++ {
+		resp, err := b.do(ctx, http.MethodPut, key, data, header)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode == http.StatusPreconditionFailed:
+			return fmt.Errorf("PUT %s: %w", key, errWebDAVPreconditionFailed)
+		case (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound) && attempt == 0:
+			// The parent collection doesn't exist yet
+			if err := b.mkcolAll(ctx, path.Dir(key)); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("PUT %s: unexpected status %s", key, resp.Status)
+		}
+	}
+}
+
+// mkcolAll creates the collection for dir along with any missing parents
+func (b *WebDAVBackend) mkcolAll(ctx context.Context, dir string) error {
+	if dir == "." || dir == "/" || dir == "" {
+		return nil
+	}
+	resp, err := b.do(ctx, "MKCOL", dir+"/", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusMethodNotAllowed:
+		// 405 means that the collection already exists
+		return nil
+	case http.StatusConflict:
+		// The parent collection doesn't exist yet
+		if err := b.mkcolAll(ctx, path.Dir(dir)); err != nil {
+			return err
+		}
+		return b.mkcolAll(ctx, dir)
+	default:
+		return fmt.Errorf("MKCOL %s: unexpected status %s", dir, resp.Status)
+	}
+}
+
+func (b *WebDAVBackend) deleteFile(ctx context.Context, key string) error {
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || (resp.StatusCode >= 200 && resp.StatusCode < 300) {
+		return nil
+	}
+	return fmt.Errorf("DELETE %s: unexpected status %s", key, resp.Status)
+}
+
+type webDAVMultistatus struct {
+	Responses []struct {
+		Href       string    `xml:"DAV: href"`
+		Collection *struct{} `xml:"DAV: propstat>prop>resourcetype>collection"`
+	} `xml:"DAV: response"`
+}
+
+type webDAVResource struct {
+	key          string
+	isCollection bool
+}
+
+const webDAVPropfindBody = `<?xml version="1.0" encoding="utf-8"?><d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/></d:prop></d:propfind>`
+
+// propfind lists the resources within the collection for key with the given depth (excluding the collection
+// itself). Returns errWebDAVNotFound if the collection doesn't exist.
+func (b *WebDAVBackend) propfind(ctx context.Context, key, depth string) ([]webDAVResource, error) {
+	header := http.Header{}
+	header.Set("Depth", depth)
+	header.Set("Content-Type", "application/xml")
+	resp, err := b.do(ctx, "PROPFIND", key+"/", []byte(webDAVPropfindBody), header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("PROPFIND %s: %w", key, errWebDAVNotFound)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s: unexpected status %s", key, resp.Status)
+	}
+	var ms webDAVMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: failed to parse response: %w", key, err)
+	}
+
+	basePath := strings.TrimSuffix(b.baseURL.Path, "/")
+	selfPath := strings.TrimSuffix(path.Join(basePath, key), "/")
+	var resources []webDAVResource
+	for _, r := range ms.Responses {
+		// Hrefs may be either absolute paths or full URLs
+		href, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, fmt.Errorf("PROPFIND %s: failed to parse href %q: %w", key, r.Href, err)
+		}
+		p := strings.TrimSuffix(href.Path, "/")
+		if p == selfPath || !strings.HasPrefix(p, basePath+"/") {
+			continue
+		}
+		resources = append(resources, webDAVResource{
+			key:          strings.TrimPrefix(p, basePath+"/"),
+			isCollection: r.Collection != nil,
+		})
+	}
+	return resources, nil
+}
+
+// listFiles recursively lists the JSON files within the collection for dir. Hidden files are skipped since
+// they are typically in-progress uploads.
+func (b *WebDAVBackend) listFiles(ctx context.Context, dir string) ([]string, error) {
+	// Not all servers support Depth: infinity, so walk the collections one level at a time
+	resources, err := b.propfind(ctx, dir, "1")
+	if errors.Is(err, errWebDAVNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, r := range resources {
+		name := path.Base(r.key)
+		if r.isCollection {
+			children, err := b.listFiles(ctx, r.key)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, children...)
+		} else if !strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".json") {
+			files = append(files, r.key)
+		}
+	}
+	return files, nil
+}
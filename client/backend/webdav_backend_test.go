@@ -0,0 +1,284 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/webdav"
+)
+
+// webDAVTestServer wraps an in-process WebDAV server. x/net/webdav doesn't write files atomically or
+// implement If-Match and If-None-Match for PUT, so this adds both in the same way as servers like Nextcloud.
+type webDAVTestServer struct {
+	mu      sync.RWMutex
+	handler *webdav.Handler
+	// Whether to strip ETags from responses, like some servers that don't support them
+	stripETags bool
+}
+
+// etagStrippingWriter removes the ETag header from a response
+type etagStrippingWriter struct {
+	http.ResponseWriter
+}
+
+func (w etagStrippingWriter) WriteHeader(code int) {
+	w.Header().Del("ETag")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w etagStrippingWriter) Write(p []byte) (int, error) {
+	w.Header().Del("ETag")
+	return w.ResponseWriter.Write(p)
+}
+
+func (s *webDAVTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || user != "alice" || password != "hunter2" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if s.stripETags {
+		w = etagStrippingWriter{w}
+	}
+	if r.Method != http.MethodPut {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ifMatch, ifNoneMatch := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+	head := httptest.NewRecorder()
+	s.handler.ServeHTTP(head, httptest.NewRequest(http.MethodHead, r.URL.Path, nil))
+	etag := head.Header().Get("ETag")
+	exists := head.Code == http.StatusOK
+	if (ifMatch != "" && (!exists || ifMatch != etag)) || (ifNoneMatch == "*" && exists) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+func newTestWebDAVBackend(t *testing.T) *WebDAVBackend {
+	return newTestWebDAVBackendWithServer(t, &webDAVTestServer{})
+}
+
+func newTestWebDAVBackendWithServer(t *testing.T, s *webDAVTestServer) *WebDAVBackend {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(dir+"/hishtory", 0o700))
+	s.handler = &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	b, err := NewWebDAVBackend(&WebDAVConfig{URL: server.URL + "/dav/hishtory/", Username: "alice", Password: "hunter2"}, "user123", server.Client())
+	require.NoError(t, err)
+	return b
+}
+
+func TestWebDAVConfigValidate(t *testing.T) {
+	t.Setenv("HISHTORY_WEBDAV_PASSWORD", "")
+	require.NoError(t, (&WebDAVConfig{URL: "https://cloud.example.com/remote.php/dav/files/alice/hishtory"}).Validate())
+	require.ErrorContains(t, (&WebDAVConfig{}).Validate(), "URL is required")
+	require.ErrorContains(t, (&WebDAVConfig{URL: "ftp://example.com"}).Validate(), "must be an http or https URL")
+	require.ErrorContains(t, (&WebDAVConfig{URL: "https://example.com", Username: "alice"}).Validate(), "HISHTORY_WEBDAV_PASSWORD")
+
+	t.Setenv("HISHTORY_WEBDAV_PASSWORD", "hunter2")
+	cfg := &WebDAVConfig{URL: "https://example.com", Username: "alice"}
+	require.NoError(t, cfg.Validate())
+	assert.Equal(t, "hunter2", cfg.Password)
+}
+
+func TestWebDAVBackendPing(t *testing.T) {
+	ctx := context.Background()
+	b := newTestWebDAVBackend(t)
+	assert.Equal(t, "webdav", b.Type())
+	require.NoError(t, b.Ping(ctx))
+
+	b.password = "wrong"
+	require.Error(t, b.Ping(ctx))
+}
+
+func TestWebDAVBackendRegisterDevice(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("second device creates dump request", func(t *testing.T) {
+		b := newTestWebDAVBackend(t)
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+
+		devices, _, err := b.getDevices(ctx)
+		require.NoError(t, err)
+		assert.Len(t, devices.Devices, 2)
+
+		dumpRequests, err := b.layout.getDumpRequests(ctx, "device1")
+		require.NoError(t, err)
+		require.Len(t, dumpRequests, 1)
+		assert.Equal(t, "device2", dumpRequests[0].RequestingDeviceId)
+	})
+
+	t.Run("concurrent registrations are not lost", func(t *testing.T) {
+		b := newTestWebDAVBackend(t)
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device0"))
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		for i := 1; i <= 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- b.RegisterDevice(ctx, "user123", fmt.Sprintf("device%d", i))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		devices, _, err := b.getDevices(ctx)
+		require.NoError(t, err)
+		assert.Len(t, devices.Devices, 9)
+	})
+
+	t.Run("stale writes are rejected", func(t *testing.T) {
+		b := newTestWebDAVBackend(t)
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+		_, version, err := b.getDevices(ctx)
+		require.NoError(t, err)
+		require.NotEmpty(t, version.etag)
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+
+		header := http.Header{}
+		header.Set("If-Match", version.etag)
+		err = b.putFile(ctx, b.key("devices.json"), []byte(`{"devices":[]}`), header)
+		require.ErrorIs(t, err, errWebDAVPreconditionFailed)
+		header = http.Header{}
+		header.Set("If-None-Match", "*")
+		err = b.putFile(ctx, b.key("devices.json"), []byte(`{"devices":[]}`), header)
+		require.ErrorIs(t, err, errWebDAVPreconditionFailed)
+	})
+
+	t.Run("servers without ETags", func(t *testing.T) {
+		b := newTestWebDAVBackendWithServer(t, &webDAVTestServer{stripETags: true})
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+		devices, version, err := b.getDevices(ctx)
+		require.NoError(t, err)
+		require.True(t, version.exists)
+		require.Empty(t, version.etag)
+		require.Len(t, devices.Devices, 1)
+
+		// devices.json already exists, but can still be updated
+		require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+		require.NoError(t, b.Uninstall(ctx, "user123", "device1"))
+		devices, _, err = b.getDevices(ctx)
+		require.NoError(t, err)
+		require.Len(t, devices.Devices, 1)
+		assert.Equal(t, "device2", devices.Devices[0].DeviceId)
+	})
+}
+
+func TestWebDAVBackendSubmitAndQueryEntries(t *testing.T) {
+	ctx := context.Background()
+	b := newTestWebDAVBackend(t)
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+
+	entries := []*shared.EncHistoryEntry{
+		{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()},
+		{EncryptedId: "entry2", DeviceId: "device1", Date: time.Now().Add(-48 * time.Hour)},
+	}
+	resp, err := b.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+	require.Len(t, resp.DumpRequests, 1)
+
+	device1Entries, err := b.QueryEntries(ctx, "device1", "user123", "test")
+	require.NoError(t, err)
+	assert.Empty(t, device1Entries)
+
+	// Entries are kept until they have been read readCountLimit times
+	for i := 1; i <= readCountLimit; i++ {
+		device2Entries, err := b.QueryEntries(ctx, "device2", "user123", "test")
+		require.NoError(t, err)
+		require.Len(t, device2Entries, 2)
+		assert.Equal(t, "device2", device2Entries[0].DeviceId)
+		assert.Equal(t, i, device2Entries[0].ReadCount)
+	}
+	device2Entries, err := b.QueryEntries(ctx, "device2", "user123", "test")
+	require.NoError(t, err)
+	assert.Empty(t, device2Entries)
+
+	// Bootstrap walks all of the date collections
+	bootstrapped, err := b.Bootstrap(ctx, "user123", "device3")
+	require.NoError(t, err)
+	assert.Len(t, bootstrapped, 2)
+
+	// And SubmitDump clears the dump request
+	require.NoError(t, b.SubmitDump(ctx, bootstrapped, "user123", "device2", "device1"))
+	dumpRequests, err := b.layout.getDumpRequests(ctx, "device1")
+	require.NoError(t, err)
+	assert.Empty(t, dumpRequests)
+	device2Entries, err = b.QueryEntries(ctx, "device2", "user123", "test")
+	require.NoError(t, err)
+	assert.Len(t, device2Entries, 2)
+}
+
+func TestWebDAVBackendDeletionAndUninstall(t *testing.T) {
+	ctx := context.Background()
+	b := newTestWebDAVBackend(t)
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device1"))
+	require.NoError(t, b.RegisterDevice(ctx, "user123", "device2"))
+	entries := []*shared.EncHistoryEntry{
+		{EncryptedId: "entry1", DeviceId: "device1", Date: time.Now()},
+		{EncryptedId: "entry2", DeviceId: "device1", Date: time.Now()},
+	}
+	_, err := b.SubmitEntries(ctx, entries, "device1")
+	require.NoError(t, err)
+
+	delReq := shared.DeletionRequest{
+		UserId:   "user123",
+		Messages: shared.MessageIdentifiers{Ids: []shared.MessageIdentifier{{EntryId: "entry1"}}},
+	}
+	require.NoError(t, b.AddDeletionRequest(ctx, delReq))
+	for _, deviceId := range []string{"device1", "device2"} {
+		reqs, err := b.GetDeletionRequests(ctx, "user123", deviceId)
+		require.NoError(t, err)
+		require.Len(t, reqs, 1)
+		assert.Equal(t, deviceId, reqs[0].DestinationDeviceId)
+	}
+	bootstrapped, err := b.Bootstrap(ctx, "user123", "device3")
+	require.NoError(t, err)
+	require.Len(t, bootstrapped, 1)
+	assert.Equal(t, "entry2", bootstrapped[0].EncryptedId)
+
+	require.NoError(t, b.Uninstall(ctx, "user123", "device2"))
+	devices, _, err := b.getDevices(ctx)
+	require.NoError(t, err)
+	require.Len(t, devices.Devices, 1)
+	assert.Equal(t, "device1", devices.Devices[0].DeviceId)
+	inbox, err := b.listFiles(ctx, b.key("inbox", "device2"))
+	require.NoError(t, err)
+	assert.Empty(t, inbox)
+	dumpRequests, err := b.layout.getDumpRequests(ctx, "device1")
+	require.NoError(t, err)
+	assert.Empty(t, dumpRequests)
+}
@@ -72,9 +72,57 @@ var addDefaultSearchColumnsCmd = &cobra.Command{
 	},
 }
 
+var addSecretPatternsCmd = &cobra.Command{
+	Use:     "secret-patterns",
+	Aliases: []string{"secret-pattern"},
+	Short:   "Add a regex that matches secrets which shouldn't be recorded, in addition to the built-in rules",
+	Long:    "If the regex contains a capture group, only the first group is masked. E.g. `hishtory config-add secret-patterns 'MY_TOKEN=(\\S+)'`",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(lib.ValidateSecretPattern(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.SecretPatterns = append(config.SecretPatterns, args[0])
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var addIgnoredCommandsCmd = &cobra.Command{
+	Use:     "ignored-commands",
+	Aliases: []string{"ignored-command"},
+	Short:   "Add a regex for commands that should never be recorded",
+	Long:    "E.g. `hishtory config-add ignored-commands '^vault '` will stop recording any commands that start with `vault `. Note that this is a regex rather than a glob, and it can match anywhere in the command.",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(lib.ValidateIgnoredCommand(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.IgnoredCommands = append(config.IgnoredCommands, args[0])
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var addIgnoredDirectoriesCmd = &cobra.Command{
+	Use:     "ignored-directories",
+	Aliases: []string{"ignored-directory"},
+	Short:   "Add a glob for directories in which commands should never be recorded, including in all subdirectories",
+	Long:    "E.g. `hishtory config-add ignored-directories '~/secrets'` will stop recording commands run inside of ~/secrets. Alternatively, you can create a `" + lib.HISHTORY_IGNORE_FILE + "` file in a directory to stop recording commands run inside of it.",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(lib.ValidateIgnoredDirectory(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.IgnoredDirectories = append(config.IgnoredDirectories, args[0])
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(configAddCmd)
 	configAddCmd.AddCommand(addCustomColumnsCmd)
 	configAddCmd.AddCommand(addDisplayedColumnsCmd)
 	configAddCmd.AddCommand(addDefaultSearchColumnsCmd)
+	configAddCmd.AddCommand(addSecretPatternsCmd)
+	configAddCmd.AddCommand(addIgnoredCommandsCmd)
+	configAddCmd.AddCommand(addIgnoredDirectoriesCmd)
 }
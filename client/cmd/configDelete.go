@@ -104,9 +104,84 @@ var deleteDefaultSearchColumnCmd = &cobra.Command{
 	},
 }
 
+var deleteColumnSettingsCmd = &cobra.Command{
+	Use:   "column-settings",
+	Short: "Delete the display settings for the given columns, restoring the defaults",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, c := range args {
+			delete(config.ColumnSettings, c)
+		}
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var deleteSecretPatternsCmd = &cobra.Command{
+	Use:     "secret-patterns",
+	Aliases: []string{"secret-pattern"},
+	Short:   "Delete a custom secret pattern",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		newPatterns := make([]string, 0)
+		for _, p := range config.SecretPatterns {
+			if !slices.Contains(args, p) {
+				newPatterns = append(newPatterns, p)
+			}
+		}
+		config.SecretPatterns = newPatterns
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var deleteIgnoredCommandsCmd = &cobra.Command{
+	Use:     "ignored-commands",
+	Aliases: []string{"ignored-command"},
+	Short:   "Delete an ignored command regex",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		newPatterns := make([]string, 0)
+		for _, p := range config.IgnoredCommands {
+			if !slices.Contains(args, p) {
+				newPatterns = append(newPatterns, p)
+			}
+		}
+		config.IgnoredCommands = newPatterns
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var deleteIgnoredDirectoriesCmd = &cobra.Command{
+	Use:     "ignored-directories",
+	Aliases: []string{"ignored-directory"},
+	Short:   "Delete an ignored directory glob",
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		newPatterns := make([]string, 0)
+		for _, p := range config.IgnoredDirectories {
+			if !slices.Contains(args, p) {
+				newPatterns = append(newPatterns, p)
+			}
+		}
+		config.IgnoredDirectories = newPatterns
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(configDeleteCmd)
 	configDeleteCmd.AddCommand(deleteCustomColumnsCmd)
 	configDeleteCmd.AddCommand(deleteDisplayedColumnCommand)
 	configDeleteCmd.AddCommand(deleteDefaultSearchColumnCmd)
+	configDeleteCmd.AddCommand(deleteColumnSettingsCmd)
+	configDeleteCmd.AddCommand(deleteSecretPatternsCmd)
+	configDeleteCmd.AddCommand(deleteIgnoredCommandsCmd)
+	configDeleteCmd.AddCommand(deleteIgnoredDirectoriesCmd)
 }
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/ddworken/hishtory/client/hctx"
@@ -210,6 +211,18 @@ func init() {
 	configGetCmd.AddCommand(getLogLevelCmd)
 	configGetCmd.AddCommand(getFullScreenCmd)
 	configGetCmd.AddCommand(getDefaultSearchColumns)
+	configGetCmd.AddCommand(getMultiLineCommandsCmd)
+	configGetCmd.AddCommand(getMaxLinesPerRowCmd)
+	configGetCmd.AddCommand(getSyncQueryHistoryCmd)
+	configGetCmd.AddCommand(getColumnSettingsCmd)
+	configGetCmd.AddCommand(getCustomColumnTimeoutCmd)
+	configGetCmd.AddCommand(getCustomColumnBudgetCmd)
+	configGetCmd.AddCommand(getCustomColumnCacheTtlCmd)
+	configGetCmd.AddCommand(getSecretPolicyCmd)
+	configGetCmd.AddCommand(getSecretPatternsCmd)
+	configGetCmd.AddCommand(getIgnoredCommandsCmd)
+	configGetCmd.AddCommand(getIgnoredDirectoriesCmd)
+	configGetCmd.AddCommand(getRunOutputMaxBytesCmd)
 }
 
 var getLogLevelCmd = &cobra.Command{
@@ -231,3 +244,141 @@ var getFullScreenCmd = &cobra.Command{
 		fmt.Println(config.FullScreenRendering)
 	},
 }
+
+var getMultiLineCommandsCmd = &cobra.Command{
+	Use:   "multi-line-commands",
+	Short: "Get whether multi-line commands are rendered across multiple lines in the TUI",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.MultiLineCommands)
+	},
+}
+
+var getMaxLinesPerRowCmd = &cobra.Command{
+	Use:   "max-lines-per-row",
+	Short: "Get the maximum number of lines displayed for multi-line commands that aren't currently selected in the TUI",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.MaxLinesPerRow)
+	},
+}
+
+var getSyncQueryHistoryCmd = &cobra.Command{
+	Use:   "sync-query-history",
+	Short: "Get whether search queries run in the TUI are synced to your other devices",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.SyncQueryHistory)
+	},
+}
+
+var getColumnSettingsCmd = &cobra.Command{
+	Use:   "column-settings",
+	Short: "Get the display settings configured for individual columns",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		columnNames := make([]string, 0, len(config.ColumnSettings))
+		for name := range config.ColumnSettings {
+			columnNames = append(columnNames, name)
+		}
+		slices.Sort(columnNames)
+		for _, name := range columnNames {
+			settings := config.ColumnSettings[name]
+			fmt.Printf("%s: min-width=%d max-width=%d truncation=%q alignment=%q header-name=%q\n", name, settings.MinWidth, settings.MaxWidth, settings.Truncation, settings.Alignment, settings.HeaderName)
+		}
+	},
+}
+
+var getCustomColumnTimeoutCmd = &cobra.Command{
+	Use:   "custom-column-timeout",
+	Short: "Get the maximum number of milliseconds that each custom column command may run for",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.CustomColumnTimeoutMs)
+	},
+}
+
+var getCustomColumnBudgetCmd = &cobra.Command{
+	Use:   "custom-column-budget",
+	Short: "Get the maximum number of milliseconds that all custom column commands may run for in total",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.CustomColumnBudgetMs)
+	},
+}
+
+var getCustomColumnCacheTtlCmd = &cobra.Command{
+	Use:   "custom-column-cache-ttl",
+	Short: "Get the number of seconds that custom column values are cached for in each directory",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.CustomColumnCacheTtlSeconds)
+	},
+}
+
+var getSecretPolicyCmd = &cobra.Command{
+	Use:   "secret-policy",
+	Short: "Get how commands that contain secrets are handled",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		policy := config.SecretPolicy
+		if policy == "" {
+			policy = "off"
+		}
+		fmt.Println(policy)
+	},
+}
+
+var getSecretPatternsCmd = &cobra.Command{
+	Use:   "secret-patterns",
+	Short: "Get the custom regexes used to detect secrets",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, p := range config.SecretPatterns {
+			fmt.Println(p)
+		}
+	},
+}
+
+var getIgnoredCommandsCmd = &cobra.Command{
+	Use:   "ignored-commands",
+	Short: "Get the regexes for commands that are never recorded",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, p := range config.IgnoredCommands {
+			fmt.Println(p)
+		}
+	},
+}
+
+var getIgnoredDirectoriesCmd = &cobra.Command{
+	Use:   "ignored-directories",
+	Short: "Get the globs for directories in which commands are never recorded",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		for _, p := range config.IgnoredDirectories {
+			fmt.Println(p)
+		}
+	},
+}
+
+var getRunOutputMaxBytesCmd = &cobra.Command{
+	Use:   "run-output-max-bytes",
+	Short: "Get the maximum number of bytes of output that `hishtory run` records for each command",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		fmt.Println(config.RunOutputMaxBytes)
+	},
+}
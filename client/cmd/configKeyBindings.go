@@ -33,6 +33,9 @@ var getKeyBindingsCmd = &cobra.Command{
 		fmt.Println("jump-end-of-input: \t" + strings.Join(config.KeyBindings.JumpEndOfInput, " "))
 		fmt.Println("word-left: \t\t" + strings.Join(config.KeyBindings.WordLeft, " "))
 		fmt.Println("word-right: \t\t" + strings.Join(config.KeyBindings.WordRight, " "))
+		fmt.Println("previous-query: \t" + strings.Join(config.KeyBindings.PreviousQuery, " "))
+		fmt.Println("next-query: \t\t" + strings.Join(config.KeyBindings.NextQuery, " "))
+		fmt.Println("inspect: \t\t" + strings.Join(config.KeyBindings.Inspect, " "))
 	},
 }
 
@@ -78,6 +81,12 @@ var setKeyBindingsCmd = &cobra.Command{
 			config.KeyBindings.WordLeft = args[1:]
 		case "word-right":
 			config.KeyBindings.WordRight = args[1:]
+		case "previous-query":
+			config.KeyBindings.PreviousQuery = args[1:]
+		case "next-query":
+			config.KeyBindings.NextQuery = args[1:]
+		case "inspect":
+			config.KeyBindings.Inspect = args[1:]
 		default:
 			lib.CheckFatalError(fmt.Errorf("unknown action %q, run `hishtory config-get keybindings` to see the list of currently configured key bindings", args[0]))
 		}
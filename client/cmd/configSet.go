@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/ddworken/hishtory/client/hctx"
@@ -294,6 +295,58 @@ var setFullScreenCmd = &cobra.Command{
 	},
 }
 
+var setMultiLineCommandsCmd = &cobra.Command{
+	Use:       "multi-line-commands",
+	Short:     "Whether multi-line commands are rendered across multiple lines in the TUI, rather than being escaped onto a single line",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.MultiLineCommands = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setMaxLinesPerRowCmd = &cobra.Command{
+	Use:   "max-lines-per-row",
+	Short: "The maximum number of lines displayed for multi-line commands that aren't currently selected in the TUI",
+	Long:  "Only applies if multi-line-commands is enabled. The selected command is always expanded to show the full command.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		val, err := strconv.Atoi(args[0])
+		if err != nil || val < 1 {
+			log.Fatalf("Unexpected config value %s, must be a positive integer", args[0])
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.MaxLinesPerRow = val
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setSyncQueryHistoryCmd = &cobra.Command{
+	Use:       "sync-query-history",
+	Short:     "Whether search queries run in the TUI are synced to your other devices so that they can be recalled there",
+	Long:      "Devices running older versions of hishtory don't understand synced search queries and may record them as an empty history entry, so this should only be enabled once all of your devices are up to date.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: []string{"true", "false"},
+	Run: func(cmd *cobra.Command, args []string) {
+		val := args[0]
+		if val != "true" && val != "false" {
+			log.Fatalf("Unexpected config value %s, must be one of: true, false", val)
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.SyncQueryHistory = (val == "true")
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 func validateDefaultSearchColumns(ctx context.Context, columns []string) error {
 	customColNames, err := lib.GetAllCustomColumnNames(ctx)
 	if err != nil {
@@ -324,6 +377,125 @@ var setDefaultSearchColumns = &cobra.Command{
 	},
 }
 
+var setCustomColumnTimeoutCmd = &cobra.Command{
+	Use:   "custom-column-timeout",
+	Short: "The maximum number of milliseconds that each custom column command may run for",
+	Long:  "Custom column commands that don't finish in time are recorded as \"" + lib.CUSTOM_COLUMN_TIMEOUT_MARKER + "\" so that a slow command can't block your prompt.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		val, err := strconv.Atoi(args[0])
+		if err != nil || val < 1 {
+			log.Fatalf("Unexpected config value %s, must be a positive integer", args[0])
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.CustomColumnTimeoutMs = val
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setCustomColumnBudgetCmd = &cobra.Command{
+	Use:   "custom-column-budget",
+	Short: "The maximum number of milliseconds that all custom column commands may run for in total",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		val, err := strconv.Atoi(args[0])
+		if err != nil || val < 1 {
+			log.Fatalf("Unexpected config value %s, must be a positive integer", args[0])
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.CustomColumnBudgetMs = val
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setCustomColumnCacheTtlCmd = &cobra.Command{
+	Use:   "custom-column-cache-ttl",
+	Short: "The number of seconds that custom column values are cached for in each directory, or 0 to disable caching",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		val, err := strconv.Atoi(args[0])
+		if err != nil || val < 0 {
+			log.Fatalf("Unexpected config value %s, must be a non-negative integer", args[0])
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.CustomColumnCacheTtlSeconds = val
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setSecretPolicyCmd = &cobra.Command{
+	Use:       "secret-policy",
+	Short:     "Configure how commands that contain secrets (e.g. API keys or passwords) are handled",
+	Long:      "With `mask`, detected secrets are replaced with " + lib.SECRET_MASK + " before the command is saved or synced. With `skip`, commands containing secrets aren't recorded at all. With `off`, commands aren't scanned for secrets.",
+	Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	ValidArgs: lib.SUPPORTED_SECRET_POLICIES,
+	Run: func(cmd *cobra.Command, args []string) {
+		lib.CheckFatalError(lib.ValidateSecretPolicy(args[0]))
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.SecretPolicy = args[0]
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setColumnSettingsCmd = &cobra.Command{
+	Use:   "column-settings",
+	Short: "Configure how an individual column is displayed",
+	Long:  "Configure how an individual column is displayed. For example, to right-align the exit code column, run `hishtory config-set column-settings 'Exit Code' alignment right`. Supported settings are min-width, max-width, truncation (one of: " + strings.Join(lib.SUPPORTED_TRUNCATION_STRATEGIES, ", ") + "), alignment (one of: " + strings.Join(lib.SUPPORTED_COLUMN_ALIGNMENTS, ", ") + "), and header-name.",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		columnName, setting, val := args[0], args[1], args[2]
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		settings := config.ColumnSettings[columnName]
+		switch setting {
+		case "min-width", "max-width":
+			width, err := strconv.Atoi(val)
+			if err != nil {
+				log.Fatalf("Unexpected config value %s, must be a non-negative integer", val)
+			}
+			if setting == "min-width" {
+				settings.MinWidth = width
+			} else {
+				settings.MaxWidth = width
+			}
+		case "truncation":
+			settings.Truncation = val
+		case "alignment":
+			settings.Alignment = val
+		case "header-name":
+			settings.HeaderName = val
+		default:
+			log.Fatalf("Unexpected column setting %s, must be one of: min-width, max-width, truncation, alignment, header-name", setting)
+		}
+		lib.CheckFatalError(lib.ValidateColumnSettings(settings))
+		if config.ColumnSettings == nil {
+			config.ColumnSettings = make(map[string]hctx.ColumnSettings)
+		}
+		config.ColumnSettings[columnName] = settings
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
+var setRunOutputMaxBytesCmd = &cobra.Command{
+	Use:   "run-output-max-bytes",
+	Short: "The maximum number of bytes of output that `hishtory run` records for each command",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		val, err := strconv.Atoi(args[0])
+		if err != nil || val < 1 {
+			log.Fatalf("Unexpected config value %s, must be a positive integer", args[0])
+		}
+		ctx := hctx.MakeContext()
+		config := hctx.GetConf(ctx)
+		config.RunOutputMaxBytes = val
+		lib.CheckFatalError(hctx.SetConfig(config))
+	},
+}
+
 func init() {
 	rootCmd.AddCommand(configSetCmd)
 	configSetCmd.AddCommand(setEnableControlRCmd)
@@ -342,6 +514,15 @@ func init() {
 	configSetCmd.AddCommand(setLogLevelCmd)
 	configSetCmd.AddCommand(setFullScreenCmd)
 	configSetCmd.AddCommand(setDefaultSearchColumns)
+	configSetCmd.AddCommand(setMultiLineCommandsCmd)
+	configSetCmd.AddCommand(setMaxLinesPerRowCmd)
+	configSetCmd.AddCommand(setSyncQueryHistoryCmd)
+	configSetCmd.AddCommand(setColumnSettingsCmd)
+	configSetCmd.AddCommand(setCustomColumnTimeoutCmd)
+	configSetCmd.AddCommand(setCustomColumnBudgetCmd)
+	configSetCmd.AddCommand(setCustomColumnCacheTtlCmd)
+	configSetCmd.AddCommand(setSecretPolicyCmd)
+	configSetCmd.AddCommand(setRunOutputMaxBytesCmd)
 	setColorSchemeCmd.AddCommand(setColorSchemeSelectedText)
 	setColorSchemeCmd.AddCommand(setColorSchemeSelectedBackground)
 	setColorSchemeCmd.AddCommand(setColorSchemeBorderColor)
@@ -0,0 +1,347 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ddworken/hishtory/client/backend"
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+const (
+	// How long to wait for additional requests before writing a batch to the DB
+	daemonBatchWindow = 20 * time.Millisecond
+	// The maximum number of queued requests, after which the shell hooks fall back to persisting entries directly
+	daemonQueueSize = 1000
+	// How often the daemon syncs with the backend, and the minimum delay between syncs requested by the shell hooks
+	daemonSyncInterval    = 5 * time.Minute
+	daemonMinSyncInterval = 30 * time.Second
+	// The bounds for the exponential backoff when the backend can't be reached
+	daemonMinSyncBackoff = 30 * time.Second
+	daemonMaxSyncBackoff = 30 * time.Minute
+)
+
+var daemonCmd = &cobra.Command{
+	Use:     "daemon",
+	Short:   "Run a daemon that records and syncs history entries in the background, to speed up your shell prompt",
+	Long:    "Run a daemon that records and syncs history entries in the background, to speed up your shell prompt. While the daemon is running, the shell hooks hand off history entries to it rather than opening the local DB and syncing on every prompt. If the daemon isn't running, the shell hooks fall back to persisting history entries directly.",
+	GroupID: GROUP_ID_CONFIG,
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		homedir, err := os.UserHomeDir()
+		lib.CheckFatalError(err)
+		db, err := hctx.OpenLocalSqliteDb()
+		lib.CheckFatalError(err)
+		lib.CheckFatalError(runDaemon(ctx, homedir, db))
+	},
+}
+
+type daemon struct {
+	homedir       string
+	db            *gorm.DB
+	requests      chan lib.DaemonRequest
+	syncRequested chan struct{}
+	lastSync      time.Time
+	nextSync      time.Time
+	syncBackoff   time.Duration
+	// Dump requests that failed to be handled, which are retried on the next sync
+	pendingDumpRequests []*shared.DumpRequest
+}
+
+func newDaemon(homedir string, db *gorm.DB) *daemon {
+	return &daemon{
+		homedir:       homedir,
+		db:            db,
+		requests:      make(chan lib.DaemonRequest, daemonQueueSize),
+		syncRequested: make(chan struct{}, 1),
+	}
+}
+
+// runDaemon serves requests from the shell hooks until ctx is cancelled
+func runDaemon(ctx context.Context, homedir string, db *gorm.DB) error {
+	l, err := lib.ListenForDaemonRequests(homedir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(lib.GetDaemonSocketPath(homedir))
+	hctx.GetLogger().Infof("hishtory daemon listening on %s", lib.GetDaemonSocketPath(homedir))
+
+	d := newDaemon(homedir, db)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- lib.ServeDaemonRequests(l, d.enqueue)
+	}()
+	d.run(ctx)
+	return <-serveErr
+}
+
+func (d *daemon) enqueue(req lib.DaemonRequest) error {
+	switch req.Type {
+	case lib.DaemonRequestPresave, lib.DaemonRequestSave:
+		if req.Entry == nil {
+			return fmt.Errorf("%s request is missing an entry", req.Type)
+		}
+		select {
+		case d.requests <- req:
+			return nil
+		default:
+			return fmt.Errorf("request queue is full")
+		}
+	case lib.DaemonRequestSync:
+		select {
+		case d.syncRequested <- struct{}{}:
+		default:
+			// A sync is already pending
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected request type %#v", req.Type)
+	}
+}
+
+// Processes queued requests and periodically syncs. Everything that touches the DB or the config runs on this
+// goroutine, so that requests are persisted in the order they were received.
+func (d *daemon) run(ctx context.Context) {
+	for {
+		syncTimer := time.NewTimer(time.Until(d.nextSync))
+		select {
+		case <-ctx.Done():
+			syncTimer.Stop()
+			// Persist anything that was already acknowledged before exiting
+			d.processBatch(d.drainRequests(nil))
+			return
+		case req := <-d.requests:
+			syncTimer.Stop()
+			time.Sleep(daemonBatchWindow)
+			d.processBatch(d.drainRequests([]lib.DaemonRequest{req}))
+		case <-d.syncRequested:
+			syncTimer.Stop()
+			if d.syncBackoff == 0 && time.Since(d.lastSync) >= daemonMinSyncInterval {
+				d.sync()
+			}
+		case <-syncTimer.C:
+			d.sync()
+		}
+	}
+}
+
+func (d *daemon) drainRequests(batch []lib.DaemonRequest) []lib.DaemonRequest {
+	for {
+		select {
+		case req := <-d.requests:
+			batch = append(batch, req)
+		default:
+			return batch
+		}
+	}
+}
+
+// Builds a context for processing a batch. The config is re-read every time since it may have been changed
+// by other hishtory commands.
+func (d *daemon) makeContext() (context.Context, error) {
+	config, err := hctx.GetConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve config: %w", err)
+	}
+	ctx := context.WithValue(context.Background(), hctx.ConfigCtxKey, &config)
+	ctx = context.WithValue(ctx, hctx.DbCtxKey, d.db)
+	ctx = context.WithValue(ctx, hctx.HomedirCtxKey, d.homedir)
+	return ctx, nil
+}
+
+func presavedEntryKey(entry *data.HistoryEntry) string {
+	return entry.CurrentWorkingDirectory + "\x00" + strconv.FormatInt(entry.StartTime.Unix(), 10) + "\x00" + entry.Command
+}
+
+func (d *daemon) processBatch(reqs []lib.DaemonRequest) {
+	if len(reqs) == 0 {
+		return
+	}
+	ctx, err := d.makeContext()
+	if err != nil {
+		hctx.GetLogger().Warnf("daemon: dropping %d requests: %v", len(reqs), err)
+		return
+	}
+	config := hctx.GetConf(ctx)
+	entries := make([]*data.HistoryEntry, 0, len(reqs))
+	presavedInBatch := make(map[string]int)
+	for _, req := range reqs {
+		entry := req.Entry
+		cc, err := lib.BuildCustomColumnsInDir(ctx, entry.CurrentWorkingDirectory, req.Cwd, req.Env)
+		if err != nil {
+			hctx.GetLogger().Warnf("daemon: failed to build custom columns: %v", err)
+		} else {
+			entry.CustomColumns = cc
+		}
+		if req.Type == lib.DaemonRequestPresave {
+			presavedInBatch[presavedEntryKey(entry)] = len(entries)
+			entries = append(entries, entry)
+			continue
+		}
+		if config.EnablePresaving {
+			if i, ok := presavedInBatch[presavedEntryKey(entry)]; ok {
+				// The presaved entry hasn't been persisted yet, so it can just be dropped
+				entries[i] = nil
+			} else {
+				// Requests are processed in order, so a missing presaved entry can't be due to a race with
+				// presaving and there is no need to retry the lookup
+				err := deletePresavedEntries(ctx, entry, deletePresavedEntriesMaxRetries)
+				if err != nil {
+					hctx.GetLogger().Warnf("daemon: failed to delete presaved entry: %v", err)
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+	entries = slices.DeleteFunc(entries, func(e *data.HistoryEntry) bool { return e == nil })
+	if len(entries) == 0 {
+		return
+	}
+
+	// Persist them locally
+	toCreate := make([]data.HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		toCreate = append(toCreate, *entry)
+	}
+	err = lib.ReliableDbCreateAll(d.db, toCreate)
+	if err != nil {
+		hctx.GetLogger().Warnf("daemon: failed to persist %d history entries: %v", len(entries), err)
+		return
+	}
+	hctx.GetLogger().Debugf("daemon: persisted %d history entries", len(entries))
+
+	// And persist them remotely
+	if config.IsOffline {
+		return
+	}
+	encEntries, err := lib.EncryptEntries(config, entries)
+	if err != nil {
+		hctx.GetLogger().Warnf("daemon: failed to encrypt history entries: %v", err)
+		return
+	}
+	b, ctx := lib.GetSyncBackend(ctx)
+	submitResponse, err := b.SubmitEntries(ctx, encEntries, config.DeviceId)
+	if err != nil {
+		hctx.GetLogger().Warnf("daemon: failed to upload %d history entries, will retry later: %v", len(entries), err)
+		err = recordMissedUpload(config, entries[0].StartTime)
+		if err != nil {
+			hctx.GetLogger().Warnf("daemon: failed to record missed upload: %v", err)
+		}
+		d.backOff()
+		return
+	}
+	if submitResponse != nil {
+		err = lib.HandleDeletionRequests(ctx, submitResponse.DeletionRequests)
+		if err != nil {
+			hctx.GetLogger().Warnf("daemon: failed to handle deletion requests: %v", err)
+		}
+		err = d.handleDumpRequests(ctx, submitResponse.DumpRequests)
+		if err != nil {
+			hctx.GetLogger().Warnf("daemon: failed to handle dump requests, will retry later: %v", err)
+			d.backOff()
+		}
+	}
+}
+
+// Handles the given dump requests along with any that previously failed. If this fails, they are all queued
+// to be retried on the next sync, rather than relying on the backend to return them again.
+func (d *daemon) handleDumpRequests(ctx context.Context, dumpRequests []*shared.DumpRequest) error {
+	for _, req := range dumpRequests {
+		if !slices.ContainsFunc(d.pendingDumpRequests, func(r *shared.DumpRequest) bool { return r.RequestingDeviceId == req.RequestingDeviceId }) {
+			d.pendingDumpRequests = append(d.pendingDumpRequests, req)
+		}
+	}
+	if len(d.pendingDumpRequests) == 0 {
+		return nil
+	}
+	err := handleDumpRequests(ctx, d.pendingDumpRequests)
+	if err != nil {
+		return err
+	}
+	d.pendingDumpRequests = nil
+	return nil
+}
+
+// Uploads any entries that previously failed to upload and retrieves new entries from the backend
+func (d *daemon) sync() {
+	d.lastSync = time.Now()
+	ctx, err := d.makeContext()
+	if err != nil {
+		hctx.GetLogger().Warnf("daemon: failed to sync: %v", err)
+		d.backOff()
+		return
+	}
+	if hctx.GetConf(ctx).IsOffline {
+		d.nextSync = time.Now().Add(daemonSyncInterval)
+		return
+	}
+	if !lib.CanReachBackend(ctx) {
+		hctx.GetLogger().Infof("daemon: backend is unreachable, backing off")
+		d.backOff()
+		return
+	}
+	ctx, err = lib.ApplyPendingBackendSwitch(ctx)
+	if err != nil {
+		// Keep syncing via the current backend, and retry the switch on the next sync
+		hctx.GetLogger().Warnf("daemon: %v", err)
+	}
+	err = maybeUploadSkippedHistoryEntries(ctx)
+	if err == nil {
+		err = maybeSubmitPendingDeletionRequests(ctx)
+	}
+	if err == nil {
+		err = lib.RetrieveAdditionalEntriesFromRemote(ctx, "preload")
+	}
+	if err == nil {
+		err = d.handleDumpRequests(ctx, nil)
+	}
+	if err != nil {
+		hctx.GetLogger().Warnf("daemon: failed to sync: %v", err)
+		d.backOff()
+		return
+	}
+	d.syncBackoff = 0
+	d.nextSync = time.Now().Add(daemonSyncInterval)
+
+	// Opportunistically compact the S3 bucket. MaybeGC ensures that only one device runs GC per day.
+	b, ctx := lib.GetSyncBackend(ctx)
+	for _, b := range backend.Backends(b) {
+		s3Backend, ok := b.(*backend.S3Backend)
+		if !ok {
+			continue
+		}
+		stats, err := s3Backend.MaybeGC(ctx)
+		if err != nil {
+			hctx.GetLogger().Warnf("daemon: failed to GC S3 bucket: %v", err)
+		} else if stats != nil {
+			hctx.GetLogger().Infof("daemon: GC compacted %d entries and deleted %d objects", stats.EntriesCompacted, stats.ObjectsDeleted)
+		}
+	}
+}
+
+func (d *daemon) backOff() {
+	d.syncBackoff = min(max(2*d.syncBackoff, daemonMinSyncBackoff), daemonMaxSyncBackoff)
+	d.nextSync = time.Now().Add(d.syncBackoff)
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+}
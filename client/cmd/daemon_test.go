@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/shared"
+	"github.com/ddworken/hishtory/shared/testutils"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonProcessBatch(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	config := hctx.GetConf(ctx)
+	config.IsOffline = true
+	config.EnablePresaving = true
+	config.CustomColumns = []hctx.CustomColumnDefinition{{ColumnName: "dir", ColumnCommand: "pwd"}}
+	require.NoError(t, hctx.SetConfig(config))
+	db := hctx.GetDb(ctx)
+	d := newDaemon(hctx.GetHome(ctx), db)
+	tmpDir := t.TempDir()
+
+	makeEntry := func(command string, endTime time.Time) *data.HistoryEntry {
+		entry := testutils.MakeFakeHistoryEntry(command)
+		entry.StartTime = time.Unix(1700000000, 0).UTC()
+		entry.EndTime = endTime
+		return &entry
+	}
+	countEntries := func() int64 {
+		var count int64
+		require.NoError(t, db.Model(&data.HistoryEntry{}).Count(&count).Error)
+		return count
+	}
+
+	// A presave and a save in the same batch only persists the completed entry
+	d.processBatch([]lib.DaemonRequest{
+		{Type: lib.DaemonRequestPresave, Entry: makeEntry("ls /foo", time.Unix(0, 0).UTC()), Cwd: tmpDir},
+		{Type: lib.DaemonRequestSave, Entry: makeEntry("ls /foo", time.Unix(1700000005, 0).UTC()), Cwd: tmpDir},
+	})
+	require.Equal(t, int64(1), countEntries())
+	var entry data.HistoryEntry
+	require.NoError(t, db.Where("command = ?", "ls /foo").First(&entry).Error)
+	require.Equal(t, time.Unix(1700000005, 0).UTC(), entry.EndTime.UTC())
+	// Custom columns are evaluated in the shell's directory
+	require.Equal(t, data.CustomColumns{{Name: "dir", Val: tmpDir}}, entry.CustomColumns)
+
+	// A presaved entry from an earlier batch is deleted once the command finishes
+	d.processBatch([]lib.DaemonRequest{
+		{Type: lib.DaemonRequestPresave, Entry: makeEntry("sleep 100", time.Unix(0, 0).UTC()), Cwd: tmpDir},
+	})
+	require.Equal(t, int64(2), countEntries())
+	d.processBatch([]lib.DaemonRequest{
+		{Type: lib.DaemonRequestSave, Entry: makeEntry("sleep 100", time.Unix(1700000100, 0).UTC()), Cwd: tmpDir},
+	})
+	require.Equal(t, int64(2), countEntries())
+	require.NoError(t, db.Where("command = ?", "sleep 100").First(&entry).Error)
+	require.Equal(t, time.Unix(1700000100, 0).UTC(), entry.EndTime.UTC())
+}
+
+func TestDaemonRetriesDumpRequests(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	config := hctx.GetConf(ctx)
+	syncDir := t.TempDir()
+	config.BackendType = "dir"
+	config.DirConfig = &hctx.DirBackendConfig{Path: syncDir}
+	require.NoError(t, hctx.SetConfig(config))
+	entry := testutils.MakeFakeHistoryEntry("ls /foo")
+	require.NoError(t, lib.ReliableDbCreate(hctx.GetDb(ctx), entry))
+	d := newDaemon(hctx.GetHome(ctx), hctx.GetDb(ctx))
+	b, ctx := lib.GetSyncBackend(ctx)
+	require.NoError(t, b.RegisterDevice(ctx, data.UserId(config.UserSecret), config.DeviceId))
+
+	// The dump fails since the requesting device's inbox can't be created, so it is queued to be retried
+	inboxPath := filepath.Join(syncDir, data.UserId(config.UserSecret), "inbox", "other-device")
+	require.NoError(t, os.MkdirAll(filepath.Dir(inboxPath), 0o700))
+	require.NoError(t, os.WriteFile(inboxPath, []byte{}, 0o600))
+	dumpRequest := &shared.DumpRequest{UserId: data.UserId(config.UserSecret), RequestingDeviceId: "other-device"}
+	require.Error(t, d.handleDumpRequests(ctx, []*shared.DumpRequest{dumpRequest}))
+	require.Len(t, d.pendingDumpRequests, 1)
+	require.Error(t, d.handleDumpRequests(ctx, []*shared.DumpRequest{dumpRequest}))
+	require.Len(t, d.pendingDumpRequests, 1)
+
+	// And once the inbox can be written to, the retry succeeds
+	require.NoError(t, os.Remove(inboxPath))
+	require.NoError(t, d.handleDumpRequests(ctx, nil))
+	require.Empty(t, d.pendingDumpRequests)
+	entries, err := b.QueryEntries(ctx, "other-device", data.UserId(config.UserSecret), "test")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestDaemonEnqueue(t *testing.T) {
+	d := newDaemon(os.TempDir(), nil)
+	require.Error(t, d.enqueue(lib.DaemonRequest{Type: lib.DaemonRequestSave}))
+	require.Error(t, d.enqueue(lib.DaemonRequest{Type: "unknown"}))
+	entry := testutils.MakeFakeHistoryEntry("ls")
+	require.NoError(t, d.enqueue(lib.DaemonRequest{Type: lib.DaemonRequestSave, Entry: &entry}))
+	require.Len(t, d.drainRequests(nil), 1)
+
+	// Sync requests are coalesced
+	require.NoError(t, d.enqueue(lib.DaemonRequest{Type: lib.DaemonRequestSync}))
+	require.NoError(t, d.enqueue(lib.DaemonRequest{Type: lib.DaemonRequestSync}))
+	require.Len(t, d.syncRequested, 1)
+}
+
+func TestDaemonBackOff(t *testing.T) {
+	d := newDaemon(os.TempDir(), nil)
+	d.backOff()
+	require.Equal(t, daemonMinSyncBackoff, d.syncBackoff)
+	d.backOff()
+	require.Equal(t, 2*daemonMinSyncBackoff, d.syncBackoff)
+	for range 10 {
+		d.backOff()
+	}
+	require.Equal(t, daemonMaxSyncBackoff, d.syncBackoff)
+}
@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/backend"
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// The WAL is normally checkpointed back into the DB long before it reaches this size
+const doctorMaxWalSize = 64 * 1024 * 1024
+
+var doctorJsonFlag *bool
+
+var doctorCmd = &cobra.Command{
+	Use:     "doctor",
+	GroupID: GROUP_ID_INSTALL,
+	Short:   "Check your hishtory installation for problems",
+	Long:    "Checks that your shells are configured to record history, that the config and the local DB are valid, and that syncing works, and prints how to fix any problems that are found. Use --json to get a report to attach to a bug report.",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		homedir, err := os.UserHomeDir()
+		lib.CheckFatalError(err)
+		report := doctorReport{
+			Version: "v0." + lib.Version,
+			Os:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+			Checks:  runDoctorChecks(homedir),
+		}
+		if *doctorJsonFlag {
+			out, err := json.MarshalIndent(report, "", "  ")
+			lib.CheckFatalError(err)
+			fmt.Println(string(out))
+		} else {
+			printDoctorReport(report)
+		}
+		if report.hasErrors() {
+			os.Exit(1)
+		}
+	},
+}
+
+type doctorStatus string
+
+const (
+	doctorOk      doctorStatus = "ok"
+	doctorWarning doctorStatus = "warning"
+	doctorError   doctorStatus = "error"
+	doctorSkipped doctorStatus = "skipped"
+)
+
+// doctorCheck is the result of a single check run by `hishtory doctor`
+type doctorCheck struct {
+	Name    string       `json:"name"`
+	Status  doctorStatus `json:"status"`
+	Message string       `json:"message"`
+	// How to fix the problem, only set if the check didn't pass
+	Fix string `json:"fix,omitempty"`
+}
+
+type doctorReport struct {
+	Version string        `json:"version"`
+	Os      string        `json:"os"`
+	Arch    string        `json:"arch"`
+	Checks  []doctorCheck `json:"checks"`
+}
+
+func (r doctorReport) hasErrors() bool {
+	for _, check := range r.Checks {
+		if check.Status == doctorError {
+			return true
+		}
+	}
+	return false
+}
+
+func printDoctorReport(report doctorReport) {
+	fmt.Printf("hiSHtory %s (%s/%s)\n\n", report.Version, report.Os, report.Arch)
+	errorCount, warningCount := 0, 0
+	for _, check := range report.Checks {
+		switch check.Status {
+		case doctorError:
+			errorCount++
+		case doctorWarning:
+			warningCount++
+		}
+		fmt.Printf("%-9s %s: %s\n", "["+strings.ToUpper(string(check.Status))+"]", check.Name, check.Message)
+		if check.Fix != "" {
+			fmt.Printf("%-9s Fix: %s\n", "", check.Fix)
+		}
+	}
+	fmt.Printf("\nFound %d errors and %d warnings\n", errorCount, warningCount)
+}
+
+// runDoctorChecks runs all checks. Checks that depend on an earlier check which failed are skipped rather than
+// failing with a confusing error.
+func runDoctorChecks(homedir string) []doctorCheck {
+	hishtoryDir := path.Join(homedir, data.GetHishtoryPath())
+	checks := []doctorCheck{checkHishtoryPath(hishtoryDir)}
+	if checks[0].Status == doctorError {
+		return checks
+	}
+	checks = append(checks, checkShellHooks(homedir)...)
+
+	config, err := hctx.GetConfig()
+	if err != nil {
+		checks = append(checks, doctorCheck{Name: "Config", Status: doctorError, Message: err.Error(), Fix: fmt.Sprintf("fix the JSON syntax error in %s, or reinstall with `hishtory install` after moving it aside", path.Join(hishtoryDir, data.CONFIG_PATH))})
+	} else {
+		checks = append(checks, doctorCheck{Name: "Config", Status: doctorOk, Message: "parsed " + path.Join(hishtoryDir, data.CONFIG_PATH)})
+	}
+
+	db, err := hctx.OpenLocalSqliteDb()
+	if err != nil {
+		checks = append(checks, doctorCheck{Name: "Database", Status: doctorError, Message: err.Error(), Fix: "check the permissions of " + path.Join(hishtoryDir, data.DB_PATH)})
+	} else {
+		checks = append(checks, checkDbIntegrity(db), checkWalSize(hishtoryDir))
+	}
+
+	if config.UserSecret == "" {
+		return append(checks, doctorCheck{Name: "Sync", Status: doctorSkipped, Message: "the config couldn't be read"})
+	}
+	ctx := context.WithValue(context.Background(), hctx.ConfigCtxKey, &config)
+	ctx = context.WithValue(ctx, hctx.HomedirCtxKey, homedir)
+	if db != nil {
+		ctx = context.WithValue(ctx, hctx.DbCtxKey, db)
+	}
+	checks = append(checks, checkPendingSync(&config)...)
+	checks = append(checks, checkBackends(ctx)...)
+	checks = append(checks, checkServerVersion(ctx))
+	logContents, err := os.ReadFile(path.Join(hishtoryDir, "hishtory.log"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		checks = append(checks, doctorCheck{Name: "Custom columns", Status: doctorWarning, Message: fmt.Sprintf("failed to read hishtory.log: %v", err)})
+	} else {
+		checks = append(checks, checkCustomColumns(config.CustomColumns, string(logContents))...)
+	}
+	return checks
+}
+
+func checkHishtoryPath(hishtoryDir string) doctorCheck {
+	check := doctorCheck{Name: "HISHTORY_PATH"}
+	fix := "run `hishtory install`"
+	if os.Getenv("HISHTORY_PATH") != "" {
+		fix = "check that HISHTORY_PATH points to the directory hishtory was installed in, or run `hishtory install`"
+	}
+	info, err := os.Stat(hishtoryDir)
+	if err != nil {
+		check.Status, check.Message, check.Fix = doctorError, fmt.Sprintf("%s doesn't exist", hishtoryDir), fix
+		return check
+	}
+	if !info.IsDir() {
+		check.Status, check.Message, check.Fix = doctorError, fmt.Sprintf("%s isn't a directory", hishtoryDir), fix
+		return check
+	}
+	for _, file := range []string{data.CONFIG_PATH, "hishtory"} {
+		if _, err := os.Stat(path.Join(hishtoryDir, file)); err != nil {
+			check.Status, check.Message, check.Fix = doctorError, fmt.Sprintf("%s is missing from %s", file, hishtoryDir), fix
+			return check
+		}
+	}
+	check.Status, check.Message = doctorOk, "using "+hishtoryDir
+	return check
+}
+
+func checkShellHooks(homedir string) []doctorCheck {
+	type shell struct {
+		binary       string
+		configScript string
+		rcPath       string
+		isConfigured func(string) (bool, error)
+	}
+	shells := []shell{
+		{"bash", getBashConfigPath(homedir), path.Join(homedir, ".bashrc"), isBashRcConfigured},
+		{"zsh", getZshConfigPath(homedir), getZshRcPath(homedir), isZshConfigured},
+		{"fish", getFishConfigPath(homedir), path.Join(homedir, ".config/fish/config.fish"), isFishConfigured},
+		{"nu", getNushellConfigPath(homedir), getNushellRcPath(homedir), isNushellConfigured},
+		{"pwsh", getPowerShellConfigPath(homedir), getPowerShellProfilePath(homedir), isPowerShellConfigured},
+	}
+	if doesBashProfileNeedConfig(homedir) {
+		shells = append(shells, shell{"bash", getBashConfigPath(homedir), path.Join(homedir, ".bash_profile"), isBashProfileConfigured})
+	}
+
+	var checks []doctorCheck
+	for _, s := range shells {
+		if _, err := exec.LookPath(s.binary); err != nil {
+			continue
+		}
+		check := doctorCheck{Name: fmt.Sprintf("Shell hooks (%s)", s.binary)}
+		configured, err := s.isConfigured(homedir)
+		if err != nil {
+			check.Status, check.Message = doctorError, err.Error()
+		} else if !configured {
+			check.Status, check.Message, check.Fix = doctorError, fmt.Sprintf("%s doesn't source hishtory", convertToRelativePath(s.rcPath)), "run `hishtory install` to configure it"
+		} else if _, err := os.Stat(s.configScript); err != nil {
+			check.Status, check.Message, check.Fix = doctorError, fmt.Sprintf("%s sources %s, which doesn't exist", convertToRelativePath(s.rcPath), convertToRelativePath(s.configScript)), "run `hishtory install` to recreate it"
+		} else {
+			check.Status, check.Message = doctorOk, fmt.Sprintf("%s sources hishtory", convertToRelativePath(s.rcPath))
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkDbIntegrity(db *gorm.DB) doctorCheck {
+	check := doctorCheck{Name: "Database integrity"}
+	var results []string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&results).Error; err != nil {
+		check.Status, check.Message = doctorError, fmt.Sprintf("failed to run integrity check: %v", err)
+		return check
+	}
+	if len(results) == 1 && results[0] == "ok" {
+		var count int64
+		db.Model(&data.HistoryEntry{}).Count(&count)
+		check.Status, check.Message = doctorOk, fmt.Sprintf("%d history entries", count)
+		return check
+	}
+	check.Status, check.Message = doctorError, "the database is corrupted: "+strings.Join(results, "; ")
+	check.Fix = "export your history with `hishtory export`, then move the database aside and run `hishtory install` to restore your history from the sync backend"
+	return check
+}
+
+func checkWalSize(hishtoryDir string) doctorCheck {
+	check := doctorCheck{Name: "Database WAL"}
+	info, err := os.Stat(path.Join(hishtoryDir, data.DB_PATH+"-wal"))
+	if errors.Is(err, os.ErrNotExist) {
+		check.Status, check.Message = doctorOk, "no WAL file"
+		return check
+	}
+	if err != nil {
+		check.Status, check.Message = doctorWarning, fmt.Sprintf("failed to check WAL file: %v", err)
+		return check
+	}
+	check.Status, check.Message = doctorOk, formatBytes(info.Size())
+	if info.Size() > doctorMaxWalSize {
+		check.Status = doctorWarning
+		check.Message = fmt.Sprintf("the WAL file is %s, so it isn't being checkpointed", formatBytes(info.Size()))
+		check.Fix = "restart any long-running hishtory processes such as `hishtory daemon` or `hishtory webui`"
+	}
+	return check
+}
+
+func checkPendingSync(config *hctx.ClientConfig) []doctorCheck {
+	if config.IsOffline {
+		return nil
+	}
+	fix := "these are retried automatically once the sync backend is reachable, see the backend checks below"
+	uploads := doctorCheck{Name: "Pending uploads", Status: doctorOk, Message: "none"}
+	if config.HaveMissedUploads {
+		uploads.Status = doctorWarning
+		uploads.Message = fmt.Sprintf("history entries recorded since %s haven't been uploaded", time.Unix(config.MissedUploadTimestamp, 0).Format(time.RFC3339))
+		uploads.Fix = fix
+	}
+	deletions := doctorCheck{Name: "Pending deletions", Status: doctorOk, Message: "none"}
+	if len(config.PendingDeletionRequests) > 0 {
+		deletions.Status = doctorWarning
+		deletions.Message = fmt.Sprintf("%d deletion requests haven't been sent to your other devices", len(config.PendingDeletionRequests))
+		deletions.Fix = fix
+	}
+	return []doctorCheck{uploads, deletions}
+}
+
+func checkBackends(ctx context.Context) []doctorCheck {
+	if hctx.GetConf(ctx).IsOffline {
+		return []doctorCheck{{Name: "Sync backend", Status: doctorSkipped, Message: "syncing is disabled"}}
+	}
+	statuses, err := lib.GetBackendStatuses(ctx)
+	if err != nil {
+		return []doctorCheck{{Name: "Sync backend", Status: doctorError, Message: err.Error(), Fix: "check the backend config with `hishtory status --full-config` and any required credentials in your environment"}}
+	}
+	var checks []doctorCheck
+	for _, status := range statuses {
+		check := doctorCheck{Name: "Sync backend"}
+		if !status.IsPrimary {
+			check.Name = "Mirror backend"
+		}
+		if status.Err != nil {
+			check.Status = doctorError
+			if !status.IsPrimary {
+				// Syncing continues via the other backends
+				check.Status = doctorWarning
+			}
+			check.Message = fmt.Sprintf("%s is unreachable: %v", status.Description, status.Err)
+			check.Fix = "check your network connection and the backend's credentials"
+		} else {
+			check.Status, check.Message = doctorOk, fmt.Sprintf("%s responded in %v", status.Description, status.Latency.Round(time.Microsecond))
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+func checkServerVersion(ctx context.Context) doctorCheck {
+	check := doctorCheck{Name: "Version"}
+	config := hctx.GetConf(ctx)
+	usesServer := !config.IsOffline && lib.BackendTypeOrDefault(config.BackendType) == string(backend.BackendTypeHTTP)
+	for _, mirror := range config.MirrorBackends {
+		usesServer = usesServer || lib.BackendTypeOrDefault(mirror.BackendType) == string(backend.BackendTypeHTTP)
+	}
+	if !usesServer || lib.Version == "Unknown" {
+		check.Status, check.Message = doctorSkipped, "v0."+lib.Version
+		return check
+	}
+	downloadData, err := GetDownloadData(ctx)
+	if err != nil {
+		check.Status, check.Message = doctorWarning, fmt.Sprintf("failed to get the latest version from the server: %v", err)
+		return check
+	}
+	if downloadData.Version != "v0."+lib.Version {
+		check.Status, check.Message, check.Fix = doctorWarning, fmt.Sprintf("v0.%s is installed, but the server is on %s", lib.Version, downloadData.Version), "run `hishtory update`"
+		return check
+	}
+	check.Status, check.Message = doctorOk, "v0."+lib.Version+" is the latest version"
+	return check
+}
+
+// checkCustomColumns reports custom columns whose commands failed or timed out according to hishtory.log
+func checkCustomColumns(columns []hctx.CustomColumnDefinition, logContents string) []doctorCheck {
+	var checks []doctorCheck
+	for _, cc := range columns {
+		check := doctorCheck{Name: fmt.Sprintf("Custom column %q", cc.ColumnName)}
+		failures, timeouts := countCustomColumnFailures(cc.ColumnName, logContents)
+		switch {
+		case failures > 0 || timeouts > 0:
+			check.Status = doctorWarning
+			check.Message = fmt.Sprintf("failed %d times and timed out %d times according to hishtory.log", failures, timeouts)
+			check.Fix = fmt.Sprintf("check that `%s` succeeds quickly, or raise the timeout via `hishtory config-set custom-column-timeout`", cc.ColumnCommand)
+		default:
+			check.Status, check.Message = doctorOk, "no failures in hishtory.log"
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// countCustomColumnFailures counts the failures and timeouts of the named custom column that were logged by
+// evaluateCustomColumn
+func countCustomColumnFailures(columnName, logContents string) (int, int) {
+	// Log messages are quoted since they contain spaces
+	quote := func(s string) string {
+		quoted := strconv.Quote(s)
+		return quoted[1 : len(quoted)-1]
+	}
+	failure := quote(fmt.Sprintf("failed to execute custom command named %v (", columnName))
+	timeout := quote(fmt.Sprintf("custom column %#v timed out", columnName))
+	failures, timeouts := 0, 0
+	for _, line := range strings.Split(logContents, "\n") {
+		if strings.Contains(line, failure) {
+			failures++
+		} else if strings.Contains(line, timeout) {
+			timeouts++
+		}
+	}
+	return failures, timeouts
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorJsonFlag = doctorCmd.Flags().Bool("json", false, "Print the results as JSON")
+}
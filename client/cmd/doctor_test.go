@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountCustomColumnFailures(t *testing.T) {
+	// Log the same messages as evaluateCustomColumn, with the same formatter as hctx.GetLogger
+	var logs bytes.Buffer
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	logger.SetOutput(&logs)
+	logger.Warnf("failed to execute custom command named %v (stdout=%#v, stderr=%#v)", "git branch", "", "fatal: not a git repository")
+	logger.Warnf("failed to execute custom command named %v (stdout=%#v, stderr=%#v)", "git branch", "", "")
+	logger.Warnf("custom column %#v timed out after %v", "git branch", "2s")
+	logger.Warnf("failed to execute custom command named %v (stdout=%#v, stderr=%#v)", "git", "", "")
+
+	failures, timeouts := countCustomColumnFailures("git branch", logs.String())
+	require.Equal(t, 2, failures)
+	require.Equal(t, 1, timeouts)
+	failures, timeouts = countCustomColumnFailures("git", logs.String())
+	require.Equal(t, 1, failures)
+	require.Equal(t, 0, timeouts)
+
+	checks := checkCustomColumns([]hctx.CustomColumnDefinition{{ColumnName: "git branch", ColumnCommand: "git branch --show-current"}, {ColumnName: "user", ColumnCommand: "whoami"}}, logs.String())
+	require.Len(t, checks, 2)
+	require.Equal(t, doctorWarning, checks[0].Status)
+	require.Contains(t, checks[0].Fix, "git branch --show-current")
+	require.Equal(t, doctorOk, checks[1].Status)
+}
+
+func TestCheckHishtoryPath(t *testing.T) {
+	dir := t.TempDir()
+	check := checkHishtoryPath(path.Join(dir, "missing"))
+	require.Equal(t, doctorError, check.Status)
+	require.Contains(t, check.Message, "doesn't exist")
+
+	check = checkHishtoryPath(dir)
+	require.Equal(t, doctorError, check.Status)
+	require.Contains(t, check.Message, data.CONFIG_PATH+" is missing")
+
+	require.NoError(t, os.WriteFile(path.Join(dir, data.CONFIG_PATH), []byte("{}"), 0o600))
+	require.NoError(t, os.WriteFile(path.Join(dir, "hishtory"), nil, 0o700))
+	check = checkHishtoryPath(dir)
+	require.Equal(t, doctorOk, check.Status)
+}
+
+func TestCheckPendingSync(t *testing.T) {
+	checks := checkPendingSync(&hctx.ClientConfig{})
+	require.Len(t, checks, 2)
+	require.Equal(t, doctorOk, checks[0].Status)
+	require.Equal(t, doctorOk, checks[1].Status)
+
+	checks = checkPendingSync(&hctx.ClientConfig{HaveMissedUploads: true, MissedUploadTimestamp: 1700000000, PendingDeletionRequests: make([]shared.DeletionRequest, 3)})
+	require.Equal(t, doctorWarning, checks[0].Status)
+	require.Equal(t, doctorWarning, checks[1].Status)
+	require.Contains(t, checks[1].Message, "3 deletion requests")
+
+	require.Empty(t, checkPendingSync(&hctx.ClientConfig{IsOffline: true, HaveMissedUploads: true}))
+}
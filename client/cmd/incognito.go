@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ddworken/hishtory/client/lib"
+
+	"github.com/spf13/cobra"
+)
+
+var incognitoCmd = &cobra.Command{
+	Use:       "incognito <on|off|status>",
+	Short:     "Pause hiSHtory recording for only the current shell session",
+	Long:      "Pause hiSHtory recording for only the current shell session (and any shells started from it). Unlike `hishtory disable`, this doesn't impact any other shells. `hishtory incognito status` prints `on` or `off` so that it can be included in your prompt.",
+	GroupID:   GROUP_ID_CONFIG,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{"on", "off", "status"},
+	Run: func(cmd *cobra.Command, args []string) {
+		switch args[0] {
+		case "on", "off":
+			// The shell integration already updated the environment before running this
+			lib.CheckFatalError(lib.CheckIncognitoUpdated(args[0] == "on"))
+		case "status":
+			if lib.IsIncognito() {
+				fmt.Println("on")
+			} else {
+				fmt.Println("off")
+			}
+		default:
+			lib.CheckFatalError(fmt.Errorf("unexpected argument %#v, must be one of: on, off, status", args[0]))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(incognitoCmd)
+}
@@ -15,6 +15,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/ddworken/hishtory/client/backend"
 	"github.com/ddworken/hishtory/client/data"
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
@@ -22,6 +23,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 	"gorm.io/gorm"
 )
 
@@ -188,6 +190,14 @@ func install(secretKey string, offline, skipConfigModification bool) error {
 	if err != nil {
 		return err
 	}
+	err = configureNushell(homedir, path, skipConfigModification)
+	if err != nil {
+		return err
+	}
+	err = configurePowerShell(homedir, path, skipConfigModification)
+	if err != nil {
+		return err
+	}
 	err = handleUpgradedFeatures()
 	if err != nil {
 		return err
@@ -247,6 +257,10 @@ func handleUpgradedFeatures() error {
 		// Whitespace filtering is not yet configured, so enable it by default
 		config.FilterWhitespacePrefix = true
 	}
+	if !strings.Contains(string(configContents), "secret_policy") {
+		// Secret scanning is not yet configured, so enable masking by default
+		config.SecretPolicy = "mask"
+	}
 	return hctx.SetConfig(&config)
 }
 
@@ -331,6 +345,135 @@ func isFishConfigured(homedir string) (bool, error) {
 	return strings.Contains(string(fishConfig), getFishConfigFragment(homedir)), nil
 }
 
+func getNushellConfigPath(homedir string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), "config.nu")
+}
+
+// Returns the path to the user's Nushell config.nu, which mirrors the logic for $nu.config-path
+func getNushellRcPath(homedir string) string {
+	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
+		return path.Join(xdgConfigHome, "nushell", "config.nu")
+	}
+	if runtime.GOOS == "darwin" {
+		return path.Join(homedir, "Library", "Application Support", "nushell", "config.nu")
+	}
+	return path.Join(homedir, ".config", "nushell", "config.nu")
+}
+
+func configureNushell(homedir, binaryPath string, skipConfigModification bool) error {
+	// Check if nushell is installed
+	_, err := exec.LookPath("nu")
+	if err != nil {
+		return nil
+	}
+	// Create the file we're going to source. Do this no matter what in case there are updates to it.
+	configContents := lib.ConfigNuContents
+	if os.Getenv("HISHTORY_TEST") != "" {
+		testConfig, err := tweakConfigForTests(configContents)
+		if err != nil {
+			return err
+		}
+		configContents = testConfig
+	}
+	err = os.WriteFile(getNushellConfigPath(homedir), []byte(configContents), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write config.nu file: %w", err)
+	}
+	// Check if we need to configure the nushell config
+	nushellIsConfigured, err := isNushellConfigured(homedir)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", getNushellRcPath(homedir), err)
+	}
+	if nushellIsConfigured {
+		return nil
+	}
+	// Add to the nushell config
+	err = os.MkdirAll(path.Dir(getNushellRcPath(homedir)), 0o744)
+	if err != nil {
+		return fmt.Errorf("failed to create nushell config directory: %w", err)
+	}
+	return addToShellConfig(getNushellRcPath(homedir), getNushellConfigFragment(homedir), skipConfigModification)
+}
+
+func getNushellConfigFragment(homedir string) string {
+	return "\n# Hishtory Config:\n$env.PATH = ($env.PATH | split row (char esep) | append \"" + path.Join(homedir, data.GetHishtoryPath()) + "\")\nsource \"" + getNushellConfigPath(homedir) + "\"\n"
+}
+
+func isNushellConfigured(homedir string) (bool, error) {
+	_, err := os.Stat(getNushellRcPath(homedir))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	nushellConfig, err := os.ReadFile(getNushellRcPath(homedir))
+	if err != nil {
+		return false, fmt.Errorf("failed to read nushell config: %w", err)
+	}
+	return strings.Contains(string(nushellConfig), getNushellConfigFragment(homedir)), nil
+}
+
+func getPowerShellConfigPath(homedir string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), "config.ps1")
+}
+
+// Returns the path to the user's PowerShell profile, which mirrors the value of $PROFILE for pwsh on unix
+func getPowerShellProfilePath(homedir string) string {
+	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
+		return path.Join(xdgConfigHome, "powershell", "Microsoft.PowerShell_profile.ps1")
+	}
+	return path.Join(homedir, ".config", "powershell", "Microsoft.PowerShell_profile.ps1")
+}
+
+func configurePowerShell(homedir, binaryPath string, skipConfigModification bool) error {
+	// Check if pwsh is installed
+	_, err := exec.LookPath("pwsh")
+	if err != nil {
+		return nil
+	}
+	// Create the file we're going to source. Do this no matter what in case there are updates to it.
+	configContents := lib.ConfigPs1Contents
+	if os.Getenv("HISHTORY_TEST") != "" {
+		testConfig, err := tweakConfigForTests(configContents)
+		if err != nil {
+			return err
+		}
+		configContents = testConfig
+	}
+	err = os.WriteFile(getPowerShellConfigPath(homedir), []byte(configContents), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write config.ps1 file: %w", err)
+	}
+	// Check if we need to configure the PowerShell profile
+	powerShellIsConfigured, err := isPowerShellConfigured(homedir)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", getPowerShellProfilePath(homedir), err)
+	}
+	if powerShellIsConfigured {
+		return nil
+	}
+	// Add to the PowerShell profile
+	err = os.MkdirAll(path.Dir(getPowerShellProfilePath(homedir)), 0o744)
+	if err != nil {
+		return fmt.Errorf("failed to create PowerShell profile directory: %w", err)
+	}
+	return addToShellConfig(getPowerShellProfilePath(homedir), getPowerShellConfigFragment(homedir), skipConfigModification)
+}
+
+func getPowerShellConfigFragment(homedir string) string {
+	return "\n# Hishtory Config:\n$env:PATH = \"$env:PATH:" + path.Join(homedir, data.GetHishtoryPath()) + "\"\n. \"" + getPowerShellConfigPath(homedir) + "\"\n"
+}
+
+func isPowerShellConfigured(homedir string) (bool, error) {
+	_, err := os.Stat(getPowerShellProfilePath(homedir))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	powerShellProfile, err := os.ReadFile(getPowerShellProfilePath(homedir))
+	if err != nil {
+		return false, fmt.Errorf("failed to read PowerShell profile: %w", err)
+	}
+	return strings.Contains(string(powerShellProfile), getPowerShellConfigFragment(homedir)), nil
+}
+
 func getZshConfigPath(homedir string) string {
 	return path.Join(homedir, data.GetHishtoryPath(), "config.zsh")
 }
@@ -576,6 +719,14 @@ func uninstall(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	err = stripLines(getNushellRcPath(homedir), getNushellConfigFragment(homedir))
+	if err != nil {
+		return err
+	}
+	err = stripLines(getPowerShellProfilePath(homedir), getPowerShellConfigFragment(homedir))
+	if err != nil {
+		return err
+	}
 	err = os.RemoveAll(path.Join(homedir, data.GetHishtoryPath()))
 	if err != nil {
 		return err
@@ -629,6 +780,7 @@ func setup(userSecret string, isOffline bool) error {
 	}
 	config.EnablePresaving = true
 	config.FilterWhitespacePrefix = true
+	config.SecretPolicy = "mask"
 	err := hctx.SetConfig(&config)
 	if err != nil {
 		return fmt.Errorf("failed to persist config to disk: %w", err)
@@ -662,6 +814,9 @@ func registerAndBootstrapDevice(ctx context.Context, config *hctx.ClientConfig,
 	}
 
 	// Bootstrap: retrieve all entries
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		ctx = backend.WithProgress(ctx, newBootstrapProgressPrinter(os.Stderr))
+	}
 	retrievedEntries, err := b.Bootstrap(ctx, userId, config.DeviceId)
 	if err != nil {
 		return fmt.Errorf("failed to bootstrap device from the backend: %w", err)
@@ -669,14 +824,35 @@ func registerAndBootstrapDevice(ctx context.Context, config *hctx.ClientConfig,
 
 	hctx.GetLogger().Infof("Bootstrapping new device: Found %d entries", len(retrievedEntries))
 	for _, entry := range retrievedEntries {
-		decEntry, err := data.DecryptHistoryEntry(userSecret, *entry)
+		err := lib.AddEncryptedEntryToDbIfNew(db, userSecret, *entry)
+		if errors.Is(err, lib.ErrUndecryptableEntry) {
+			hctx.GetLogger().Warnf("Bootstrapping new device: skipping entry: %v", err)
+			continue
+		}
 		if err != nil {
-			return fmt.Errorf("failed to decrypt history entry from server: %w", err)
+			return err
 		}
-		lib.AddToDbIfNew(db, decEntry)
 	}
 
-	return nil
+	return lib.SaveServerRequestEncoding(ctx)
+}
+
+// The minimum number of objects for which bootstrap progress is shown, so that it doesn't flash up for small histories
+const minObjectsForBootstrapProgress = 1000
+
+// newBootstrapProgressPrinter returns a backend.ProgressFunc that prints progress to w at most every 100ms
+func newBootstrapProgressPrinter(w io.Writer) backend.ProgressFunc {
+	var lastPrint time.Time
+	return func(done, total int) {
+		if total < minObjectsForBootstrapProgress || (done < total && time.Since(lastPrint) < 100*time.Millisecond) {
+			return
+		}
+		lastPrint = time.Now()
+		fmt.Fprintf(w, "\rDownloading history: %d/%d", done, total)
+		if done == total {
+			fmt.Fprintln(w)
+		}
+	}
 }
 
 func init() {
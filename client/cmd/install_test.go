@@ -3,10 +3,13 @@ package cmd
 import (
 	"os"
 	"path"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/ddworken/hishtory/client/data"
 	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
 	"github.com/ddworken/hishtory/shared/testutils"
 
 	"github.com/stretchr/testify/require"
@@ -59,3 +62,46 @@ func TestSetupOffline(t *testing.T) {
 		t.Fatalf("hishtory config should have been offline, actual=%#v", string(data))
 	}
 }
+
+func TestTweakConfigForTests(t *testing.T) {
+	for _, configContents := range []string{lib.ConfigShContents, lib.ConfigZshContents, lib.ConfigFishContents, lib.ConfigNuContents, lib.ConfigPs1Contents} {
+		tweaked, err := tweakConfigForTests(configContents)
+		require.NoError(t, err)
+		require.NotContains(t, tweaked, "# Background Run")
+		// The Foreground Run lines are uncommented so that tests record entries synchronously
+		tweakedLines := strings.Split(tweaked, "\n")
+		for _, line := range strings.Split(configContents, "\n") {
+			if !strings.Contains(line, "# Foreground Run") {
+				continue
+			}
+			uncommented := strings.TrimPrefix(strings.TrimSpace(line), "# ")
+			require.True(t, slices.ContainsFunc(tweakedLines, func(l string) bool { return strings.TrimSpace(l) == uncommented }), "missing uncommented line %#v", uncommented)
+		}
+	}
+}
+
+func TestNushellConfigFragment(t *testing.T) {
+	homedir := "/home/example"
+	fragment := getNushellConfigFragment(homedir)
+	require.Contains(t, fragment, `append "/home/example/.hishtory"`)
+	require.Contains(t, fragment, `source "/home/example/.hishtory/config.nu"`)
+}
+
+func TestPowerShellConfigFragment(t *testing.T) {
+	homedir := "/home/example"
+	fragment := getPowerShellConfigFragment(homedir)
+	require.Contains(t, fragment, `$env:PATH = "$env:PATH:/home/example/.hishtory"`)
+	require.Contains(t, fragment, `. "/home/example/.hishtory/config.ps1"`)
+}
+
+func TestBootstrapProgressPrinter(t *testing.T) {
+	var out strings.Builder
+	printProgress := newBootstrapProgressPrinter(&out)
+	printProgress(1, 10)
+	require.Empty(t, out.String())
+
+	printProgress(1, 2000)
+	printProgress(2, 2000)
+	printProgress(2000, 2000)
+	require.Equal(t, "\rDownloading history: 1/2000\rDownloading history: 2000/2000\n", out.String())
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"os"
@@ -14,6 +15,7 @@ import (
 	"github.com/ddworken/hishtory/client/tui"
 
 	"github.com/fatih/color"
+	"github.com/mattn/go-runewidth"
 	"github.com/muesli/termenv"
 	"github.com/rodaine/table"
 	"github.com/spf13/cobra"
@@ -109,11 +111,26 @@ var updateLocalDbFromRemoteCmd = &cobra.Command{
 		// Periodically, run a query so as to ensure that the local DB stays mostly up to date and that we don't
 		// accumulate a large number of entries that this device doesn't know about. This ensures that queries
 		// are always reasonably complete and fast (even when offline).
+		if homedir, err := os.UserHomeDir(); err == nil {
+			// If the daemon is running, it owns syncing, so just let it know that now is a good time to sync
+			err := lib.SendDaemonRequest(homedir, lib.DaemonRequest{Type: lib.DaemonRequestSync})
+			if err == nil {
+				return
+			}
+			if !errors.Is(err, lib.ErrDaemonNotRunning) {
+				hctx.GetLogger().Warnf("updateLocalDbFromRemote: Failed to send sync request to the daemon: %v", err)
+			}
+		}
 		ctx := hctx.MakeContext()
 		config := hctx.GetConf(ctx)
 		if config.IsOffline {
 			return
 		}
+		// Backend switches requested by other devices can take a while, so they are applied here in the background
+		ctx, err := lib.ApplyPendingBackendSwitch(ctx)
+		if err != nil {
+			hctx.GetLogger().Warnf("updateLocalDbFromRemote: %v", err)
+		}
 		// Do it a random percent of the time, which should be approximately often enough.
 		if rand.Intn(20) == 0 && !config.IsOffline {
 			err := lib.RetrieveAdditionalEntriesFromRemote(ctx, "preload")
@@ -171,16 +188,17 @@ func DisplayResults(ctx context.Context, results []*data.HistoryEntry, numResult
 	headerFmt := color.New(color.FgGreen, color.Underline).SprintfFunc()
 
 	columns := make([]any, 0)
+	columnSettings := make([]hctx.ColumnSettings, 0)
 	for _, c := range config.DisplayedColumns {
-		columns = append(columns, c)
+		columns = append(columns, lib.GetColumnHeader(ctx, c))
+		columnSettings = append(columnSettings, lib.GetColumnSettings(ctx, c))
 	}
 	tbl := table.New(columns...)
 	tbl.WithHeaderFormatter(headerFmt)
 
-	numRows := 0
-
 	seenCommands := make(map[string]bool)
 
+	rows := make([][]string, 0)
 	for _, entry := range results {
 		if config.FilterDuplicateCommands && entry != nil {
 			cmd := strings.TrimSpace(entry.Command)
@@ -194,13 +212,32 @@ func DisplayResults(ctx context.Context, results []*data.HistoryEntry, numResult
 		if err != nil {
 			return err
 		}
-		tbl.AddRow(stringArrayToAnyArray(row)...)
-		numRows += 1
-		if numRows >= numResults {
+		for i := range row {
+			row[i] = lib.TruncateColumnValue(row[i], columnSettings[i].MaxWidth, columnSettings[i].Truncation)
+		}
+		rows = append(rows, row)
+		if len(rows) >= numResults {
 			break
 		}
 	}
 
+	// The table library only supports left-aligned columns, so pad any other columns ourselves
+	for i, settings := range columnSettings {
+		if settings.Alignment == "" || settings.Alignment == "left" {
+			continue
+		}
+		width := runewidth.StringWidth(columns[i].(string))
+		for _, row := range rows {
+			width = max(width, runewidth.StringWidth(row[i]))
+		}
+		for _, row := range rows {
+			row[i] = lib.AlignColumnValue(row[i], width, settings.Alignment)
+		}
+	}
+
+	for _, row := range rows {
+		tbl.AddRow(stringArrayToAnyArray(row)...)
+	}
 	tbl.Print()
 	return nil
 }
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+
+	"github.com/spf13/cobra"
+)
+
+var printRunAliases *bool
+
+var runCmd = &cobra.Command{
+	Use:   "run -- <command> [args...]",
+	Short: "Run a command and record its output, resource usage and timing",
+	Long: "Run a command and record the tail of its output along with its CPU time, max RSS and wall time. These are stored with the history entry for the current command line, " +
+		"and can be viewed in the TUI or searched via the `output:` atom.\n\n" +
+		"To always record the output of specific commands, add `eval \"$(hishtory run --alias make terraform)\"` to your shell's config file.\n\n" +
+		"Since the output is captured, the command's stdout and stderr aren't a terminal. So some commands disable colors or progress bars, " +
+		"and interactive commands (e.g. editors, pagers, or anything using a full-screen UI) may not work correctly and shouldn't be aliased.",
+	GroupID: GROUP_ID_MANAGEMENT,
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if *printRunAliases {
+			fmt.Print(buildRunAliases(args))
+			return
+		}
+		config, err := hctx.GetConfig()
+		lib.CheckFatalError(err)
+		sessionId := os.Getenv("HISHTORY_SESSION_ID")
+		if sessionId == "" {
+			fmt.Fprintln(os.Stderr, "hishtory: HISHTORY_SESSION_ID isn't set, so the output of this command won't be recorded (is the hishtory shell integration installed?)")
+		}
+		result, err := lib.RunCommand(args, os.Stdout, os.Stderr, config.RunOutputMaxBytes)
+		if err != nil {
+			// Mirror how shells report commands that can't be executed
+			fmt.Fprintf(os.Stderr, "hishtory: %v\n", err)
+			result = lib.RunResult{StartTime: time.Now().UTC(), ExitCode: 127, Output: err.Error()}
+		}
+		if sessionId != "" {
+			homedir, err := os.UserHomeDir()
+			if err == nil {
+				err = lib.SaveRunResult(homedir, sessionId, result)
+			}
+			if err != nil {
+				// Don't change the exit code of the command just because it couldn't be recorded
+				fmt.Fprintf(os.Stderr, "hishtory: failed to record the output of this command: %v\n", err)
+			}
+		}
+		os.Exit(result.ExitCode)
+	},
+}
+
+// Builds shell aliases that wrap each of the given commands with `hishtory run`. This syntax works for bash,
+// zsh and fish.
+func buildRunAliases(commands []string) string {
+	var sb strings.Builder
+	for _, c := range commands {
+		sb.WriteString(fmt.Sprintf("alias %s=%s\n", c, lib.ShellQuoteArgs([]string{"hishtory run -- " + c})))
+	}
+	return sb.String()
+}
+
+// Attaches the output and resource usage of any commands that were run via `hishtory run` from this command line
+func addRunResults(ctx context.Context, entry *data.HistoryEntry, results []lib.RunResult) error {
+	// Allow for some slop since shells record the start time with varying precision
+	merged := lib.MergeRunResults(results, entry.StartTime.Add(-time.Second), hctx.GetConf(ctx).RunOutputMaxBytes)
+	if merged == nil {
+		return nil
+	}
+	output, err := lib.ApplySecretPolicyToOutput(ctx, merged.Output)
+	if err != nil {
+		return err
+	}
+	entry.Output = output
+	entry.WallTime = merged.WallTime
+	entry.CpuTime = merged.CpuTime
+	entry.MaxRssKb = merged.MaxRssKb
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+	// Treat everything after the command name as arguments for the command, even if `--` is omitted
+	runCmd.Flags().SetInterspersed(false)
+	printRunAliases = runCmd.Flags().Bool("alias", false, "Print shell aliases that wrap the given commands with hishtory run (don't use this for interactive commands, since their output won't be a terminal)")
+}
@@ -1,11 +1,10 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"os/user"
 	"reflect"
 	"regexp"
@@ -33,16 +32,28 @@ var getTimestampCmd = &cobra.Command{
 	},
 }
 
+var newSessionIdCmd = &cobra.Command{
+	Use:    "newSessionId",
+	Hidden: true,
+	Short:  "[Internal-only] Returns a new random ID for a shell session",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Println(uuid.Must(uuid.NewRandom()).String())
+	},
+}
+
 var saveHistoryEntryCmd = &cobra.Command{
 	Use:                "saveHistoryEntry",
 	Hidden:             true,
 	Short:              "[Internal-only] The command used to save history entries",
 	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx := hctx.MakeContext()
-		lib.CheckFatalError(maybeUploadSkippedHistoryEntries(ctx))
-		lib.CheckFatalError(maybeSubmitPendingDeletionRequests(ctx))
-		saveHistoryEntry(ctx)
+		handOffToDaemon(lib.DaemonRequestSave, buildHistoryEntryToSave, func(ctx context.Context, entry *data.HistoryEntry) {
+			// The daemon isn't running, so also upload skipped entries and pending deletion requests, which
+			// the daemon would otherwise handle
+			lib.CheckFatalError(maybeUploadSkippedHistoryEntries(ctx))
+			lib.CheckFatalError(maybeSubmitPendingDeletionRequests(ctx))
+			persistHistoryEntry(ctx, entry)
+		})
 	},
 }
 
@@ -52,11 +63,42 @@ var presaveHistoryEntryCmd = &cobra.Command{
 	Short:              "[Internal-only] The command used to pre-save history entries that haven't yet finished running",
 	DisableFlagParsing: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		ctx := hctx.MakeContext()
-		presaveHistoryEntry(ctx)
+		handOffToDaemon(lib.DaemonRequestPresave, buildPresavedHistoryEntry, persistPresavedHistoryEntry)
 	},
 }
 
+type cmdContextKey string
+
+// Set when custom columns will be evaluated by the daemon rather than while building the history entry
+const deferCustomColumnsCtxKey cmdContextKey = "deferCustomColumns"
+
+// handOffToDaemon builds a history entry without opening the local DB, and sends it to the daemon to be
+// persisted. If the daemon isn't running or can't be reached, the entry is instead persisted directly. Note
+// that this doesn't check whether the daemon is running beforehand, so that the socket is only connected to once.
+func handOffToDaemon(reqType string, build func(context.Context) *data.HistoryEntry, persist func(context.Context, *data.HistoryEntry)) {
+	ctx := context.WithValue(hctx.MakeContextWithoutDb(), deferCustomColumnsCtxKey, true)
+	entry := build(ctx)
+	if entry == nil {
+		return
+	}
+	cwd, err := getCwdWithoutSubstitution()
+	lib.CheckFatalError(err)
+	err = lib.SendDaemonRequest(hctx.GetHome(ctx), lib.DaemonRequest{Type: reqType, Entry: entry, Cwd: cwd, Env: os.Environ()})
+	if err == nil {
+		return
+	}
+	// Note that the entry can't just be rebuilt since building it has side effects (e.g. for bash, the
+	// last saved history line is recorded to de-duplicate commands)
+	if !errors.Is(err, lib.ErrDaemonNotRunning) {
+		hctx.GetLogger().Warnf("failed to hand off history entry to the daemon, persisting it directly: %v", err)
+	}
+	ctx = hctx.MakeContext()
+	cc, err := lib.BuildCustomColumns(ctx, entry.CurrentWorkingDirectory)
+	lib.CheckFatalError(err)
+	entry.CustomColumns = cc
+	persist(ctx, entry)
+}
+
 func maybeSubmitPendingDeletionRequests(ctx context.Context) error {
 	config := hctx.GetConf(ctx)
 	if config.IsOffline {
@@ -85,10 +127,16 @@ func maybeSubmitPendingDeletionRequests(ctx context.Context) error {
 
 func maybeUploadSkippedHistoryEntries(ctx context.Context) error {
 	config := hctx.GetConf(ctx)
-	if !config.HaveMissedUploads {
+	if config.IsOffline {
 		return nil
 	}
-	if config.IsOffline {
+	if err := lib.UploadMissedMirrorEntries(ctx); err != nil {
+		return err
+	}
+	if err := lib.UploadPendingQueryHistory(ctx); err != nil {
+		return err
+	}
+	if !config.HaveMissedUploads {
 		return nil
 	}
 
@@ -106,16 +154,21 @@ func maybeUploadSkippedHistoryEntries(ctx context.Context) error {
 		return err
 	}
 
+	// Cleared before uploading since with mirror backends, entries that still fail to upload to the primary
+	// backend are recorded again while uploading
+	missedUploadTimestamp := config.MissedUploadTimestamp
+	config.HaveMissedUploads = false
+	config.MissedUploadTimestamp = 0
 	b, ctx := lib.GetSyncBackend(ctx)
 	_, err = b.SubmitEntries(ctx, encEntries, config.DeviceId)
 	if err != nil {
 		// Failed to upload the history entry, so we must still be offline. So just return nil and we'll try again later.
+		config.HaveMissedUploads = true
+		config.MissedUploadTimestamp = missedUploadTimestamp
 		return nil
 	}
 
 	// Mark down that we persisted it
-	config.HaveMissedUploads = false
-	config.MissedUploadTimestamp = 0
 	err = hctx.SetConfig(config)
 	if err != nil {
 		return fmt.Errorf("failed to mark a history entry as uploaded: %w", err)
@@ -127,33 +180,43 @@ func handlePotentialUploadFailure(ctx context.Context, err error, config *hctx.C
 	if err != nil {
 		if lib.IsOfflineError(ctx, err) {
 			hctx.GetLogger().Warnf("Failed to remotely persist hishtory entry because we failed to connect to the remote server! This is likely because the device is offline, but also could be because the remote server is having reliability issues. Original error: %v", err)
-			if !config.HaveMissedUploads {
-				config.HaveMissedUploads = true
-				// Set MissedUploadTimestamp to `entry timestamp - 1` so that the current entry will get
-				// uploaded once network access is regained.
-				config.MissedUploadTimestamp = entryTimestamp.UTC().Unix() - 1
-				lib.CheckFatalError(hctx.SetConfig(config))
-			}
+			lib.CheckFatalError(recordMissedUpload(config, entryTimestamp))
 		} else {
 			lib.CheckFatalError(err)
 		}
 	}
 }
 
-func presaveHistoryEntry(ctx context.Context) {
+// Marks down that entries starting at entryTimestamp failed to upload, so that they're uploaded later by
+// maybeUploadSkippedHistoryEntries
+func recordMissedUpload(config *hctx.ClientConfig, entryTimestamp time.Time) error {
+	if config.HaveMissedUploads {
+		return nil
+	}
+	config.HaveMissedUploads = true
+	// Set MissedUploadTimestamp to `entry timestamp - 1` so that the current entry will get
+	// uploaded once network access is regained.
+	config.MissedUploadTimestamp = entryTimestamp.UTC().Unix() - 1
+	return hctx.SetConfig(config)
+}
+
+func buildPresavedHistoryEntry(ctx context.Context) *data.HistoryEntry {
 	config := hctx.GetConf(ctx)
 	if !config.IsEnabled {
-		return
+		return nil
 	}
 	if !config.EnablePresaving {
-		return
+		return nil
+	}
+	if lib.IsIncognito() {
+		return nil
 	}
 
 	// Build the basic entry with metadata retrieved from runtime
 	entry, err := buildPreArgsHistoryEntry(ctx)
 	lib.CheckFatalError(err)
 	if entry == nil {
-		return
+		return nil
 	}
 
 	// Augment it with os.Args
@@ -162,23 +225,44 @@ func presaveHistoryEntry(ctx context.Context) {
 	lib.CheckFatalError(err)
 	entry.Command = cmd
 	if strings.TrimSpace(entry.Command) == "" {
-		return
+		return nil
 	}
 	if config.FilterWhitespacePrefix && len(entry.Command) > 0 && (entry.Command[0] == ' ' || entry.Command[0] == '\t') {
-		return
+		return nil
 	}
 
 	// Skip recording redact commands to avoid saving sensitive search terms
 	if isRedactCommand(entry.Command) {
-		return
+		return nil
+	}
+
+	// Skip recording commands that match the user's ignore rules
+	shouldIgnore, err := shouldIgnoreCommand(ctx, entry.Command)
+	lib.CheckFatalError(err)
+	if shouldIgnore {
+		return nil
 	}
 
+	// Check for secrets before the command is persisted anywhere
+	cmd, shouldSkip, err := lib.ApplySecretPolicy(ctx, entry.Command)
+	lib.CheckFatalError(err)
+	if shouldSkip {
+		return nil
+	}
+	entry.Command = cmd
+
 	entry.StartTime = parseCrossPlatformTime(os.Args[4])
 	entry.EndTime = time.Unix(0, 0).UTC()
+	lib.CheckFatalError(addCustomColumns(ctx, entry))
+	return entry
+}
+
+func persistPresavedHistoryEntry(ctx context.Context, entry *data.HistoryEntry) {
+	config := hctx.GetConf(ctx)
 
-	// And persist it locally.
+	// Persist it locally.
 	db := hctx.GetDb(ctx)
-	err = lib.ReliableDbCreate(db, *entry)
+	err := lib.ReliableDbCreate(db, *entry)
 	lib.CheckFatalError(err)
 	db.Commit()
 
@@ -192,18 +276,32 @@ func presaveHistoryEntry(ctx context.Context) {
 	}
 }
 
-func saveHistoryEntry(ctx context.Context) {
+func buildHistoryEntryToSave(ctx context.Context) *data.HistoryEntry {
+	// Always consume the results of `hishtory run` so that they can't be attached to a later command
+	runResults, err := lib.ConsumeRunResults(hctx.GetHome(ctx), os.Getenv("HISHTORY_SESSION_ID"))
+	lib.CheckFatalError(err)
+
 	config := hctx.GetConf(ctx)
 	if !config.IsEnabled {
 		hctx.GetLogger().Infof("Skipping saving a history entry because hishtory is disabled\n")
-		return
+		return nil
+	}
+	if lib.IsIncognito() {
+		hctx.GetLogger().Infof("Skipping saving a history entry because this shell session is in incognito mode\n")
+		return nil
 	}
 	entry, err := buildHistoryEntry(ctx, os.Args)
 	lib.CheckFatalError(err)
 	if entry == nil {
 		hctx.GetLogger().Infof("Skipping saving a history entry because we did not build a history entry (was the command prefixed with a space and/or empty?)\n")
-		return
+		return nil
 	}
+	lib.CheckFatalError(addRunResults(ctx, entry, runResults))
+	return entry
+}
+
+func persistHistoryEntry(ctx context.Context, entry *data.HistoryEntry) {
+	config := hctx.GetConf(ctx)
 	db := hctx.GetDb(ctx)
 
 	// Drop any entries from pre-saving since they're no longer needed
@@ -212,7 +310,7 @@ func saveHistoryEntry(ctx context.Context) {
 	}
 
 	// Persist it locally
-	err = lib.ReliableDbCreate(db, *entry)
+	err := lib.ReliableDbCreate(db, *entry)
 	lib.CheckFatalError(err)
 
 	// Persist it remotely
@@ -233,6 +331,8 @@ func saveHistoryEntry(ctx context.Context) {
 	}
 }
 
+const deletePresavedEntriesMaxRetries = 3
+
 func deletePresavedEntries(ctx context.Context, entry *data.HistoryEntry, retryCount int) error {
 	db := hctx.GetDb(ctx)
 
@@ -268,10 +368,9 @@ func deletePresavedEntries(ctx context.Context, entry *data.HistoryEntry, retryC
 		// this function after a short delay. If it still is empty, then we assume we are in case #1.
 		// We retry up to 3 times with increasing delays since presaving can take 1+ seconds when uploading
 		// to remote backends (especially S3).
-		const maxRetries = 3
-		if retryCount >= maxRetries {
+		if retryCount >= deletePresavedEntriesMaxRetries {
 			// Already retried max times, assume we're in case #1
-			hctx.GetLogger().Warnf("failed to find presaved entry matching cmd=%#v after %d retries, skipping delete", entry.Command, maxRetries)
+			hctx.GetLogger().Warnf("failed to find presaved entry matching cmd=%#v after %d retries, skipping delete", entry.Command, deletePresavedEntriesMaxRetries)
 			return nil
 		}
 		// Use exponential backoff: 500ms, 1000ms, 2000ms
@@ -318,19 +417,28 @@ func handleDumpRequests(ctx context.Context, dumpRequests []*shared.DumpRequest)
 	db := hctx.GetDb(ctx)
 	config := hctx.GetConf(ctx)
 	if len(dumpRequests) > 0 {
-		lib.CheckFatalError(lib.RetrieveAdditionalEntriesFromRemote(ctx, "newclient"))
+		err := lib.RetrieveAdditionalEntriesFromRemote(ctx, "newclient")
+		if err != nil {
+			return err
+		}
 		entries, err := lib.Search(ctx, db, "", 0)
-		lib.CheckFatalError(err)
+		if err != nil {
+			return err
+		}
 
 		encEntries, err := lib.EncryptEntries(config, entries)
-		lib.CheckFatalError(err)
+		if err != nil {
+			return err
+		}
 
 		b, ctx := lib.GetSyncBackend(ctx)
 		for _, dumpRequest := range dumpRequests {
 			if !config.IsOffline {
 				// TODO: Test whether this fails if the data is extremely large? It may need to be chunked
 				err := b.SubmitDump(ctx, encEntries, dumpRequest.UserId, dumpRequest.RequestingDeviceId, config.DeviceId)
-				lib.CheckFatalError(err)
+				if err != nil {
+					return fmt.Errorf("failed to submit dump for device %s: %w", dumpRequest.RequestingDeviceId, err)
+				}
 			}
 		}
 	}
@@ -369,16 +477,72 @@ func buildPreArgsHistoryEntry(ctx context.Context) (*data.HistoryEntry, error) {
 	// entry ID
 	entry.EntryId = uuid.Must(uuid.NewRandom()).String()
 
-	// custom columns
-	cc, err := buildCustomColumns(ctx)
+	// session
+	entry.SessionId, entry.ShellPid, entry.Tty = getSessionInfo()
+
+	// git context
+	err = addGitContext(&entry, homedir)
 	if err != nil {
 		return nil, err
 	}
-	entry.CustomColumns = cc
+
+	// remote origin
+	origin, err := lib.GetRemoteOrigin(homedir)
+	if err != nil {
+		// A malformed kubeconfig shouldn't prevent the command from being recorded
+		hctx.GetLogger().Infof("failed to read the remote origin: %v", err)
+	}
+	entry.SshClient = origin.SshClient
+	entry.Container = origin.Container
+	entry.KubeContext = origin.KubeContext
+	entry.KubeNamespace = origin.KubeNamespace
 
 	return &entry, nil
 }
 
+func addGitContext(entry *data.HistoryEntry, homedir string) error {
+	cwd, err := getCwdWithoutSubstitution()
+	if err != nil {
+		return fmt.Errorf("failed to get cwd for git context: %w", err)
+	}
+	gitContext, err := lib.GetGitContext(cwd)
+	if err != nil {
+		// A malformed .git directory shouldn't prevent the command from being recorded
+		hctx.GetLogger().Infof("failed to read git context for %#v: %v", cwd, err)
+		return nil
+	}
+	if gitContext == nil {
+		return nil
+	}
+	entry.GitRepo = abbreviateHomeDir(gitContext.RepoRoot, homedir)
+	entry.GitBranch = gitContext.Branch
+	entry.GitCommit = gitContext.Commit
+	return nil
+}
+
+// Returns the session ID, shell PID, and TTY that are exported by the shell integration scripts
+func getSessionInfo() (string, int, string) {
+	sessionId := os.Getenv("HISHTORY_SESSION_ID")
+	shellPid, err := strconv.Atoi(os.Getenv("HISHTORY_SHELL_PID"))
+	if err != nil {
+		shellPid = 0
+	}
+	tty := strings.TrimSpace(os.Getenv("HISHTORY_TTY"))
+	if !strings.HasPrefix(tty, "/") {
+		// `tty` prints "not a tty" when stdin isn't a terminal
+		tty = ""
+	}
+	return sessionId, shellPid, tty
+}
+
+func shouldIgnoreCommand(ctx context.Context, command string) (bool, error) {
+	cwd, err := getCwdWithoutSubstitution()
+	if err != nil {
+		return false, fmt.Errorf("failed to get cwd for checking ignore rules: %w", err)
+	}
+	return lib.ShouldIgnoreCommand(ctx, command, cwd)
+}
+
 func isRedactCommand(command string) bool {
 	// Check if the command contains the pattern "hishtory" followed by whitespace followed by "redact" or "delete"
 	matched, _ := regexp.MatchString(`hishtory\s+(redact|delete)`, command)
@@ -426,9 +590,45 @@ func buildHistoryEntry(ctx context.Context, args []string) (*data.HistoryEntry,
 		return nil, nil
 	}
 
+	// Skip recording commands that match the user's ignore rules
+	shouldIgnore, err := shouldIgnoreCommand(ctx, entry.Command)
+	if err != nil {
+		return nil, err
+	}
+	if shouldIgnore {
+		return nil, nil
+	}
+
+	// Check for secrets before the command is persisted anywhere
+	cmd, shouldSkip, err := lib.ApplySecretPolicy(ctx, entry.Command)
+	if err != nil {
+		return nil, err
+	}
+	if shouldSkip {
+		return nil, nil
+	}
+	entry.Command = cmd
+
+	if err := addCustomColumns(ctx, entry); err != nil {
+		return nil, err
+	}
 	return entry, nil
 }
 
+// addCustomColumns evaluates the custom columns for the entry, unless they'll be evaluated by the daemon. Since
+// custom columns run arbitrary commands, this is only done once it is known that the entry won't be skipped.
+func addCustomColumns(ctx context.Context, entry *data.HistoryEntry) error {
+	if ctx.Value(deferCustomColumnsCtxKey) != nil {
+		return nil
+	}
+	cc, err := lib.BuildCustomColumns(ctx, entry.CurrentWorkingDirectory)
+	if err != nil {
+		return err
+	}
+	entry.CustomColumns = cc
+	return nil
+}
+
 func extractCommandFromArg(ctx context.Context, shell, arg string, isPresave bool) (string, error) {
 	config := hctx.GetConf(ctx)
 	if shell == "bash" {
@@ -454,7 +654,7 @@ func extractCommandFromArg(ctx context.Context, shell, arg string, isPresave boo
 			return "", nil
 		}
 		return cmd, nil
-	} else if shell == "zsh" || shell == "fish" {
+	} else if shell == "zsh" || shell == "fish" || shell == "nu" || shell == "pwsh" {
 		cmd := trimTrailingWhitespace(arg)
 		if config.FilterWhitespacePrefix && len(cmd) > 0 && (cmd[0] == ' ' || cmd[0] == '\t') {
 			return "", nil
@@ -469,33 +669,6 @@ func trimTrailingWhitespace(s string) string {
 	return strings.TrimSuffix(strings.TrimSuffix(s, "\n"), " ")
 }
 
-func buildCustomColumns(ctx context.Context) (data.CustomColumns, error) {
-	ccs := data.CustomColumns{}
-	config := hctx.GetConf(ctx)
-	for _, cc := range config.CustomColumns {
-		cmd := exec.Command("bash", "-c", cc.ColumnCommand)
-		var stdout bytes.Buffer
-		cmd.Stdout = &stdout
-		var stderr bytes.Buffer
-		cmd.Stderr = &stderr
-		err := cmd.Start()
-		if err != nil {
-			return nil, fmt.Errorf("failed to execute custom command named %v (stdout=%#v, stderr=%#v)", cc.ColumnName, stdout.String(), stderr.String())
-		}
-		err = cmd.Wait()
-		if err != nil {
-			// Log a warning, but don't crash. This way commands can exit with a different status and still work.
-			hctx.GetLogger().Warnf("failed to execute custom command named %v (stdout=%#v, stderr=%#v)", cc.ColumnName, stdout.String(), stderr.String())
-		}
-		ccv := data.CustomColumn{
-			Name: cc.ColumnName,
-			Val:  strings.TrimSpace(stdout.String()),
-		}
-		ccs = append(ccs, ccv)
-	}
-	return ccs, nil
-}
-
 func buildRegexFromTimeFormat(timeFormat string) string {
 	expectedRegex := ""
 	lastCharWasPercent := false
@@ -646,13 +819,17 @@ func getCwd(ctx context.Context) (string, string, error) {
 		return "", "", fmt.Errorf("failed to get cwd for last command: %w", err)
 	}
 	homedir := hctx.GetHome(ctx)
-	if cwd == homedir {
-		return "~/", homedir, nil
+	return abbreviateHomeDir(cwd, homedir), homedir, nil
+}
+
+func abbreviateHomeDir(path, homedir string) string {
+	if path == homedir {
+		return "~/"
 	}
-	if strings.HasPrefix(cwd, homedir) {
-		return strings.Replace(cwd, homedir, "~", 1), homedir, nil
+	if strings.HasPrefix(path, homedir) {
+		return strings.Replace(path, homedir, "~", 1)
 	}
-	return cwd, homedir, nil
+	return path
 }
 
 func getCwdWithoutSubstitution() (string, error) {
@@ -679,4 +856,5 @@ func init() {
 	rootCmd.AddCommand(saveHistoryEntryCmd)
 	rootCmd.AddCommand(presaveHistoryEntryCmd)
 	rootCmd.AddCommand(getTimestampCmd)
+	rootCmd.AddCommand(newSessionIdCmd)
 }
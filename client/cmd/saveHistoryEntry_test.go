@@ -3,11 +3,13 @@ package cmd
 import (
 	"os"
 	"os/user"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
 	"github.com/ddworken/hishtory/shared/testutils"
 
 	"github.com/stretchr/testify/require"
@@ -97,6 +99,29 @@ func TestBuildHistoryEntry(t *testing.T) {
 		t.Fatalf("history entry has incorrect Unix time in the start time: %v", entry.StartTime.Unix())
 	}
 
+	// Test building an entry for nushell
+	entry, err = buildHistoryEntry(hctx.MakeContext(), []string{"unused", "saveHistoryEntry", "nu", "120", "ls /foo", "1641774958"})
+	require.NoError(t, err)
+	if entry.ExitCode != 120 {
+		t.Fatalf("history entry has unexpected exit code: %v", entry.ExitCode)
+	}
+	if entry.Command != "ls /foo" {
+		t.Fatalf("history entry has unexpected command: %v", entry.Command)
+	}
+	if entry.StartTime.Unix() != 1641774958 {
+		t.Fatalf("history entry has incorrect Unix time in the start time: %v", entry.StartTime.Unix())
+	}
+
+	// Test building an entry for PowerShell
+	entry, err = buildHistoryEntry(hctx.MakeContext(), []string{"unused", "saveHistoryEntry", "pwsh", "1", "Get-ChildItem /foo", "1641774958"})
+	require.NoError(t, err)
+	if entry.ExitCode != 1 {
+		t.Fatalf("history entry has unexpected exit code: %v", entry.ExitCode)
+	}
+	if entry.Command != "Get-ChildItem /foo" {
+		t.Fatalf("history entry has unexpected command: %v", entry.Command)
+	}
+
 	// Test building an entry that is empty, and thus not saved
 	entry, err = buildHistoryEntry(hctx.MakeContext(), []string{"unused", "saveHistoryEntry", "zsh", "120", " \n", "1641774958"})
 	require.NoError(t, err)
@@ -307,3 +332,76 @@ func TestBuildHistoryEntrySkipsRedactCommands(t *testing.T) {
 		t.Fatalf("expected command to be 'hishtory query foo', got: %#v", entry.Command)
 	}
 }
+
+func TestBuildHistoryEntrySkipsCustomColumnsForIgnoredCommands(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	defer testutils.RunTestServer()()
+	require.NoError(t, setup("", false))
+
+	// Custom columns run arbitrary commands, so they shouldn't be run for commands that are never recorded
+	marker := filepath.Join(t.TempDir(), "marker")
+	conf := hctx.GetConf(hctx.MakeContext())
+	conf.IgnoredCommands = []string{"^vault "}
+	conf.CustomColumns = []hctx.CustomColumnDefinition{{ColumnName: "marker", ColumnCommand: "touch " + marker}}
+	require.NoError(t, hctx.SetConfig(conf))
+	entry, err := buildHistoryEntry(hctx.MakeContext(), []string{"unused", "saveHistoryEntry", "zsh", "0", "vault read secret/foo", "1641774958"})
+	require.NoError(t, err)
+	require.Nil(t, entry)
+	require.NoFileExists(t, marker)
+
+	// But they are still run for other commands
+	entry, err = buildHistoryEntry(hctx.MakeContext(), []string{"unused", "saveHistoryEntry", "zsh", "0", "ls", "1641774958"})
+	require.NoError(t, err)
+	require.NotNil(t, entry)
+	require.FileExists(t, marker)
+}
+
+func TestGetSessionInfo(t *testing.T) {
+	defer testutils.BackupAndRestoreEnv("HISHTORY_SESSION_ID")()
+	defer testutils.BackupAndRestoreEnv("HISHTORY_SHELL_PID")()
+	defer testutils.BackupAndRestoreEnv("HISHTORY_TTY")()
+
+	os.Setenv("HISHTORY_SESSION_ID", "6a3b0c1e-session")
+	os.Setenv("HISHTORY_SHELL_PID", "4242")
+	os.Setenv("HISHTORY_TTY", "/dev/pts/3")
+	sessionId, shellPid, tty := getSessionInfo()
+	require.Equal(t, "6a3b0c1e-session", sessionId)
+	require.Equal(t, 4242, shellPid)
+	require.Equal(t, "/dev/pts/3", tty)
+
+	// Outside of a terminal, tty prints "not a tty" which shouldn't be recorded
+	os.Setenv("HISHTORY_SESSION_ID", "")
+	os.Setenv("HISHTORY_SHELL_PID", "")
+	os.Setenv("HISHTORY_TTY", "not a tty")
+	sessionId, shellPid, tty = getSessionInfo()
+	require.Equal(t, "", sessionId)
+	require.Equal(t, 0, shellPid)
+	require.Equal(t, "", tty)
+}
+
+func TestAddRunResults(t *testing.T) {
+	defer testutils.BackupAndRestore(t)()
+	require.NoError(t, hctx.InitConfig())
+	ctx := hctx.MakeContext()
+	hctx.GetConf(ctx).SecretPolicy = "mask"
+	start := time.Unix(1700000000, 0).UTC()
+
+	entry := testutils.MakeFakeHistoryEntry("make test")
+	entry.StartTime = start
+	require.NoError(t, addRunResults(ctx, &entry, nil))
+	require.Equal(t, "", entry.Output)
+
+	results := []lib.RunResult{
+		{StartTime: start.Add(-time.Hour), Output: "stale\n", WallTime: time.Second},
+		{StartTime: start.Add(time.Second), Output: "password: --password=hunter2\n", WallTime: 2 * time.Second, CpuTime: time.Second, MaxRssKb: 1024},
+	}
+	require.NoError(t, addRunResults(ctx, &entry, results))
+	require.Equal(t, "password: --password=[REDACTED]\n", entry.Output)
+	require.Equal(t, 2*time.Second, entry.WallTime)
+	require.Equal(t, time.Second, entry.CpuTime)
+	require.Equal(t, int64(1024), entry.MaxRssKb)
+}
+
+func TestBuildRunAliases(t *testing.T) {
+	require.Equal(t, "alias make='hishtory run -- make'\nalias terraform='hishtory run -- terraform'\n", buildRunAliases([]string{"make", "terraform"}))
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
@@ -24,11 +25,20 @@ var statusCmd = &cobra.Command{
 		ctx := hctx.MakeContext()
 		config := hctx.GetConf(ctx)
 		fmt.Printf("hiSHtory: v0.%s\nEnabled: %v\n", lib.Version, config.IsEnabled)
+		if lib.IsIncognito() {
+			fmt.Println("Incognito: true (recording is paused for this shell session)")
+		}
 		fmt.Printf("Secret Key: %s\n", config.UserSecret)
 		if *verbose {
 			fmt.Printf("User ID: %s\n", data.UserId(config.UserSecret))
 			fmt.Printf("Device ID: %s\n", config.DeviceId)
 			printOnlineStatus(config)
+			if !config.IsOffline && len(config.MirrorBackends) > 0 {
+				printBackendStatuses(ctx)
+			}
+			if lib.IsDaemonRunning(hctx.GetHome(ctx)) {
+				fmt.Println("Daemon: Running")
+			}
 		}
 		fmt.Printf("Commit Hash: %s\n", lib.GitCommit)
 		if *configFlag {
@@ -52,6 +62,15 @@ func printOnlineStatus(config *hctx.ClientConfig) {
 			if config.S3Config != nil {
 				fmt.Printf(" (bucket: %s, region: %s)", config.S3Config.Bucket, config.S3Config.Region)
 			}
+			if config.BackendType == "dir" && config.DirConfig != nil {
+				fmt.Printf(" (path: %s)", config.DirConfig.Path)
+			}
+			if config.BackendType == "webdav" && config.WebDAVConfig != nil {
+				fmt.Printf(" (url: %s)", config.WebDAVConfig.URL)
+			}
+			if config.BackendType == "git" && config.GitConfig != nil {
+				fmt.Printf(" (remote: %s)", config.GitConfig.Remote)
+			}
 			fmt.Println()
 		} else if lib.GetServerHostname() != lib.DefaultServerHostname {
 			fmt.Println("Sync Server: " + lib.GetServerHostname())
@@ -65,6 +84,23 @@ func printOnlineStatus(config *hctx.ClientConfig) {
 	}
 }
 
+func printBackendStatuses(ctx context.Context) {
+	fmt.Println("Backend Status:")
+	statuses, err := lib.GetBackendStatuses(ctx)
+	lib.CheckFatalError(err)
+	for _, status := range statuses {
+		role := "mirror"
+		if status.IsPrimary {
+			role = "primary"
+		}
+		if status.Err != nil {
+			fmt.Printf("  %s [%s]: Unreachable (%v)\n", status.Description, role, status.Err)
+		} else {
+			fmt.Printf("  %s [%s]: Reachable\n", status.Description, role)
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(statusCmd)
 	verbose = statusCmd.Flags().BoolP("verbose", "v", false, "Display verbose hiSHtory information")
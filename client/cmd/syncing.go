@@ -3,7 +3,10 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 
+	"github.com/ddworken/hishtory/client/backend"
 	"github.com/ddworken/hishtory/client/data"
 	"github.com/ddworken/hishtory/client/hctx"
 	"github.com/ddworken/hishtory/client/lib"
@@ -14,7 +17,7 @@ import (
 var syncingCmd = &cobra.Command{
 	Use:       "syncing",
 	Short:     "Configure syncing to enable or disable syncing with the hishtory backend",
-	Long:      "Run `hishtory syncing disable` to disable syncing and `hishtory syncing enable` to enable syncing.",
+	Long:      "Run `hishtory syncing disable` to disable syncing and `hishtory syncing enable` to enable syncing. Run `hishtory syncing enable --dir /path/to/shared/dir` to sync via a directory shared between your devices (e.g. a network drive or a Syncthing folder) rather than via the hishtory server, `hishtory syncing enable --webdav https://example.com/dav/hishtory` to sync via a WebDAV server (e.g. Nextcloud), or `hishtory syncing enable --git git@github.com:alice/history.git` to sync via a git repository. Run `hishtory syncing migrate --to s3 --s3-bucket my-bucket --s3-region us-east-1` to move an online device to a different backend, `hishtory syncing mirror add --to dir --dir /mnt/backup` to also mirror your history to a second backend, and `hishtory syncing gc` to compact the history stored in your S3 bucket.",
 	ValidArgs: []string{"disable", "enable"},
 	Args:      cobra.MatchAll(cobra.OnlyValidArgs, cobra.ExactArgs(1)),
 	Run: func(cmd *cobra.Command, args []string) {
@@ -29,6 +32,27 @@ var syncingCmd = &cobra.Command{
 
 		ctx := hctx.MakeContext()
 		conf := hctx.GetConf(ctx)
+		if syncingStatus && (*syncingDirFlag != "" || *syncingWebDAVFlag != "" || *syncingGitFlag != "") {
+			lib.CheckFatalError(checkCanSwitchBackend(conf))
+		}
+		if *syncingDirFlag != "" {
+			if !syncingStatus {
+				lib.CheckFatalError(fmt.Errorf("--dir can only be used with `hishtory syncing enable`"))
+			}
+			lib.CheckFatalError(configureDirBackend(conf, *syncingDirFlag))
+		}
+		if *syncingWebDAVFlag != "" {
+			if !syncingStatus {
+				lib.CheckFatalError(fmt.Errorf("--webdav can only be used with `hishtory syncing enable`"))
+			}
+			lib.CheckFatalError(configureWebDAVBackend(conf, *syncingWebDAVFlag, *syncingWebDAVUsernameFlag))
+		}
+		if *syncingGitFlag != "" {
+			if !syncingStatus {
+				lib.CheckFatalError(fmt.Errorf("--git can only be used with `hishtory syncing enable`"))
+			}
+			lib.CheckFatalError(configureGitBackend(conf, *syncingGitFlag))
+		}
 		if syncingStatus {
 			if conf.IsOffline {
 				lib.CheckFatalError(switchToOnline(ctx))
@@ -65,6 +89,59 @@ func switchToOnline(ctx context.Context) error {
 	return nil
 }
 
+// configureDirBackend configures syncing via the given shared directory
+func configureDirBackend(config *hctx.ClientConfig, dir string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve sync directory: %w", err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to access sync directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("sync directory %s is not a directory", dir)
+	}
+	config.BackendType = string(backend.BackendTypeDir)
+	config.DirConfig = &hctx.DirBackendConfig{Path: dir}
+	return nil
+}
+
+// configureWebDAVBackend configures syncing via the given WebDAV collection
+func configureWebDAVBackend(config *hctx.ClientConfig, url, username string) error {
+	// Validate the config up front so that a missing password is reported before anything is changed
+	webdavCfg := &backend.WebDAVConfig{URL: url, Username: username}
+	if err := webdavCfg.Validate(); err != nil {
+		return err
+	}
+	config.BackendType = string(backend.BackendTypeWebDAV)
+	config.WebDAVConfig = &hctx.WebDAVBackendConfig{URL: url, Username: username}
+	return nil
+}
+
+// configureGitBackend configures syncing via the given git remote
+func configureGitBackend(config *hctx.ClientConfig, remote string) error {
+	// Local repositories are referenced by absolute path, since git resolves relative paths from the local clone
+	if _, err := os.Stat(remote); err == nil {
+		remote, err = filepath.Abs(remote)
+		if err != nil {
+			return fmt.Errorf("failed to resolve git remote: %w", err)
+		}
+	}
+	config.BackendType = string(backend.BackendTypeGit)
+	config.GitConfig = &hctx.GitBackendConfig{Remote: remote}
+	return nil
+}
+
+// The sync backend can only be changed while offline, so that the device is first uninstalled from the
+// current backend
+func checkCanSwitchBackend(config *hctx.ClientConfig) error {
+	if !config.IsOffline {
+		return fmt.Errorf("device is already syncing via the %s backend, run `hishtory syncing migrate` or `hishtory syncing disable` first", lib.BackendTypeOrDefault(config.BackendType))
+	}
+	return nil
+}
+
 func switchToOffline(ctx context.Context) error {
 	config := hctx.GetConf(ctx)
 	config.IsOffline = true
@@ -80,6 +157,198 @@ func switchToOffline(ctx context.Context) error {
 	return nil
 }
 
+var syncingMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move this device to a different sync backend, uploading your full history to it",
+	Long:  "Registers this device with the sync backend given by --to, uploads any history entries that are missing from it, and then switches this device to syncing via it. With --notify-devices, your other devices that sync via the current backend switch to the new backend the next time they sync. They need the same credentials in their environment (e.g. $HISHTORY_S3_SECRET_ACCESS_KEY) as this device.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		conf := hctx.GetConf(ctx)
+		if conf.IsOffline {
+			lib.CheckFatalError(fmt.Errorf("device is offline, run `hishtory syncing enable` instead"))
+		}
+		newConfig := *conf
+		lib.CheckFatalError(configureTargetBackend(&newConfig, targetBackendFlag))
+		lib.CheckFatalError(lib.MigrateBackend(ctx, &newConfig, *migrateNotifyDevicesFlag))
+		fmt.Printf("Migrated to the %s backend successfully\n", lib.BackendTypeOrDefault(newConfig.BackendType))
+	},
+}
+
+// configureTargetBackend configures syncing via the backend given by the --to flag of `syncing migrate` and
+// `syncing mirror add`
+func configureTargetBackend(config *hctx.ClientConfig, backendType string) error {
+	switch backend.BackendType(backendType) {
+	case backend.BackendTypeHTTP:
+		config.BackendType = string(backend.BackendTypeHTTP)
+		return nil
+	case backend.BackendTypeS3:
+		// Validate the config up front so that missing credentials are reported before anything is changed
+		s3Cfg := &backend.S3Config{
+			Bucket:      targetS3BucketFlag,
+			Region:      targetS3RegionFlag,
+			Endpoint:    targetS3EndpointFlag,
+			AccessKeyID: targetS3AccessKeyIdFlag,
+			Prefix:      targetS3PrefixFlag,
+			Compression: targetS3CompressionFlag,
+		}
+		if err := s3Cfg.Validate(); err != nil {
+			return err
+		}
+		config.BackendType = string(backend.BackendTypeS3)
+		config.S3Config = &hctx.S3BackendConfig{
+			Bucket:      s3Cfg.Bucket,
+			Region:      s3Cfg.Region,
+			Endpoint:    s3Cfg.Endpoint,
+			AccessKeyID: s3Cfg.AccessKeyID,
+			Prefix:      s3Cfg.Prefix,
+			Compression: s3Cfg.Compression,
+		}
+		return nil
+	case backend.BackendTypeDir:
+		if targetDirFlag == "" {
+			return fmt.Errorf("--dir is required with --to dir")
+		}
+		return configureDirBackend(config, targetDirFlag)
+	case backend.BackendTypeWebDAV:
+		if targetWebDAVFlag == "" {
+			return fmt.Errorf("--webdav is required with --to webdav")
+		}
+		return configureWebDAVBackend(config, targetWebDAVFlag, targetWebDAVUsernameFlag)
+	case backend.BackendTypeGit:
+		if targetGitFlag == "" {
+			return fmt.Errorf("--git is required with --to git")
+		}
+		return configureGitBackend(config, targetGitFlag)
+	default:
+		return fmt.Errorf("unsupported backend %q, expected one of http, s3, dir, webdav, or git", backendType)
+	}
+}
+
+var syncingMirrorCmd = &cobra.Command{
+	Use:   "mirror",
+	Short: "Mirror your history to additional sync backends, e.g. an S3 bucket as an archive alongside the hishtory server",
+	Long:  "Run `hishtory syncing mirror add --to s3 --s3-bucket my-bucket --s3-region us-east-1` to mirror your history to another backend in addition to the one this device already syncs via, and `hishtory syncing mirror remove s3` to stop. History entries are written to every backend, and syncing keeps working while one of them is down. Run this on each of your devices so that all of their history is mirrored.",
+}
+
+var syncingMirrorAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Start mirroring your history to the sync backend given by --to, uploading your full history to it",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		mirrorConfig := *hctx.GetConf(ctx)
+		lib.CheckFatalError(configureTargetBackend(&mirrorConfig, targetBackendFlag))
+		lib.CheckFatalError(lib.AddMirrorBackend(ctx, &mirrorConfig))
+		fmt.Printf("Mirroring history to the %s backend\n", lib.BackendTypeOrDefault(mirrorConfig.BackendType))
+	},
+}
+
+var syncingMirrorRemoveCmd = &cobra.Command{
+	Use:       "remove <type> [location]",
+	Short:     "Stop mirroring your history to the sync backend of the given type",
+	Long:      "Stops mirroring your history to the sync backend of the given type. If you mirror to several backends of the same type, also pass the location of the one to remove: the S3 bucket (optionally followed by /prefix), the directory, the WebDAV URL, or the git remote.",
+	ValidArgs: []string{"http", "s3", "dir", "webdav", "git"},
+	Args:      cobra.RangeArgs(1, 2),
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		location := ""
+		if len(args) > 1 {
+			location = args[1]
+		}
+		lib.CheckFatalError(lib.RemoveMirrorBackend(ctx, args[0], location))
+		fmt.Printf("Stopped mirroring history to the %s backend\n", args[0])
+	},
+}
+
+var syncingGcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Compact the history stored in your S3 bucket and delete objects that are no longer needed",
+	Long:  "Packs old history entries into larger segment objects and deletes the inboxes, deletion requests, and dump requests of uninstalled devices along with messages that have already been read, to reduce the number of objects in your S3 bucket. This also runs automatically once a day if you use `hishtory daemon`.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := hctx.MakeContext()
+		conf := hctx.GetConf(ctx)
+		if conf.IsOffline {
+			lib.CheckFatalError(fmt.Errorf("syncing is disabled"))
+		}
+		b, ctx := lib.GetSyncBackend(ctx)
+		var s3Backend *backend.S3Backend
+		for _, b := range backend.Backends(b) {
+			if s, ok := b.(*backend.S3Backend); ok {
+				s3Backend = s
+			}
+		}
+		if s3Backend == nil {
+			lib.CheckFatalError(fmt.Errorf("gc is only supported for the s3 backend, but this device syncs via the %s backend", lib.BackendTypeOrDefault(conf.BackendType)))
+		}
+		stats, err := s3Backend.GC(ctx)
+		lib.CheckFatalError(err)
+		fmt.Printf("Compacted %d entries into %d segments and deleted %d objects, reclaiming %s\n", stats.EntriesCompacted, stats.SegmentsWritten, stats.ObjectsDeleted, formatBytes(stats.BytesReclaimed))
+	},
+}
+
+func formatBytes(n int64) string {
+	abs := max(n, -n)
+	switch {
+	case abs >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case abs >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case abs >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
+
+var (
+	migrateNotifyDevicesFlag *bool
+	targetBackendFlag        string
+	targetS3BucketFlag       string
+	targetS3RegionFlag       string
+	targetS3EndpointFlag     string
+	targetS3AccessKeyIdFlag  string
+	targetS3PrefixFlag       string
+	targetS3CompressionFlag  string
+	targetDirFlag            string
+	targetWebDAVFlag         string
+	targetWebDAVUsernameFlag string
+	targetGitFlag            string
+)
+
+var (
+	syncingDirFlag            *string
+	syncingWebDAVFlag         *string
+	syncingWebDAVUsernameFlag *string
+	syncingGitFlag            *string
+)
+
 func init() {
 	rootCmd.AddCommand(syncingCmd)
+	syncingCmd.AddCommand(syncingMigrateCmd)
+	syncingCmd.AddCommand(syncingGcCmd)
+	syncingCmd.AddCommand(syncingMirrorCmd)
+	syncingMirrorCmd.AddCommand(syncingMirrorAddCmd)
+	syncingMirrorCmd.AddCommand(syncingMirrorRemoveCmd)
+	syncingDirFlag = syncingCmd.Flags().String("dir", "", "Sync via the given directory shared between your devices rather than via the hishtory server")
+	syncingWebDAVFlag = syncingCmd.Flags().String("webdav", "", "Sync via the given WebDAV collection rather than via the hishtory server")
+	syncingWebDAVUsernameFlag = syncingCmd.Flags().String("webdav-username", "", "The username for the WebDAV server (the password is read from $HISHTORY_WEBDAV_PASSWORD)")
+	syncingGitFlag = syncingCmd.Flags().String("git", "", "Sync via the given git repository rather than via the hishtory server")
+	syncingCmd.MarkFlagsMutuallyExclusive("dir", "webdav", "git")
+	migrateNotifyDevicesFlag = syncingMigrateCmd.Flags().Bool("notify-devices", false, "Tell your other devices to switch to the new backend too")
+	for _, cmd := range []*cobra.Command{syncingMigrateCmd, syncingMirrorAddCmd} {
+		cmd.Flags().StringVar(&targetBackendFlag, "to", "", "The backend to sync via: http, s3, dir, webdav, or git")
+		cmd.Flags().StringVar(&targetS3BucketFlag, "s3-bucket", "", "The S3 bucket to sync via")
+		cmd.Flags().StringVar(&targetS3RegionFlag, "s3-region", "", "The AWS region of the S3 bucket")
+		cmd.Flags().StringVar(&targetS3EndpointFlag, "s3-endpoint", "", "A custom S3-compatible endpoint URL")
+		cmd.Flags().StringVar(&targetS3AccessKeyIdFlag, "s3-access-key-id", "", "The AWS access key ID (the secret is read from $HISHTORY_S3_SECRET_ACCESS_KEY)")
+		cmd.Flags().StringVar(&targetS3PrefixFlag, "s3-prefix", "", "A path prefix within the S3 bucket")
+		cmd.Flags().StringVar(&targetS3CompressionFlag, "s3-compression", "", "Compress uploaded S3 objects with gzip or zstd")
+		cmd.Flags().StringVar(&targetDirFlag, "dir", "", "The shared directory to sync via")
+		cmd.Flags().StringVar(&targetWebDAVFlag, "webdav", "", "The WebDAV collection to sync via")
+		cmd.Flags().StringVar(&targetWebDAVUsernameFlag, "webdav-username", "", "The username for the WebDAV server (the password is read from $HISHTORY_WEBDAV_PASSWORD)")
+		cmd.Flags().StringVar(&targetGitFlag, "git", "", "The git repository to sync via")
+		lib.CheckFatalError(cmd.MarkFlagRequired("to"))
+	}
 }
@@ -41,8 +41,60 @@ type HistoryEntry struct {
 	DeviceId                string        `json:"device_id" gorm:"uniqueIndex:compositeindex"`
 	EntryId                 string        `json:"entry_id" gorm:"uniqueIndex:compositeindex,uniqueIndex:entry_id_index"`
 	CustomColumns           CustomColumns `json:"custom_columns"`
+	// The shell session that the command was run in, along with the PID and TTY of that shell. These
+	// are empty for entries recorded by older clients or imported from shell history files.
+	SessionId string `json:"session_id" gorm:"index:session_id_index"`
+	ShellPid  int    `json:"shell_pid"`
+	Tty       string `json:"tty"`
+	// The git repository root, branch and HEAD commit if the command was run inside of a git repository
+	GitRepo   string `json:"git_repo" gorm:"index:git_repo_index"`
+	GitBranch string `json:"git_branch"`
+	GitCommit string `json:"git_commit"`
+	// Where the command was run from: the SSH client address if it was run over SSH, the container
+	// runtime if it was run inside of a container, and the active kubectl context and namespace
+	SshClient     string `json:"ssh_client"`
+	Container     string `json:"container"`
+	KubeContext   string `json:"kube_context" gorm:"index:kube_context_index"`
+	KubeNamespace string `json:"kube_namespace"`
+	// The tail of the output and the resource usage, for commands that were run via `hishtory run`
+	Output   string        `json:"output"`
+	WallTime time.Duration `json:"wall_time"`
+	CpuTime  time.Duration `json:"cpu_time"`
+	MaxRssKb int64         `json:"max_rss_kb"`
 }
 
+// A search query that was run in the TUI, stored so that previous queries can be recalled
+type QueryHistoryEntry struct {
+	Query     string    `json:"query"`
+	Timestamp time.Time `json:"timestamp" gorm:"index:query_history_timestamp_index"`
+	DeviceId  string    `json:"device_id"`
+	EntryId   string    `json:"entry_id" gorm:"uniqueIndex:query_history_entry_id_index"`
+	// Whether this query was recorded on this device and still needs to be uploaded. Not synced.
+	PendingUpload bool `json:"-" gorm:"index:query_history_pending_upload_index"`
+}
+
+// The prefix for EncHistoryEntry.EncryptedId that marks an encrypted QueryHistoryEntry. These are
+// synced through the same backend APIs as history entries, but are encrypted with different additional
+// data so that they can never be decrypted as a HistoryEntry.
+const QUERY_HISTORY_ID_PREFIX = shared.QueryHistoryIdPrefix
+
+// A ControlMessage is sent from one device to the user's other devices, e.g. to tell them to switch to
+// a different sync backend
+type ControlMessage struct {
+	EntryId   string    `json:"entry_id"`
+	Timestamp time.Time `json:"timestamp"`
+	DeviceId  string    `json:"device_id"`
+	// The sync backend that the message was sent via, and the JSON config of the backend that devices
+	// syncing via it should switch to
+	FromBackendType string          `json:"from_backend_type"`
+	BackendConfig   json.RawMessage `json:"backend_config"`
+}
+
+// The prefix for EncHistoryEntry.EncryptedId that marks an encrypted ControlMessage. Like query history,
+// these are encrypted with different additional data so that they can never be decrypted as a HistoryEntry,
+// and only the user's own devices can create them.
+const CONTROL_MESSAGE_ID_PREFIX = shared.ControlMessageIdPrefix
+
 type CustomColumns []CustomColumn
 
 type CustomColumn struct {
@@ -158,12 +210,12 @@ func DecryptHistoryEntry(userSecret string, entry shared.EncHistoryEntry) (Histo
 	}
 	plaintext, err := Decrypt(userSecret, entry.EncryptedData, []byte(UserId(userSecret)), entry.Nonce)
 	if err != nil {
-		return HistoryEntry{}, nil
+		return HistoryEntry{}, fmt.Errorf("failed to decrypt history entry: %w", err)
 	}
 	var decryptedEntry HistoryEntry
 	err = json.Unmarshal(plaintext, &decryptedEntry)
 	if err != nil {
-		return HistoryEntry{}, nil
+		return HistoryEntry{}, fmt.Errorf("failed to unmarshal decrypted history entry: %w", err)
 	}
 	if decryptedEntry.EntryId != "" && entry.EncryptedId != "" && decryptedEntry.EntryId != entry.EncryptedId {
 		return HistoryEntry{}, fmt.Errorf("rejecting encrypted history entry that contains mismatching IDs (outer=%s inner=%s)", entry.EncryptedId, decryptedEntry.EntryId)
@@ -171,6 +223,98 @@ func DecryptHistoryEntry(userSecret string, entry shared.EncHistoryEntry) (Histo
 	return decryptedEntry, nil
 }
 
+func queryHistoryAdditionalData(userSecret string) []byte {
+	return []byte(UserId(userSecret) + "/" + QUERY_HISTORY_ID_PREFIX)
+}
+
+func IsEncryptedQueryHistoryEntry(entry shared.EncHistoryEntry) bool {
+	return strings.HasPrefix(entry.EncryptedId, QUERY_HISTORY_ID_PREFIX)
+}
+
+func EncryptQueryHistoryEntry(userSecret string, entry QueryHistoryEntry) (shared.EncHistoryEntry, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return shared.EncHistoryEntry{}, err
+	}
+	ciphertext, nonce, err := Encrypt(userSecret, data, queryHistoryAdditionalData(userSecret))
+	if err != nil {
+		return shared.EncHistoryEntry{}, err
+	}
+	return shared.EncHistoryEntry{
+		EncryptedData: ciphertext,
+		Nonce:         nonce,
+		UserId:        UserId(userSecret),
+		Date:          entry.Timestamp,
+		EncryptedId:   QUERY_HISTORY_ID_PREFIX + entry.EntryId,
+		ReadCount:     0,
+	}, nil
+}
+
+func DecryptQueryHistoryEntry(userSecret string, entry shared.EncHistoryEntry) (QueryHistoryEntry, error) {
+	if entry.UserId != UserId(userSecret) {
+		return QueryHistoryEntry{}, fmt.Errorf("refusing to decrypt query history entry with mismatching UserId")
+	}
+	plaintext, err := Decrypt(userSecret, entry.EncryptedData, queryHistoryAdditionalData(userSecret), entry.Nonce)
+	if err != nil {
+		return QueryHistoryEntry{}, err
+	}
+	var decryptedEntry QueryHistoryEntry
+	err = json.Unmarshal(plaintext, &decryptedEntry)
+	if err != nil {
+		return QueryHistoryEntry{}, fmt.Errorf("failed to unmarshal query history entry: %w", err)
+	}
+	if QUERY_HISTORY_ID_PREFIX+decryptedEntry.EntryId != entry.EncryptedId {
+		return QueryHistoryEntry{}, fmt.Errorf("rejecting encrypted query history entry that contains mismatching IDs (outer=%s inner=%s)", entry.EncryptedId, decryptedEntry.EntryId)
+	}
+	return decryptedEntry, nil
+}
+
+func controlMessageAdditionalData(userSecret string) []byte {
+	return []byte(UserId(userSecret) + "/" + CONTROL_MESSAGE_ID_PREFIX)
+}
+
+func IsEncryptedControlMessage(entry shared.EncHistoryEntry) bool {
+	return strings.HasPrefix(entry.EncryptedId, CONTROL_MESSAGE_ID_PREFIX)
+}
+
+func EncryptControlMessage(userSecret string, msg ControlMessage) (shared.EncHistoryEntry, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return shared.EncHistoryEntry{}, err
+	}
+	ciphertext, nonce, err := Encrypt(userSecret, data, controlMessageAdditionalData(userSecret))
+	if err != nil {
+		return shared.EncHistoryEntry{}, err
+	}
+	return shared.EncHistoryEntry{
+		EncryptedData: ciphertext,
+		Nonce:         nonce,
+		UserId:        UserId(userSecret),
+		Date:          msg.Timestamp,
+		EncryptedId:   CONTROL_MESSAGE_ID_PREFIX + msg.EntryId,
+		ReadCount:     0,
+	}, nil
+}
+
+func DecryptControlMessage(userSecret string, entry shared.EncHistoryEntry) (ControlMessage, error) {
+	if entry.UserId != UserId(userSecret) {
+		return ControlMessage{}, fmt.Errorf("refusing to decrypt control message with mismatching UserId")
+	}
+	plaintext, err := Decrypt(userSecret, entry.EncryptedData, controlMessageAdditionalData(userSecret), entry.Nonce)
+	if err != nil {
+		return ControlMessage{}, err
+	}
+	var msg ControlMessage
+	err = json.Unmarshal(plaintext, &msg)
+	if err != nil {
+		return ControlMessage{}, fmt.Errorf("failed to unmarshal control message: %w", err)
+	}
+	if CONTROL_MESSAGE_ID_PREFIX+msg.EntryId != entry.EncryptedId {
+		return ControlMessage{}, fmt.Errorf("rejecting encrypted control message that contains mismatching IDs (outer=%s inner=%s)", entry.EncryptedId, msg.EntryId)
+	}
+	return msg, nil
+}
+
 func ValidateHishtoryPath() error {
 	hishtoryPath := os.Getenv("HISHTORY_PATH")
 	if strings.HasPrefix(hishtoryPath, "/") {
@@ -1,7 +1,10 @@
 package data
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestEncryptDecrypt(t *testing.T) {
@@ -58,3 +61,56 @@ func TestCustomColumnSerialization(t *testing.T) {
 		t.Fatalf("unexpected val for empty CustomColumns: %#v", val)
 	}
 }
+
+func TestEncryptDecryptQueryHistoryEntry(t *testing.T) {
+	entry := QueryHistoryEntry{Query: "ls exit_code:0", Timestamp: time.Unix(1234567, 0).UTC(), DeviceId: "device", EntryId: "id"}
+	encEntry, err := EncryptQueryHistoryEntry("key", entry)
+	checkError(t, err)
+	if !IsEncryptedQueryHistoryEntry(encEntry) {
+		t.Fatalf("expected encrypted entry to be recognized as a query history entry: %#v", encEntry)
+	}
+	decEntry, err := DecryptQueryHistoryEntry("key", encEntry)
+	checkError(t, err)
+	if decEntry != entry {
+		t.Fatalf("expected decrypt(encrypt(x)) to work, got %#v", decEntry)
+	}
+
+	// Query history entries can't be decrypted as history entries, and vice versa
+	if historyEntry, err := DecryptHistoryEntry("key", encEntry); err == nil {
+		t.Fatalf("unexpectedly decrypted a query history entry as a history entry: %#v", historyEntry)
+	}
+	encHistoryEntry, err := EncryptHistoryEntry("key", HistoryEntry{Command: "ls", EntryId: "id2"})
+	checkError(t, err)
+	if IsEncryptedQueryHistoryEntry(encHistoryEntry) {
+		t.Fatalf("unexpectedly recognized a history entry as a query history entry: %#v", encHistoryEntry)
+	}
+	encHistoryEntry.EncryptedId = QUERY_HISTORY_ID_PREFIX + "id2"
+	if _, err := DecryptQueryHistoryEntry("key", encHistoryEntry); err == nil {
+		t.Fatalf("unexpectedly decrypted a history entry as a query history entry")
+	}
+}
+
+func TestEncryptDecryptControlMessage(t *testing.T) {
+	msg := ControlMessage{EntryId: "id", Timestamp: time.Unix(1234567, 0).UTC(), DeviceId: "device", FromBackendType: "http", BackendConfig: json.RawMessage(`{"backend_type":"s3"}`)}
+	encEntry, err := EncryptControlMessage("key", msg)
+	checkError(t, err)
+	if !IsEncryptedControlMessage(encEntry) || IsEncryptedQueryHistoryEntry(encEntry) {
+		t.Fatalf("expected encrypted entry to be recognized as a control message: %#v", encEntry)
+	}
+	decMsg, err := DecryptControlMessage("key", encEntry)
+	checkError(t, err)
+	if !reflect.DeepEqual(decMsg, msg) {
+		t.Fatalf("expected decrypt(encrypt(x)) to work, got %#v", decMsg)
+	}
+
+	// Control messages can only be created with the user secret
+	if _, err := DecryptControlMessage("other-key", encEntry); err == nil {
+		t.Fatalf("unexpectedly decrypted a control message with the wrong key")
+	}
+	encHistoryEntry, err := EncryptHistoryEntry("key", HistoryEntry{Command: "ls", EntryId: "id2"})
+	checkError(t, err)
+	encHistoryEntry.EncryptedId = CONTROL_MESSAGE_ID_PREFIX + "id2"
+	if _, err := DecryptControlMessage("key", encHistoryEntry); err == nil {
+		t.Fatalf("unexpectedly decrypted a history entry as a control message")
+	}
+}
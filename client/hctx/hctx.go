@@ -109,6 +109,7 @@ func OpenLocalSqliteDb() (*gorm.DB, error) {
 		return nil, err
 	}
 	db.AutoMigrate(&data.HistoryEntry{})
+	db.AutoMigrate(&data.QueryHistoryEntry{})
 	db.Exec("PRAGMA journal_mode = WAL")
 	db.Exec("pragma mmap_size = 268435456")
 	db.Exec("CREATE INDEX IF NOT EXISTS start_time_index ON history_entries(start_time)")
@@ -127,17 +128,23 @@ const (
 )
 
 func MakeContext() context.Context {
+	ctx := MakeContextWithoutDb()
+	db, err := OpenLocalSqliteDb()
+	if err != nil {
+		panic(fmt.Errorf("failed to open local DB: %w", err))
+	}
+	return context.WithValue(ctx, DbCtxKey, db)
+}
+
+// MakeContextWithoutDb is like MakeContext, but doesn't open the local DB since that is comparatively slow.
+// This is used by the shell hooks when they hand off history entries to the daemon.
+func MakeContextWithoutDb() context.Context {
 	ctx := context.Background()
 	config, err := GetConfig()
 	if err != nil {
 		panic(fmt.Errorf("failed to retrieve config: %w", err))
 	}
 	ctx = context.WithValue(ctx, ConfigCtxKey, &config)
-	db, err := OpenLocalSqliteDb()
-	if err != nil {
-		panic(fmt.Errorf("failed to open local DB: %w", err))
-	}
-	ctx = context.WithValue(ctx, DbCtxKey, db)
 	homedir, err := os.UserHomeDir()
 	if err != nil {
 		panic(fmt.Errorf("failed to get homedir: %w", err))
@@ -194,10 +201,24 @@ type ClientConfig struct {
 	DeviceId string `json:"device_id" yaml:"-"`
 
 	// Backend configuration for syncing
-	// BackendType specifies the sync backend: "http" (default) or "s3"
+	// BackendType specifies the sync backend: "http" (default), "s3", "dir", "webdav", or "git"
 	BackendType string `json:"backend_type,omitempty"`
 	// S3Config holds configuration for the S3 backend (only used when BackendType is "s3")
 	S3Config *S3BackendConfig `json:"s3_config,omitempty"`
+	// DirConfig holds configuration for the directory backend (only used when BackendType is "dir")
+	DirConfig *DirBackendConfig `json:"dir_config,omitempty"`
+	// WebDAVConfig holds configuration for the WebDAV backend (only used when BackendType is "webdav")
+	WebDAVConfig *WebDAVBackendConfig `json:"webdav_config,omitempty"`
+	// GitConfig holds configuration for the git backend (only used when BackendType is "git")
+	GitConfig *GitBackendConfig `json:"git_config,omitempty"`
+	// Additional backends that history is mirrored to, e.g. an S3 bucket as an archive alongside the hishtory server
+	MirrorBackends []BackendConfig `json:"mirror_backends,omitempty"`
+	// A backend that another device told this device to switch to. Migrating can take a while, so this is
+	// applied by the daemon or the next background sync rather than while retrieving the control message.
+	PendingBackendSwitch *BackendConfig `json:"pending_backend_switch,omitempty" yaml:"-"`
+	// The content encoding that the hishtory server advertised support for in request bodies. This is persisted so
+	// that short-lived processes like the shell hooks can compress their first request.
+	ServerRequestEncoding string `json:"server_request_encoding,omitempty" yaml:"-"`
 	// Used for skipping history entries prefixed with a space in bash
 	LastPreSavedHistoryLine string `json:"last_presaved_history_line" yaml:"-"`
 	// Used for skipping history entries prefixed with a space in bash
@@ -251,6 +272,30 @@ type ClientConfig struct {
 	// Columns that are used for default searches.
 	// See https://github.com/ddworken/hishtory/issues/268 for context on this.
 	DefaultSearchColumns []string `json:"default_search_columns"`
+	// Whether multi-line commands are rendered across multiple lines in the TUI rather than being escaped
+	MultiLineCommands bool `json:"multi_line_commands"`
+	// The maximum number of lines displayed for each multi-line command, other than the selected one which is always fully expanded
+	MaxLinesPerRow int `json:"max_lines_per_row"`
+	// Whether queries run in the TUI are synced to other devices so that they can be recalled there
+	SyncQueryHistory bool `json:"sync_query_history"`
+	// Display settings for individual columns, keyed by the column name
+	ColumnSettings map[string]ColumnSettings `json:"column_settings"`
+	// The maximum amount of time that each custom column command may run for, in milliseconds
+	CustomColumnTimeoutMs int `json:"custom_column_timeout_ms"`
+	// The maximum amount of time that all custom column commands may run for in total, in milliseconds
+	CustomColumnBudgetMs int `json:"custom_column_budget_ms"`
+	// How long custom column values are cached for each directory, in seconds. Zero disables caching.
+	CustomColumnCacheTtlSeconds int `json:"custom_column_cache_ttl_seconds"`
+	// How commands containing secrets are handled: "mask" replaces the secret, "skip" doesn't record the command, and "off" disables secret scanning
+	SecretPolicy string `json:"secret_policy"`
+	// Additional regexes for secrets, on top of the built-in rules
+	SecretPatterns []string `json:"secret_patterns"`
+	// Regexes for commands that should never be recorded
+	IgnoredCommands []string `json:"ignored_commands"`
+	// Globs for directories in which commands should never be recorded
+	IgnoredDirectories []string `json:"ignored_directories"`
+	// The maximum number of bytes of output that `hishtory run` records for each command
+	RunOutputMaxBytes int `json:"run_output_max_bytes"`
 }
 
 type ColorScheme struct {
@@ -264,6 +309,32 @@ type CustomColumnDefinition struct {
 	ColumnCommand string `json:"column_command"`
 }
 
+// ColumnSettings configures how a single column is displayed in the TUI, `hishtory query`, and the web UI
+type ColumnSettings struct {
+	// The minimum width of the column (only applies to the TUI)
+	MinWidth int `json:"min_width,omitempty"`
+	// The maximum width of the column, values that are wider than this are truncated
+	MaxWidth int `json:"max_width,omitempty"`
+	// How values that are too wide are truncated: "right" (the default), "left", "middle", or "path"
+	Truncation string `json:"truncation,omitempty"`
+	// How values are aligned within the column: "left" (the default), "right", or "center"
+	Alignment string `json:"alignment,omitempty"`
+	// An alternate name to display in the header of the column
+	HeaderName string `json:"header_name,omitempty"`
+}
+
+// BackendConfig holds the configuration of a single sync backend. Only the config for BackendType is used.
+type BackendConfig struct {
+	BackendType  string               `json:"backend_type"`
+	S3Config     *S3BackendConfig     `json:"s3_config,omitempty"`
+	DirConfig    *DirBackendConfig    `json:"dir_config,omitempty"`
+	WebDAVConfig *WebDAVBackendConfig `json:"webdav_config,omitempty"`
+	GitConfig    *GitBackendConfig    `json:"git_config,omitempty"`
+	// For mirror backends, the time before the first history entry that failed to upload to this backend while
+	// another backend was available, or 0 if there is none. These are uploaded by lib.UploadMissedMirrorEntries.
+	MissedUploadTimestamp int64 `json:"missed_upload_timestamp,omitempty"`
+}
+
 // S3BackendConfig holds configuration for the S3 sync backend.
 // This is stored in the client config file (except for SecretAccessKey).
 type S3BackendConfig struct {
@@ -277,6 +348,33 @@ type S3BackendConfig struct {
 	AccessKeyID string `json:"access_key_id,omitempty"`
 	// Prefix is an optional path prefix within the bucket (e.g., "hishtory/")
 	Prefix string `json:"prefix,omitempty"`
+	// Concurrency is the maximum number of parallel requests to S3 (optional, defaults to 16)
+	Concurrency int `json:"concurrency,omitempty"`
+	// Compression is the content encoding ("gzip" or "zstd") to compress uploaded objects with (optional)
+	Compression string `json:"compression,omitempty"`
+}
+
+// DirBackendConfig holds configuration for the directory sync backend.
+type DirBackendConfig struct {
+	// Path is the absolute path of a directory shared between devices (e.g. a network drive)
+	Path string `json:"path"`
+}
+
+// WebDAVBackendConfig holds configuration for the WebDAV sync backend.
+// This is stored in the client config file (except for the password, which is read from HISHTORY_WEBDAV_PASSWORD).
+type WebDAVBackendConfig struct {
+	// URL is the URL of the WebDAV collection to store history in (required)
+	URL string `json:"url"`
+	// Username is the username for HTTP basic auth (optional)
+	Username string `json:"username,omitempty"`
+}
+
+// GitBackendConfig holds configuration for the git sync backend.
+type GitBackendConfig struct {
+	// Remote is the URL or path of the git repository (required)
+	Remote string `json:"remote"`
+	// Branch is the branch to store history on (optional, defaults to "main")
+	Branch string `json:"branch,omitempty"`
 }
 
 func GetConfigContents() ([]byte, error) {
@@ -348,6 +446,18 @@ func GetConfig() (ClientConfig, error) {
 	if len(config.DefaultSearchColumns) == 0 {
 		config.DefaultSearchColumns = []string{"command", "hostname", "current_working_directory"}
 	}
+	if config.MaxLinesPerRow == 0 {
+		config.MaxLinesPerRow = 3
+	}
+	if config.CustomColumnTimeoutMs == 0 {
+		config.CustomColumnTimeoutMs = 2000
+	}
+	if config.CustomColumnBudgetMs == 0 {
+		config.CustomColumnBudgetMs = 3000
+	}
+	if config.RunOutputMaxBytes == 0 {
+		config.RunOutputMaxBytes = 16384
+	}
 	return config, nil
 }
 
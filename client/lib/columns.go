@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+
+	"github.com/mattn/go-runewidth"
+)
+
+var (
+	SUPPORTED_TRUNCATION_STRATEGIES = []string{"right", "left", "middle", "path"}
+	SUPPORTED_COLUMN_ALIGNMENTS     = []string{"left", "right", "center"}
+)
+
+// GetColumnSettings returns the configured display settings for the given column
+func GetColumnSettings(ctx context.Context, columnName string) hctx.ColumnSettings {
+	config := hctx.GetConf(ctx)
+	if settings, ok := config.ColumnSettings[columnName]; ok {
+		return settings
+	}
+	for name, settings := range config.ColumnSettings {
+		if strings.EqualFold(name, columnName) {
+			return settings
+		}
+	}
+	return hctx.ColumnSettings{}
+}
+
+// GetColumnHeader returns the name to display in the header for the given column
+func GetColumnHeader(ctx context.Context, columnName string) string {
+	headerName := GetColumnSettings(ctx, columnName).HeaderName
+	if headerName != "" {
+		return headerName
+	}
+	return columnName
+}
+
+func ValidateColumnSettings(settings hctx.ColumnSettings) error {
+	if settings.MinWidth < 0 || settings.MaxWidth < 0 {
+		return fmt.Errorf("column widths must not be negative")
+	}
+	if settings.MinWidth > 0 && settings.MaxWidth > 0 && settings.MinWidth > settings.MaxWidth {
+		return fmt.Errorf("the min width (%d) must not be larger than the max width (%d)", settings.MinWidth, settings.MaxWidth)
+	}
+	if settings.Truncation != "" && !slices.Contains(SUPPORTED_TRUNCATION_STRATEGIES, settings.Truncation) {
+		return fmt.Errorf("unsupported truncation strategy %q, must be one of: %s", settings.Truncation, strings.Join(SUPPORTED_TRUNCATION_STRATEGIES, ", "))
+	}
+	if settings.Alignment != "" && !slices.Contains(SUPPORTED_COLUMN_ALIGNMENTS, settings.Alignment) {
+		return fmt.Errorf("unsupported alignment %q, must be one of: %s", settings.Alignment, strings.Join(SUPPORTED_COLUMN_ALIGNMENTS, ", "))
+	}
+	return nil
+}
+
+// TruncateColumnValue truncates value so that it is at most width cells wide, using the given
+// truncation strategy. Values that already fit are returned unchanged.
+func TruncateColumnValue(value string, width int, strategy string) string {
+	valueWidth := runewidth.StringWidth(value)
+	if width <= 0 || valueWidth <= width {
+		return value
+	}
+	if width == 1 {
+		return "…"
+	}
+	switch strategy {
+	case "left":
+		return runewidth.TruncateLeft(value, valueWidth-(width-1), "…")
+	case "middle":
+		headWidth := (width - 1) / 2
+		tailWidth := width - 1 - headWidth
+		return runewidth.Truncate(value, headWidth, "") + "…" + runewidth.TruncateLeft(value, valueWidth-tailWidth, "")
+	case "path":
+		abbreviated := AbbreviatePath(value)
+		if runewidth.StringWidth(abbreviated) <= width {
+			return abbreviated
+		}
+		// The last path component is the most relevant bit, so keep that if it is still too long
+		return TruncateColumnValue(abbreviated, width, "left")
+	default:
+		return runewidth.Truncate(value, width, "…")
+	}
+}
+
+// AbbreviatePath shortens every component of a path other than the last to a single character,
+// e.g. `~/src/project/repo` becomes `~/s/p/repo`. Leading dots are kept so that hidden
+// directories remain distinguishable.
+func AbbreviatePath(path string) string {
+	components := strings.Split(path, "/")
+	for i, component := range components[:len(components)-1] {
+		prefix := ""
+		for strings.HasPrefix(component, ".") {
+			prefix += "."
+			component = component[1:]
+		}
+		if component == "" {
+			components[i] = prefix
+			continue
+		}
+		components[i] = prefix + string([]rune(component)[0])
+	}
+	return strings.Join(components, "/")
+}
+
+// AlignColumnValue pads value to the given width according to the alignment
+func AlignColumnValue(value string, width int, alignment string) string {
+	padding := width - runewidth.StringWidth(value)
+	if padding <= 0 {
+		return value
+	}
+	switch alignment {
+	case "right":
+		return strings.Repeat(" ", padding) + value
+	case "center":
+		return strings.Repeat(" ", padding/2) + value + strings.Repeat(" ", padding-padding/2)
+	default:
+		return value
+	}
+}
@@ -1,6 +1,22 @@
 # For detecting color rendering support for this terminal, see #134
 set -gx _hishtory_tui_color (hishtory getColorSupport; echo $status)
 
+# Identify this shell session so that entries can be grouped by the terminal they were run in
+set -gx HISHTORY_SESSION_ID (hishtory newSessionId)
+set -gx HISHTORY_SHELL_PID $fish_pid
+set -gx HISHTORY_TTY (tty 2>/dev/null)
+
+
+# Incognito mode is tracked with an env var so that it only applies to this shell session. This requires
+# wrapping hishtory, since `hishtory incognito` can't modify the environment of this shell.
+function hishtory
+    if [ "$argv[1]" = incognito ] && [ "$argv[2]" = on ]
+        set -gx HISHTORY_INCOGNITO 1
+    else if [ "$argv[1]" = incognito ] && [ "$argv[2]" = off ]
+        set -e HISHTORY_INCOGNITO
+    end
+    command hishtory $argv
+end
 
 function _hishtory_post_exec --on-event fish_preexec 
     # Runs after <ENTER>, but before the command is executed
@@ -0,0 +1,96 @@
+# This script should be dot-sourced from your PowerShell $PROFILE to integrate PowerShell with hishtory
+
+# Include guard. This file may be sourced multiple times, but we want it to only execute once.
+if ($global:__hishtory_ps_config_sourced) { return }
+$global:__hishtory_ps_config_sourced = $true
+
+# For detecting color rendering support for this terminal, see #134
+hishtory getColorSupport
+$env:_hishtory_tui_color = $LASTEXITCODE
+
+# Identify this shell session so that entries can be grouped by the terminal they were run in
+$env:HISHTORY_SESSION_ID = hishtory newSessionId
+$env:HISHTORY_SHELL_PID = $PID
+$env:HISHTORY_TTY = tty 2>$null
+
+# Incognito mode is tracked with an env var so that it only applies to this shell session. This requires
+# wrapping hishtory, since `hishtory incognito` can't modify the environment of this shell.
+function global:hishtory {
+    if ($args.Count -ge 2 -and $args[0] -eq "incognito" -and $args[1] -eq "on") {
+        $env:HISHTORY_INCOGNITO = 1
+    } elseif ($args.Count -ge 2 -and $args[0] -eq "incognito" -and $args[1] -eq "off") {
+        Remove-Item Env:HISHTORY_INCOGNITO -ErrorAction SilentlyContinue
+    }
+    & (Get-Command hishtory -CommandType Application | Select-Object -First 1) @args
+}
+
+# Starts hishtory without waiting for it to finish so that the prompt isn't slowed down. This uses
+# ProcessStartInfo.ArgumentList so that commands containing quotes and spaces are passed through as-is.
+function __hishtory_run_in_background {
+    $startInfo = [System.Diagnostics.ProcessStartInfo]::new("hishtory")
+    foreach ($arg in $args) {
+        $startInfo.ArgumentList.Add([string]$arg)
+    }
+    $startInfo.UseShellExecute = $false
+    $startInfo.RedirectStandardOutput = $true
+    $startInfo.RedirectStandardError = $true
+    [System.Diagnostics.Process]::Start($startInfo) | Out-Null
+}
+
+# PSReadLine invokes the AddToHistoryHandler after <ENTER> but before the command is executed
+$global:__hishtory_original_history_handler = (Get-PSReadLineOption).AddToHistoryHandler
+Set-PSReadLineOption -AddToHistoryHandler {
+    param([string]$line)
+    $global:__hishtory_command = $line
+    $global:__hishtory_start_time = hishtory getTimestamp
+    __hishtory_run_in_background presaveHistoryEntry pwsh $line $global:__hishtory_start_time # Background Run
+    # hishtory presaveHistoryEntry pwsh $line $global:__hishtory_start_time *> $null # Foreground Run
+    if ($global:__hishtory_original_history_handler) {
+        return $global:__hishtory_original_history_handler.Invoke($line)
+    }
+    return $true
+}
+
+# Wrap the existing prompt function so that we can save the entry after the command is executed
+$global:__hishtory_original_prompt = $function:prompt
+function global:prompt {
+    # This must run first, before anything else overwrites $? and $LASTEXITCODE
+    $succeeded = $global:?
+    $exitCode = 0
+    if (-not $succeeded) {
+        # $LASTEXITCODE is only updated by native commands, so fall back to 1 for failed cmdlets
+        $exitCode = if ($global:LASTEXITCODE) { $global:LASTEXITCODE } else { 1 }
+    }
+    $originalLastExitCode = $global:LASTEXITCODE
+
+    if ($global:__hishtory_command) {
+        __hishtory_run_in_background saveHistoryEntry pwsh $exitCode $global:__hishtory_command $global:__hishtory_start_time # Background Run
+        # hishtory saveHistoryEntry pwsh $exitCode $global:__hishtory_command $global:__hishtory_start_time *> $null # Foreground Run
+        __hishtory_run_in_background updateLocalDbFromRemote
+        # Unset __hishtory_command so we don't double-save entries when the prompt is re-rendered
+        $global:__hishtory_command = $null
+    }
+
+    # Restore $LASTEXITCODE so that other prompt customizations still see the original value
+    $global:LASTEXITCODE = $originalLastExitCode
+    & $global:__hishtory_original_prompt
+}
+
+function __hishtory_on_control_r {
+    $line = $null
+    $cursor = $null
+    [Microsoft.PowerShell.PSConsoleReadLine]::GetBufferState([ref]$line, [ref]$cursor)
+    $env:HISHTORY_TERM_INTEGRATION = 1
+    $env:HISHTORY_SHELL_NAME = "pwsh"
+    $result = hishtory tquery $line
+    Remove-Item Env:HISHTORY_TERM_INTEGRATION, Env:HISHTORY_SHELL_NAME
+    [Microsoft.PowerShell.PSConsoleReadLine]::InvokePrompt()
+    if ($result) {
+        [Microsoft.PowerShell.PSConsoleReadLine]::RevertLine()
+        [Microsoft.PowerShell.PSConsoleReadLine]::Insert(($result -join "`n"))
+    }
+}
+
+if ((hishtory config-get enable-control-r) -eq "true") {
+    Set-PSReadLineKeyHandler -Chord Ctrl+r -ScriptBlock { __hishtory_on_control_r }
+}
@@ -9,6 +9,22 @@ __hishtory_bash_config_sourced=true
 hishtory getColorSupport
 export _hishtory_tui_color=$?
 
+# Identify this shell session so that entries can be grouped by the terminal they were run in
+export HISHTORY_SESSION_ID=`hishtory newSessionId`
+export HISHTORY_SHELL_PID=$$
+export HISHTORY_TTY=`tty 2>/dev/null`
+
+# Incognito mode is tracked with an env var so that it only applies to this shell session. This requires
+# wrapping hishtory, since `hishtory incognito` can't modify the environment of this shell.
+function hishtory() {
+  if [ "${1:-}" = "incognito" ] && [ "${2:-}" = "on" ]; then
+    export HISHTORY_INCOGNITO=1
+  elif [ "${1:-}" = "incognito" ] && [ "${2:-}" = "off" ]; then
+    unset HISHTORY_INCOGNITO
+  fi
+  command hishtory "$@"
+}
+
 # Implementation of running before/after every command based on https://jichu4n.com/posts/debug-trap-and-prompt_command-in-bash/
 function __hishtory_precommand() {
   if [ -z "${HISHTORY_AT_PROMPT:-}" ]; then
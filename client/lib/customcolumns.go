@@ -0,0 +1,187 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// The value recorded for a custom column whose command didn't finish within the configured timeout
+const CUSTOM_COLUMN_TIMEOUT_MARKER = "<timed out>"
+
+const customColumnCachePath = ".custom_columns_cache.json"
+
+// How long to wait for the output of a custom column command after it has been killed. This handles commands
+// that start background processes which keep stdout open.
+const customColumnWaitDelay = 100 * time.Millisecond
+
+type customColumnCacheEntry struct {
+	ColumnCommand string    `json:"column_command"`
+	Value         string    `json:"value"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// A cache of custom column values, keyed by the cwd and then by the column name
+type customColumnCache map[string]map[string]customColumnCacheEntry
+
+// BuildCustomColumns evaluates all of the configured custom columns for a command run in cwd. The column
+// commands are run concurrently, and any that exceed the per-column timeout or the overall budget are
+// recorded as CUSTOM_COLUMN_TIMEOUT_MARKER so that a slow column can't block the prompt.
+func BuildCustomColumns(ctx context.Context, cwd string) (data.CustomColumns, error) {
+	return BuildCustomColumnsInDir(ctx, cwd, "", nil)
+}
+
+// BuildCustomColumnsInDir is like BuildCustomColumns, but runs the column commands in dir with the given
+// environment rather than inheriting them from the current process. This is used by the daemon, which
+// evaluates custom columns on behalf of shells that are running elsewhere.
+func BuildCustomColumnsInDir(ctx context.Context, cwd, dir string, env []string) (data.CustomColumns, error) {
+	config := hctx.GetConf(ctx)
+	ccs := data.CustomColumns{}
+	if len(config.CustomColumns) == 0 {
+		return ccs, nil
+	}
+	cacheTtl := time.Duration(config.CustomColumnCacheTtlSeconds) * time.Second
+	cache := customColumnCache{}
+	if cacheTtl > 0 {
+		cache = readCustomColumnCache(ctx)
+	}
+	if cache[cwd] == nil {
+		cache[cwd] = make(map[string]customColumnCacheEntry)
+	}
+
+	budgetCtx, cancel := context.WithTimeout(ctx, time.Duration(config.CustomColumnBudgetMs)*time.Millisecond)
+	defer cancel()
+	columnTimeout := time.Duration(config.CustomColumnTimeoutMs) * time.Millisecond
+	values := make([]string, len(config.CustomColumns))
+	errs := make([]error, len(config.CustomColumns))
+	var wg sync.WaitGroup
+	var cacheLock sync.Mutex
+	cacheUpdated := false
+	for i, cc := range config.CustomColumns {
+		cached, ok := cache[cwd][cc.ColumnName]
+		if ok && cached.ColumnCommand == cc.ColumnCommand && time.Since(cached.Timestamp) < cacheTtl {
+			values[i] = cached.Value
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, timedOut, err := evaluateCustomColumn(budgetCtx, cc, columnTimeout, dir, env)
+			values[i] = val
+			errs[i] = err
+			if err == nil && !timedOut && cacheTtl > 0 {
+				cacheLock.Lock()
+				defer cacheLock.Unlock()
+				cache[cwd][cc.ColumnName] = customColumnCacheEntry{ColumnCommand: cc.ColumnCommand, Value: val, Timestamp: time.Now()}
+				cacheUpdated = true
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, cc := range config.CustomColumns {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		ccs = append(ccs, data.CustomColumn{Name: cc.ColumnName, Val: values[i]})
+	}
+	if cacheUpdated {
+		err := writeCustomColumnCache(ctx, cache, cacheTtl)
+		if err != nil {
+			// The cache is just an optimization, so don't fail to record the command
+			hctx.GetLogger().Warnf("failed to write the custom column cache: %v", err)
+		}
+	}
+	return ccs, nil
+}
+
+// Runs the command for a single custom column. Returns whether the command timed out.
+func evaluateCustomColumn(ctx context.Context, cc hctx.CustomColumnDefinition, timeout time.Duration, dir string, env []string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, "bash", "-c", cc.ColumnCommand)
+	cmd.Dir = dir
+	cmd.Env = env
+	// Run the command in its own process group so that any children are also killed on timeout
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = customColumnWaitDelay
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Start()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to execute custom command named %v (stdout=%#v, stderr=%#v)", cc.ColumnName, stdout.String(), stderr.String())
+	}
+	err = cmd.Wait()
+	duration := time.Since(start)
+	if ctx.Err() != nil {
+		hctx.GetLogger().Warnf("custom column %#v timed out after %v", cc.ColumnName, duration)
+		return CUSTOM_COLUMN_TIMEOUT_MARKER, true, nil
+	}
+	if err != nil {
+		// Log a warning, but don't crash. This way commands can exit with a different status and still work.
+		hctx.GetLogger().Warnf("failed to execute custom command named %v (stdout=%#v, stderr=%#v)", cc.ColumnName, stdout.String(), stderr.String())
+	}
+	hctx.GetLogger().Debugf("custom column %#v took %v", cc.ColumnName, duration)
+	return strings.TrimSpace(stdout.String()), false, nil
+}
+
+func getCustomColumnCachePath(ctx context.Context) string {
+	return path.Join(hctx.GetHome(ctx), data.GetHishtoryPath(), customColumnCachePath)
+}
+
+func readCustomColumnCache(ctx context.Context) customColumnCache {
+	cache := customColumnCache{}
+	contents, err := os.ReadFile(getCustomColumnCachePath(ctx))
+	if err != nil {
+		return cache
+	}
+	err = json.Unmarshal(contents, &cache)
+	if err != nil {
+		// Treat a corrupted cache as empty, it will be overwritten with valid values
+		return customColumnCache{}
+	}
+	return cache
+}
+
+func writeCustomColumnCache(ctx context.Context, cache customColumnCache, cacheTtl time.Duration) error {
+	// Drop expired entries so that the cache doesn't grow forever
+	for cwd, columns := range cache {
+		for name, entry := range columns {
+			if time.Since(entry.Timestamp) >= cacheTtl {
+				delete(columns, name)
+			}
+		}
+		if len(columns) == 0 {
+			delete(cache, cwd)
+		}
+	}
+	contents, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file and then rename it, since commands may be recorded concurrently
+	cachePath := getCustomColumnCachePath(ctx)
+	tmpPath := fmt.Sprintf("%s.%d.tmp", cachePath, os.Getpid())
+	err = os.WriteFile(tmpPath, contents, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, cachePath)
+}
@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+const daemonSocketPath = "daemon.sock"
+
+// How long the shell hooks wait for the daemon before falling back to doing the work themselves
+const (
+	daemonDialTimeout    = 100 * time.Millisecond
+	daemonRequestTimeout = time.Second
+)
+
+const (
+	DaemonRequestPresave = "presave"
+	DaemonRequestSave    = "save"
+	DaemonRequestSync    = "sync"
+)
+
+// DaemonRequest is sent by the shell hooks to the daemon. Requests are newline-delimited JSON, with one request
+// per connection.
+type DaemonRequest struct {
+	Type  string             `json:"type"`
+	Entry *data.HistoryEntry `json:"entry,omitempty"`
+	// The unsubstituted cwd and the environment of the shell, used for evaluating custom columns
+	Cwd string   `json:"cwd,omitempty"`
+	Env []string `json:"env,omitempty"`
+}
+
+// DaemonResponse acknowledges that a request was queued. Note that this is sent before the entry is persisted.
+type DaemonResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+// ErrDaemonNotRunning is returned by SendDaemonRequest when the daemon's socket can't be connected to
+var ErrDaemonNotRunning = errors.New("the hishtory daemon is not running")
+
+func GetDaemonSocketPath(homedir string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), daemonSocketPath)
+}
+
+// IsDaemonRunning returns whether a daemon is listening on the socket in the hishtory dir
+func IsDaemonRunning(homedir string) bool {
+	conn, err := net.DialTimeout("unix", GetDaemonSocketPath(homedir), daemonDialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// SendDaemonRequest sends a request to the daemon and waits for it to be acknowledged
+func SendDaemonRequest(homedir string, req DaemonRequest) error {
+	conn, err := net.DialTimeout("unix", GetDaemonSocketPath(homedir), daemonDialTimeout)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDaemonNotRunning, err)
+	}
+	defer conn.Close()
+	err = conn.SetDeadline(time.Now().Add(daemonRequestTimeout))
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(conn).Encode(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request to the daemon: %w", err)
+	}
+	var resp DaemonResponse
+	err = json.NewDecoder(conn).Decode(&resp)
+	if err != nil {
+		return fmt.Errorf("failed to read response from the daemon: %w", err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("daemon failed to handle request: %s", resp.Error)
+	}
+	return nil
+}
+
+// ListenForDaemonRequests creates the daemon's socket, replacing any stale socket left behind by a daemon
+// that didn't shut down cleanly
+func ListenForDaemonRequests(homedir string) (net.Listener, error) {
+	socketPath := GetDaemonSocketPath(homedir)
+	if IsDaemonRunning(homedir) {
+		return nil, fmt.Errorf("the hishtory daemon is already running (socket=%s)", socketPath)
+	}
+	err := os.MkdirAll(path.Dir(socketPath), 0o744)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hishtory dir: %w", err)
+	}
+	err = os.Remove(socketPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale daemon socket: %w", err)
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	// Requests contain the full command and environment, so only the current user may connect
+	err = os.Chmod(socketPath, 0o600)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set permissions on the daemon socket: %w", err)
+	}
+	return l, nil
+}
+
+// ServeDaemonRequests accepts connections until the listener is closed, passing each request to handle. The
+// error returned by handle is sent back to the client.
+func ServeDaemonRequests(l net.Listener, handle func(DaemonRequest) error) error {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to accept daemon connection: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			err := serveDaemonConn(conn, handle)
+			if err != nil {
+				hctx.GetLogger().Warnf("failed to serve daemon request: %v", err)
+			}
+		}()
+	}
+}
+
+func serveDaemonConn(conn net.Conn, handle func(DaemonRequest) error) error {
+	err := conn.SetDeadline(time.Now().Add(daemonRequestTimeout))
+	if err != nil {
+		return err
+	}
+	var req DaemonRequest
+	err = json.NewDecoder(conn).Decode(&req)
+	if errors.Is(err, io.EOF) {
+		// IsDaemonRunning connects without sending a request
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode request: %w", err)
+	}
+	var resp DaemonResponse
+	err = handle(req)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return json.NewEncoder(conn).Encode(resp)
+}
@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GitContext describes the git repository that a command was run in
+type GitContext struct {
+	// The root of the working tree
+	RepoRoot string
+	// The checked out branch, or empty if HEAD is detached
+	Branch string
+	// The commit that HEAD points to, or empty if the repo doesn't have any commits yet
+	Commit string
+}
+
+// The maximum number of symbolic refs that will be followed when resolving a ref
+const maxSymbolicRefDepth = 5
+
+// GetGitContext returns the git context for the repository containing dir, or nil if dir isn't inside
+// of a git repository. This reads the .git directory directly rather than exec-ing git, since it is run
+// for every recorded command.
+func GetGitContext(dir string) (*GitContext, error) {
+	repoRoot, gitDir, err := findGitDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if repoRoot == "" {
+		return nil, nil
+	}
+	commonDir, err := getGitCommonDir(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read git HEAD: %w", err)
+	}
+	gitContext := GitContext{RepoRoot: repoRoot}
+	headStr := strings.TrimSpace(string(head))
+	if ref, ok := strings.CutPrefix(headStr, "ref: "); ok {
+		gitContext.Branch = strings.TrimPrefix(ref, "refs/heads/")
+		gitContext.Commit, err = resolveGitRef(gitDir, commonDir, ref, 0)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// A detached HEAD
+		gitContext.Commit = headStr
+	}
+	return &gitContext, nil
+}
+
+// Finds the .git directory for the repository containing dir. Returns empty strings if dir is not in a git repo.
+func findGitDir(dir string) (string, string, error) {
+	dir = filepath.Clean(dir)
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		fi, err := os.Stat(dotGit)
+		if err == nil {
+			if fi.IsDir() {
+				return dir, dotGit, nil
+			}
+			// Worktrees and submodules use a .git file that points to the real git directory
+			contents, err := os.ReadFile(dotGit)
+			if err != nil {
+				return "", "", fmt.Errorf("failed to read %s: %w", dotGit, err)
+			}
+			gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(contents)), "gitdir: ")
+			if !ok {
+				return "", "", fmt.Errorf("failed to parse %s: %#v", dotGit, string(contents))
+			}
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(dir, gitDir)
+			}
+			return dir, gitDir, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", "", fmt.Errorf("failed to stat %s: %w", dotGit, err)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", nil
+		}
+		dir = parent
+	}
+}
+
+// Returns the directory containing the shared refs, which differs from gitDir for linked worktrees
+func getGitCommonDir(gitDir string) (string, error) {
+	contents, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if errors.Is(err, os.ErrNotExist) {
+		return gitDir, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read git commondir: %w", err)
+	}
+	commonDir := strings.TrimSpace(string(contents))
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(gitDir, commonDir)
+	}
+	return commonDir, nil
+}
+
+func resolveGitRef(gitDir, commonDir, ref string, depth int) (string, error) {
+	if depth > maxSymbolicRefDepth {
+		return "", fmt.Errorf("failed to resolve git ref %#v: too many levels of symbolic refs", ref)
+	}
+	for _, dir := range []string{gitDir, commonDir} {
+		contents, err := os.ReadFile(filepath.Join(dir, ref))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read git ref %#v: %w", ref, err)
+		}
+		val := strings.TrimSpace(string(contents))
+		if target, ok := strings.CutPrefix(val, "ref: "); ok {
+			return resolveGitRef(gitDir, commonDir, target, depth+1)
+		}
+		return val, nil
+	}
+	return findPackedGitRef(commonDir, ref)
+}
+
+// Looks up a ref in the packed-refs file. Returns an empty string if the ref doesn't exist, which
+// happens for the default branch of a repo that doesn't have any commits yet.
+func findPackedGitRef(commonDir, ref string) (string, error) {
+	f, err := os.Open(filepath.Join(commonDir, "packed-refs"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open packed-refs: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") {
+			// Skip comments and peeled tags
+			continue
+		}
+		commit, name, ok := strings.Cut(line, " ")
+		if ok && name == ref {
+			return commit, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read packed-refs: %w", err)
+	}
+	return "", nil
+}
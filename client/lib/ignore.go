@@ -0,0 +1,80 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// The name of a file that disables recording for the directory that it is in, and all subdirectories
+const HISHTORY_IGNORE_FILE = ".hishtoryignore"
+
+// ValidateIgnoredCommand checks that pattern is a valid regex. Note that ignored commands only support regexes
+// (matched anywhere in the command) and not globs, unlike ignored directories.
+func ValidateIgnoredCommand(pattern string) error {
+	_, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid ignored command regex %#v: %w", pattern, err)
+	}
+	return nil
+}
+
+func ValidateIgnoredDirectory(pattern string) error {
+	_, err := filepath.Match(pattern, "")
+	if err != nil {
+		return fmt.Errorf("invalid ignored directory glob %#v: %w", pattern, err)
+	}
+	return nil
+}
+
+// ShouldIgnoreCommand returns whether the given command, run in the given directory, matches any of the
+// configured ignore rules and thus shouldn't be recorded
+func ShouldIgnoreCommand(ctx context.Context, command, cwd string) (bool, error) {
+	config := hctx.GetConf(ctx)
+	for _, pattern := range config.IgnoredCommands {
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("invalid ignored command regex %#v: %w", pattern, err)
+		}
+		if r.MatchString(command) {
+			hctx.GetLogger().Infof("Skipping recording a command since it matches the ignored command regex %#v", pattern)
+			return true, nil
+		}
+	}
+	homedir := hctx.GetHome(ctx)
+	for dir := filepath.Clean(cwd); ; dir = filepath.Dir(dir) {
+		for _, pattern := range config.IgnoredDirectories {
+			matched, err := filepath.Match(expandHomeDir(pattern, homedir), dir)
+			if err != nil {
+				return false, fmt.Errorf("invalid ignored directory glob %#v: %w", pattern, err)
+			}
+			if matched {
+				hctx.GetLogger().Infof("Skipping recording a command since %#v matches the ignored directory glob %#v", dir, pattern)
+				return true, nil
+			}
+		}
+		// Note that other errors (e.g. unreadable parent directories) are treated as the file not existing
+		if _, err := os.Stat(filepath.Join(dir, HISHTORY_IGNORE_FILE)); err == nil {
+			hctx.GetLogger().Infof("Skipping recording a command since %#v contains a %s file", dir, HISHTORY_IGNORE_FILE)
+			return true, nil
+		}
+		if filepath.Dir(dir) == dir {
+			return false, nil
+		}
+	}
+}
+
+func expandHomeDir(p, homedir string) string {
+	if p == "~" {
+		return homedir
+	}
+	if strings.HasPrefix(p, "~/") {
+		return filepath.Join(homedir, p[2:])
+	}
+	return p
+}
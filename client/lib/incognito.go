@@ -0,0 +1,26 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+)
+
+// The env var that is set while a shell session is in incognito mode. Since `hishtory incognito` can't modify
+// the environment of the shell that it is run from, the shell integration wraps hishtory in a shell function
+// that sets and unsets it. This way incognito mode is scoped to the shell session (and any shells started from
+// it), and doesn't leave behind any state once the shell exits.
+const IncognitoEnvVar = "HISHTORY_INCOGNITO"
+
+// IsIncognito returns whether the current shell session is in incognito mode
+func IsIncognito() bool {
+	return os.Getenv(IncognitoEnvVar) != ""
+}
+
+// CheckIncognitoUpdated checks that the shell integration has put the current shell session into (or out of)
+// incognito mode, since the hishtory binary can't do so itself
+func CheckIncognitoUpdated(enabled bool) error {
+	if IsIncognito() != enabled {
+		return fmt.Errorf("failed to update incognito mode since the hishtory shell integration is outdated, please restart your shell so that the latest hishtory shell integration is loaded")
+	}
+	return nil
+}
@@ -48,6 +48,12 @@ var ConfigZshContents string
 //go:embed config.fish
 var ConfigFishContents string
 
+//go:embed config.nu
+var ConfigNuContents string
+
+//go:embed config.ps1
+var ConfigPs1Contents string
+
 var (
 	Version   string = "Unknown"
 	GitCommit string = "Unknown"
@@ -119,6 +125,29 @@ func BuildTableRow(ctx context.Context, columnNames []string, entry data.History
 			row = append(row, commandRenderer(entry.Command))
 		case "User", "user":
 			row = append(row, entry.LocalUsername)
+		case "Session", "session":
+			row = append(row, entry.SessionId)
+		case "Repo", "repo":
+			row = append(row, entry.GitRepo)
+		case "Branch", "branch":
+			row = append(row, entry.GitBranch)
+		case "Commit", "commit":
+			// Display the abbreviated commit hash, like git does
+			row = append(row, entry.GitCommit[:min(len(entry.GitCommit), 7)])
+		case "Via", "via":
+			if entry.SshClient != "" {
+				row = append(row, "ssh:"+entry.SshClient)
+			} else {
+				row = append(row, "")
+			}
+		case "Container", "container":
+			row = append(row, entry.Container)
+		case "Kube Context", "kctx":
+			if entry.KubeContext != "" {
+				row = append(row, entry.KubeContext+"/"+entry.KubeNamespace)
+			} else {
+				row = append(row, "")
+			}
 		default:
 			customColumnValue, err := getCustomColumnValue(ctx, header, entry)
 			if err != nil {
@@ -563,6 +592,9 @@ func CanReachBackend(ctx context.Context) bool {
 	return b.Ping(ctx) == nil
 }
 
+// The directory containing the local clone used by the git sync backend
+const gitClonePath = "git-sync"
+
 // GetSyncBackend returns the sync backend from the context, creating it if necessary.
 // If a backend is already stored in the context, it returns that.
 // Otherwise, it creates a new backend based on the configuration and stores it.
@@ -575,10 +607,77 @@ func GetSyncBackend(ctx context.Context) (backend.SyncBackend, context.Context)
 
 	// Create new backend from config
 	config := hctx.GetConf(ctx)
+	b, err := newSyncBackend(ctx, config)
+	CheckFatalError(err)
+
+	// Store backend in context and return
+	return b, hctx.WithBackend(ctx, b)
+}
+
+// newSyncBackend creates the sync backend configured in config, mirroring to any configured mirror backends.
+// Returns an error if a non-HTTP backend is configured but can't be created.
+func newSyncBackend(ctx context.Context, config *hctx.ClientConfig) (backend.SyncBackend, error) {
+	primary, err := newSingleSyncBackend(ctx, config)
+	if err != nil || len(config.MirrorBackends) == 0 {
+		return primary, err
+	}
+	var mirrors []backend.SyncBackend
+	for _, mirror := range config.MirrorBackends {
+		mirrorConfig := *config
+		applyBackendConfig(mirror, &mirrorConfig)
+		b, err := newSingleSyncBackend(ctx, &mirrorConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mirror backend: %w", err)
+		}
+		mirrors = append(mirrors, b)
+	}
+	multi := backend.NewMultiBackend(primary, mirrors...)
+	multi.OnMissedSubmit(func(i int, entries []*shared.EncHistoryEntry) {
+		if err := recordMissedBackendUpload(config, i, entries); err != nil {
+			hctx.GetLogger().Warnf("failed to record entries that failed to upload: %v", err)
+		}
+	})
+	return multi, nil
+}
+
+// recordMissedBackendUpload marks down that entries failed to upload to the i-th backend of config, so that they
+// are uploaded later. Entries that failed to upload to the primary backend are recorded in the same way as when
+// the device is offline.
+func recordMissedBackendUpload(config *hctx.ClientConfig, i int, entries []*shared.EncHistoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	earliest := entries[0].Date
+	for _, entry := range entries {
+		if entry.Date.Before(earliest) {
+			earliest = entry.Date
+		}
+	}
+	// Subtract a second so that the earliest entry is included when searching for entries after the timestamp
+	timestamp := earliest.UTC().Unix() - 1
+	if i == 0 {
+		if config.HaveMissedUploads && config.MissedUploadTimestamp <= timestamp {
+			return nil
+		}
+		config.HaveMissedUploads = true
+		config.MissedUploadTimestamp = timestamp
+	} else {
+		mirror := &config.MirrorBackends[i-1]
+		if mirror.MissedUploadTimestamp != 0 && mirror.MissedUploadTimestamp <= timestamp {
+			return nil
+		}
+		mirror.MissedUploadTimestamp = timestamp
+	}
+	return hctx.SetConfig(config)
+}
+
+// newSingleSyncBackend creates the sync backend configured in config, ignoring any mirror backends
+func newSingleSyncBackend(ctx context.Context, config *hctx.ClientConfig) (backend.SyncBackend, error) {
 	cfg := backend.Config{
-		BackendType: config.BackendType,
-		Version:     Version,
-		HTTPClient:  GetHttpClient(),
+		BackendType:         config.BackendType,
+		Version:             Version,
+		HTTPClient:          GetHttpClient(),
+		HTTPRequestEncoding: config.ServerRequestEncoding,
 	}
 
 	// Add S3 config if applicable
@@ -588,6 +687,20 @@ func GetSyncBackend(ctx context.Context) (backend.SyncBackend, context.Context)
 		cfg.S3Endpoint = config.S3Config.Endpoint
 		cfg.S3AccessKey = config.S3Config.AccessKeyID
 		cfg.S3Prefix = config.S3Config.Prefix
+		cfg.S3Concurrency = config.S3Config.Concurrency
+		cfg.S3Compression = config.S3Config.Compression
+	}
+	if config.DirConfig != nil {
+		cfg.DirPath = config.DirConfig.Path
+	}
+	if config.WebDAVConfig != nil {
+		cfg.WebDAVURL = config.WebDAVConfig.URL
+		cfg.WebDAVUsername = config.WebDAVConfig.Username
+	}
+	if config.GitConfig != nil {
+		cfg.GitRemote = config.GitConfig.Remote
+		cfg.GitBranch = config.GitConfig.Branch
+		cfg.GitClonePath = filepath.Join(hctx.GetHome(ctx), data.GetHishtoryPath(), gitClonePath)
 	}
 
 	b, err := backend.NewBackendFromConfig(ctx, cfg)
@@ -595,18 +708,25 @@ func GetSyncBackend(ctx context.Context) (backend.SyncBackend, context.Context)
 		// If user explicitly configured a non-HTTP backend, fail loudly rather than
 		// silently falling back to HTTP (which could sync data to unexpected places)
 		if config.BackendType != "" && config.BackendType != "http" {
-			CheckFatalError(fmt.Errorf("failed to create %s backend: %w", config.BackendType, err))
+			return nil, fmt.Errorf("failed to create %s backend: %w", config.BackendType, err)
 		}
 		// For default/HTTP backend, create it directly
 		b = backend.NewHTTPBackend(
 			backend.WithVersion(Version),
 			backend.WithHTTPClient(GetHttpClient()),
 			backend.WithAuth(config.DeviceId, data.UserId(config.UserSecret)),
+			backend.WithRequestEncoding(config.ServerRequestEncoding),
 		)
 	}
+	return b, nil
+}
 
-	// Store backend in context and return
-	return b, hctx.WithBackend(ctx, b)
+// BackendTypeOrDefault returns the configured backend type, where an empty backend type means the HTTP backend.
+func BackendTypeOrDefault(backendType string) string {
+	if backendType == "" {
+		return string(backend.BackendTypeHTTP)
+	}
+	return backendType
 }
 
 func normalizeEntryTimezone(entry data.HistoryEntry) data.HistoryEntry {
@@ -664,6 +784,27 @@ func ReliableDbCreate(db *gorm.DB, entry data.HistoryEntry) error {
 	})
 }
 
+// ReliableDbCreateAll inserts all of the given entries in a single transaction
+func ReliableDbCreateAll(db *gorm.DB, entries []data.HistoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	for i := range entries {
+		entries[i] = normalizeEntryTimezone(entries[i])
+	}
+	return RetryingDbFunction(func() error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			for _, entry := range entries {
+				err := tx.Create(entry).Error
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
 func EncryptAndMarshal(config *hctx.ClientConfig, entries []*data.HistoryEntry) ([]byte, error) {
 	encEntries, err := EncryptEntries(config, entries)
 	if err != nil {
@@ -768,14 +909,46 @@ func RetrieveAdditionalEntriesFromRemote(ctx context.Context, queryReason string
 		return err
 	}
 
+	var controlMessages []*shared.EncHistoryEntry
 	for _, entry := range retrievedEntries {
-		decEntry, err := data.DecryptHistoryEntry(config.UserSecret, *entry)
+		if data.IsEncryptedControlMessage(*entry) {
+			controlMessages = append(controlMessages, entry)
+			continue
+		}
+		err := AddEncryptedEntryToDbIfNew(db, config.UserSecret, *entry)
+		if errors.Is(err, ErrUndecryptableEntry) {
+			hctx.GetLogger().Warnf("RetrieveAdditionalEntriesFromRemote: skipping entry: %v", err)
+			continue
+		}
 		if err != nil {
-			return fmt.Errorf("failed to decrypt history entry from server: %w", err)
+			return err
+		}
+	}
+	err = ProcessDeletionRequests(ctx)
+	if err != nil {
+		return err
+	}
+	handleControlMessages(ctx, controlMessages)
+	return SaveServerRequestEncoding(ctx)
+}
+
+// SaveServerRequestEncoding persists the content encoding that the hishtory server advertised support for, so
+// that the next process can compress its first request without waiting to hear from the server.
+func SaveServerRequestEncoding(ctx context.Context) error {
+	b, _ := GetSyncBackend(ctx)
+	for _, b := range backend.Backends(b) {
+		httpBackend, ok := b.(*backend.HTTPBackend)
+		if !ok {
+			continue
 		}
-		AddToDbIfNew(db, decEntry)
+		config := hctx.GetConf(ctx)
+		if encoding := httpBackend.RequestEncoding(); encoding != config.ServerRequestEncoding {
+			config.ServerRequestEncoding = encoding
+			return hctx.SetConfig(config)
+		}
+		return nil
 	}
-	return ProcessDeletionRequests(ctx)
+	return nil
 }
 
 func ProcessDeletionRequests(ctx context.Context) error {
@@ -943,6 +1116,30 @@ func SearchWithOffset(ctx context.Context, db *gorm.DB, query string, limit, off
 	return retryingSearch(ctx, db, query, limit, offset, 0)
 }
 
+// SearchResultCounts holds the number of history entries that match a search query
+type SearchResultCounts struct {
+	// The number of matching entries
+	Entries int64
+	// The number of distinct commands among the matching entries, ignoring surrounding whitespace. This is the
+	// number of rows shown by the TUI when FilterDuplicateCommands is enabled.
+	DistinctCommands int64
+}
+
+// CountSearchResults returns the total number of history entries that match the given query
+func CountSearchResults(ctx context.Context, db *gorm.DB, query string) (SearchResultCounts, error) {
+	tx, err := MakeWhereQueryFromSearch(ctx, db, query)
+	if err != nil {
+		return SearchResultCounts{}, err
+	}
+	var counts SearchResultCounts
+	// Trim the same ASCII whitespace as strings.TrimSpace so that duplicates are detected the same way as in the TUI
+	result := tx.Select("COUNT(*) AS entries, COUNT(DISTINCT trim(command, ?)) AS distinct_commands", " \t\n\v\f\r").Scan(&counts)
+	if result.Error != nil {
+		return SearchResultCounts{}, fmt.Errorf("DB query error: %w", result.Error)
+	}
+	return counts, nil
+}
+
 const SEARCH_RETRY_COUNT = 3
 
 func retryingSearch(ctx context.Context, db *gorm.DB, query string, limit, offset, currentRetryNum int) ([]*data.HistoryEntry, error) {
@@ -1050,6 +1247,38 @@ func parseAtomizedToken(ctx context.Context, token string) (string, any, any, er
 		return "(CAST(strftime(\"%s\",end_time) AS INTEGER) = ?)", strconv.FormatInt(t.Unix(), 10), nil, nil
 	case "command":
 		return "(instr(command, ?) > 0)", val, nil, nil
+	case "output":
+		return "(instr(output, ?) > 0)", val, nil, nil
+	case "session":
+		if val == "current" {
+			val = os.Getenv("HISHTORY_SESSION_ID")
+			if val == "" {
+				return "", nil, nil, fmt.Errorf("session:current can only be used from a shell with the hishtory integration installed")
+			}
+		}
+		return "(session_id = ?)", val, nil, nil
+	case "repo":
+		return "(instr(git_repo, ?) > 0 OR instr(REPLACE(git_repo, '~/', home_directory), ?) > 0)", strings.TrimSuffix(val, "/"), strings.TrimSuffix(val, "/"), nil
+	case "branch":
+		return "(git_branch = ?)", val, nil, nil
+	case "via":
+		switch val {
+		case "ssh":
+			return "(COALESCE(ssh_client, '') != '')", nil, nil, nil
+		case "local":
+			return "(COALESCE(ssh_client, '') = '')", nil, nil, nil
+		default:
+			return "(ssh_client = ?)", strings.TrimPrefix(val, "ssh:"), nil, nil
+		}
+	case "container":
+		return "(container = ?)", val, nil, nil
+	case "kctx":
+		// Supports both kctx:context and kctx:context/namespace
+		kubeContext, kubeNamespace, hasNamespace := strings.Cut(val, "/")
+		if hasNamespace {
+			return "(kube_context = ? AND kube_namespace = ?)", kubeContext, kubeNamespace, nil
+		}
+		return "(kube_context = ?)", kubeContext, nil, nil
 	default:
 		q, args, err := buildCustomColumnSearchQuery(ctx, field, val)
 		if err != nil {
@@ -0,0 +1,284 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/ddworken/hishtory/client/backend"
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/google/uuid"
+)
+
+// Control messages telling devices to switch backends are ignored once they are this old, so that an old
+// message can't undo a later migration
+const controlMessageMaxAge = 7 * 24 * time.Hour
+
+func getBackendConfig(config *hctx.ClientConfig) hctx.BackendConfig {
+	return hctx.BackendConfig{
+		BackendType:  config.BackendType,
+		S3Config:     config.S3Config,
+		DirConfig:    config.DirConfig,
+		WebDAVConfig: config.WebDAVConfig,
+		GitConfig:    config.GitConfig,
+	}
+}
+
+// applyBackendConfig makes config sync via the backend configured in c
+func applyBackendConfig(c hctx.BackendConfig, config *hctx.ClientConfig) {
+	config.BackendType = c.BackendType
+	config.S3Config = c.S3Config
+	config.DirConfig = c.DirConfig
+	config.WebDAVConfig = c.WebDAVConfig
+	config.GitConfig = c.GitConfig
+}
+
+// isSameBackend returns whether both configs sync via the same backend. Config for other backend types is
+// ignored, since it may be left over from a previous migration.
+func isSameBackend(a, b *hctx.ClientConfig) bool {
+	backendType := BackendTypeOrDefault(a.BackendType)
+	if backendType != BackendTypeOrDefault(b.BackendType) {
+		return false
+	}
+	switch backend.BackendType(backendType) {
+	case backend.BackendTypeS3:
+		// Devices syncing via the same bucket may use different credentials, concurrency and compression
+		return reflect.DeepEqual(s3Location(a.S3Config), s3Location(b.S3Config))
+	case backend.BackendTypeDir:
+		return reflect.DeepEqual(a.DirConfig, b.DirConfig)
+	case backend.BackendTypeWebDAV:
+		return reflect.DeepEqual(a.WebDAVConfig, b.WebDAVConfig)
+	case backend.BackendTypeGit:
+		return reflect.DeepEqual(a.GitConfig, b.GitConfig)
+	default:
+		return true
+	}
+}
+
+// s3Location returns the S3 config fields that identify where history is stored
+func s3Location(c *hctx.S3BackendConfig) *hctx.S3BackendConfig {
+	if c == nil {
+		return nil
+	}
+	return &hctx.S3BackendConfig{Bucket: c.Bucket, Region: c.Region, Endpoint: c.Endpoint, Prefix: c.Prefix}
+}
+
+// MigrateBackend switches the device from its current sync backend to the one configured in newConfig. It
+// registers the device with the new backend, retrieves any entries that other devices already uploaded there,
+// uploads all local entries that are missing from it, and then persists the new config and uninstalls the
+// device from the old backend. If notifyOtherDevices is set, the user's other devices are told to switch too.
+func MigrateBackend(ctx context.Context, newConfig *hctx.ClientConfig, notifyOtherDevices bool) error {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return fmt.Errorf("device is offline, run `hishtory syncing enable` instead")
+	}
+	if isSameBackend(config, newConfig) {
+		return fmt.Errorf("device is already syncing via this %s backend", BackendTypeOrDefault(config.BackendType))
+	}
+	if err := checkMirrorBackends(newConfig); err != nil {
+		return err
+	}
+	oldBackend, ctx := GetSyncBackend(ctx)
+	if err := syncToNewBackend(ctx, newConfig); err != nil {
+		return err
+	}
+
+	// The other devices are notified via the old backend, so this has to happen before uninstalling from it
+	if notifyOtherDevices {
+		if err := sendBackendSwitchMessage(ctx, oldBackend, newConfig); err != nil {
+			return fmt.Errorf("failed to notify other devices: %w", err)
+		}
+	}
+
+	oldBackendType := BackendTypeOrDefault(config.BackendType)
+	applyBackendConfig(getBackendConfig(newConfig), config)
+	if err := hctx.SetConfig(config); err != nil {
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+	// Only the old primary backend is uninstalled, the device keeps syncing via any mirror backends
+	oldPrimary := backend.Backends(oldBackend)[0]
+	if err := oldPrimary.Uninstall(ctx, data.UserId(config.UserSecret), config.DeviceId); err != nil {
+		// The device is already syncing via the new backend, so this is only worth a warning
+		hctx.GetLogger().Warnf("MigrateBackend: failed to uninstall device from the %s backend: %v", oldBackendType, err)
+	}
+	return nil
+}
+
+// syncToNewBackend registers the device with the backend configured in newConfig, adds the entries that other
+// devices already uploaded there to the local DB, and uploads all local entries that are missing from it.
+func syncToNewBackend(ctx context.Context, newConfig *hctx.ClientConfig) error {
+	config := hctx.GetConf(ctx)
+	// Only the new backend itself needs to be seeded, since any mirror backends are already in sync
+	seedConfig := *newConfig
+	seedConfig.MirrorBackends = nil
+	newBackend, err := newSyncBackend(ctx, &seedConfig)
+	if err != nil {
+		return err
+	}
+	newCtx := context.WithValue(ctx, hctx.ConfigCtxKey, &seedConfig)
+	newCtx = hctx.WithBackend(newCtx, newBackend)
+
+	if err := newBackend.Ping(newCtx); err != nil {
+		return fmt.Errorf("failed to reach the %s backend: %w", BackendTypeOrDefault(newConfig.BackendType), err)
+	}
+	userId := data.UserId(config.UserSecret)
+	if err := newBackend.RegisterDevice(newCtx, userId, config.DeviceId); err != nil {
+		return fmt.Errorf("failed to register device with the new backend: %w", err)
+	}
+	existingEntries, err := newBackend.Bootstrap(newCtx, userId, config.DeviceId)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve entries from the new backend: %w", err)
+	}
+	uploaded := make(map[string]bool)
+	for _, entry := range existingEntries {
+		uploaded[entry.EncryptedId] = true
+		err := AddEncryptedEntryToDbIfNew(hctx.GetDb(ctx), config.UserSecret, *entry)
+		if errors.Is(err, ErrUndecryptableEntry) {
+			hctx.GetLogger().Warnf("syncToNewBackend: skipping entry: %v", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return uploadMissingEntries(newCtx, uploaded)
+}
+
+// uploadMissingEntries uploads all local history entries to the backend in ctx, other than those whose IDs are
+// in uploaded
+func uploadMissingEntries(ctx context.Context, uploaded map[string]bool) error {
+	config := hctx.GetConf(ctx)
+	b, ctx := GetSyncBackend(ctx)
+	// See Reupload for how these chunk sizes were chosen
+	searchChunkSize := 300_000
+	uploadChunkSize := 500
+	for offset := 0; ; offset += searchChunkSize {
+		entries, err := SearchWithOffset(ctx, hctx.GetDb(ctx), "", searchChunkSize, offset)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve entries to upload: %w", err)
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		var missing []*data.HistoryEntry
+		for _, entry := range entries {
+			if !uploaded[entry.EntryId] {
+				missing = append(missing, entry)
+			}
+		}
+		err = shared.ForEach(shared.Chunks(missing, uploadChunkSize), 10, func(chunk []*data.HistoryEntry) error {
+			encEntries, err := EncryptEntries(config, chunk)
+			if err != nil {
+				return err
+			}
+			_, err = b.SubmitEntries(ctx, encEntries, config.DeviceId)
+			if err != nil {
+				return fmt.Errorf("failed to upload entries: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sendBackendSwitchMessage tells the user's other devices syncing via b to switch to the backend configured in
+// newConfig
+func sendBackendSwitchMessage(ctx context.Context, b shared.SyncBackend, newConfig *hctx.ClientConfig) error {
+	config := hctx.GetConf(ctx)
+	backendConfigJson, err := json.Marshal(getBackendConfig(newConfig))
+	if err != nil {
+		return err
+	}
+	msg := data.ControlMessage{
+		EntryId:         uuid.Must(uuid.NewRandom()).String(),
+		Timestamp:       time.Now().UTC(),
+		DeviceId:        config.DeviceId,
+		FromBackendType: BackendTypeOrDefault(config.BackendType),
+		BackendConfig:   backendConfigJson,
+	}
+	encMsg, err := data.EncryptControlMessage(config.UserSecret, msg)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt control message: %w", err)
+	}
+	_, err = b.SubmitEntries(ctx, []*shared.EncHistoryEntry{&encMsg}, config.DeviceId)
+	return err
+}
+
+// handleControlMessages acts on control messages retrieved from the sync backend by recording the requested
+// backend switch for ApplyPendingBackendSwitch. Failures are only logged, since the messages are retrieved
+// again on later syncs until they reach the read count limit.
+func handleControlMessages(ctx context.Context, encMsgs []*shared.EncHistoryEntry) {
+	config := hctx.GetConf(ctx)
+	var latest *data.ControlMessage
+	for _, encMsg := range encMsgs {
+		msg, err := data.DecryptControlMessage(config.UserSecret, *encMsg)
+		if err != nil {
+			hctx.GetLogger().Warnf("ignoring invalid control message: %v", err)
+			continue
+		}
+		if msg.DeviceId == config.DeviceId || time.Since(msg.Timestamp) > controlMessageMaxAge {
+			continue
+		}
+		// Only the most recent message is relevant if the user migrated several times
+		if latest == nil || msg.Timestamp.After(latest.Timestamp) {
+			latest = &msg
+		}
+	}
+	if latest == nil || latest.FromBackendType != BackendTypeOrDefault(config.BackendType) {
+		return
+	}
+
+	var target hctx.BackendConfig
+	if err := json.Unmarshal(latest.BackendConfig, &target); err != nil {
+		hctx.GetLogger().Warnf("ignoring control message with invalid backend config: %v", err)
+		return
+	}
+	newConfig := *config
+	applyBackendConfig(target, &newConfig)
+	if isSameBackend(config, &newConfig) {
+		return
+	}
+	if reflect.DeepEqual(config.PendingBackendSwitch, &target) {
+		return
+	}
+	hctx.GetLogger().Infof("device %s requested switching to the %s backend", latest.DeviceId, BackendTypeOrDefault(target.BackendType))
+	config.PendingBackendSwitch = &target
+	if err := hctx.SetConfig(config); err != nil {
+		hctx.GetLogger().Warnf("failed to persist the switch to the %s backend: %v", BackendTypeOrDefault(target.BackendType), err)
+	}
+}
+
+// ApplyPendingBackendSwitch migrates to the backend that another device requested via a control message, if
+// any. Returns a context that syncs via the new backend.
+func ApplyPendingBackendSwitch(ctx context.Context) (context.Context, error) {
+	config := hctx.GetConf(ctx)
+	if config.PendingBackendSwitch == nil {
+		return ctx, nil
+	}
+	newConfig := *config
+	applyBackendConfig(*config.PendingBackendSwitch, &newConfig)
+	// Another command may have migrated in the meantime, in which case there is nothing left to do
+	if !config.IsOffline && !isSameBackend(config, &newConfig) {
+		hctx.GetLogger().Infof("switching to the %s backend as requested by another device", BackendTypeOrDefault(newConfig.BackendType))
+		if err := MigrateBackend(ctx, &newConfig, false); err != nil {
+			return ctx, fmt.Errorf("failed to switch to the %s backend: %w", BackendTypeOrDefault(newConfig.BackendType), err)
+		}
+	}
+	config.PendingBackendSwitch = nil
+	if err := hctx.SetConfig(config); err != nil {
+		return ctx, fmt.Errorf("failed to persist config: %w", err)
+	}
+	b, err := newSyncBackend(ctx, config)
+	if err != nil {
+		return ctx, err
+	}
+	return hctx.WithBackend(ctx, b), nil
+}
@@ -0,0 +1,228 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/backend"
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// checkMirrorBackends checks that config doesn't sync via the same backend twice. The HTTP backend is configured
+// via $HISHTORY_SERVER and the git backend always uses the same local clone, so there can only be one of each.
+func checkMirrorBackends(config *hctx.ClientConfig) error {
+	backendConfigs := append([]hctx.BackendConfig{getBackendConfig(config)}, config.MirrorBackends...)
+	for i, a := range backendConfigs {
+		for _, b := range backendConfigs[:i] {
+			backendType := BackendTypeOrDefault(a.BackendType)
+			if backendType != BackendTypeOrDefault(b.BackendType) {
+				continue
+			}
+			if backendType == string(backend.BackendTypeHTTP) || backendType == string(backend.BackendTypeGit) {
+				return fmt.Errorf("device is already syncing via a %s backend, and only one is supported", backendType)
+			}
+			var configA, configB hctx.ClientConfig
+			applyBackendConfig(a, &configA)
+			applyBackendConfig(b, &configB)
+			if isSameBackend(&configA, &configB) {
+				return fmt.Errorf("device is already syncing via this %s backend", backendType)
+			}
+		}
+	}
+	return nil
+}
+
+// AddMirrorBackend starts mirroring history to the backend configured in mirrorConfig, in addition to the
+// backends the device already syncs via. It registers the device with the mirror, retrieves any entries that
+// other devices already uploaded there, and uploads all local entries that are missing from it.
+func AddMirrorBackend(ctx context.Context, mirrorConfig *hctx.ClientConfig) error {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return fmt.Errorf("device is offline, run `hishtory syncing enable` first")
+	}
+	newConfig := *config
+	newConfig.MirrorBackends = append(slices.Clone(config.MirrorBackends), getBackendConfig(mirrorConfig))
+	if err := checkMirrorBackends(&newConfig); err != nil {
+		return err
+	}
+	if err := syncToNewBackend(ctx, mirrorConfig); err != nil {
+		return err
+	}
+	config.MirrorBackends = newConfig.MirrorBackends
+	if err := hctx.SetConfig(config); err != nil {
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+	return nil
+}
+
+// UploadMissedMirrorEntries uploads the history entries that failed to upload to a mirror backend while
+// syncing kept working via another backend. Mirrors that are still unavailable are retried on the next call.
+func UploadMissedMirrorEntries(ctx context.Context) error {
+	config := hctx.GetConf(ctx)
+	if config.IsOffline {
+		return nil
+	}
+	for i := range config.MirrorBackends {
+		mirror := &config.MirrorBackends[i]
+		if mirror.MissedUploadTimestamp == 0 {
+			continue
+		}
+		mirrorConfig := *config
+		mirrorConfig.MirrorBackends = nil
+		applyBackendConfig(*mirror, &mirrorConfig)
+		b, err := newSyncBackend(ctx, &mirrorConfig)
+		if err != nil {
+			return err
+		}
+		query := fmt.Sprintf("after:%s", time.Unix(mirror.MissedUploadTimestamp, 0).Format("2006-01-02"))
+		entries, err := Search(ctx, hctx.GetDb(ctx), query, 0)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve history entries that haven't been uploaded yet: %w", err)
+		}
+		encEntries, err := EncryptEntries(config, entries)
+		if err != nil {
+			return err
+		}
+		if _, err := b.SubmitEntries(ctx, encEntries, config.DeviceId); err != nil {
+			hctx.GetLogger().Infof("UploadMissedMirrorEntries: the %s backend is still unavailable: %v", DescribeBackend(*mirror), err)
+			continue
+		}
+		mirror.MissedUploadTimestamp = 0
+		if err := hctx.SetConfig(config); err != nil {
+			return fmt.Errorf("failed to mark history entries as uploaded: %w", err)
+		}
+	}
+	return nil
+}
+
+// mirrorMatchesLocation returns whether location identifies mirror. Locations are the S3 bucket (optionally
+// followed by the prefix), the directory, the WebDAV URL, or the git remote.
+func mirrorMatchesLocation(mirror hctx.BackendConfig, location string) bool {
+	switch backend.BackendType(BackendTypeOrDefault(mirror.BackendType)) {
+	case backend.BackendTypeS3:
+		return mirror.S3Config != nil && (location == mirror.S3Config.Bucket || location == path.Join(mirror.S3Config.Bucket, mirror.S3Config.Prefix))
+	case backend.BackendTypeDir:
+		return mirror.DirConfig != nil && filepath.Clean(location) == filepath.Clean(mirror.DirConfig.Path)
+	case backend.BackendTypeWebDAV:
+		return mirror.WebDAVConfig != nil && strings.TrimSuffix(location, "/") == strings.TrimSuffix(mirror.WebDAVConfig.URL, "/")
+	case backend.BackendTypeGit:
+		return mirror.GitConfig != nil && location == mirror.GitConfig.Remote
+	default:
+		return false
+	}
+}
+
+// RemoveMirrorBackend stops mirroring history to the mirror backend of the given type, and uninstalls the device
+// from it. If the device mirrors to several backends of the same type, location selects which one to remove
+// (see mirrorMatchesLocation).
+func RemoveMirrorBackend(ctx context.Context, backendType, location string) error {
+	config := hctx.GetConf(ctx)
+	var removed, kept []hctx.BackendConfig
+	for _, mirror := range config.MirrorBackends {
+		if BackendTypeOrDefault(mirror.BackendType) == backendType && (location == "" || mirrorMatchesLocation(mirror, location)) {
+			removed = append(removed, mirror)
+		} else {
+			kept = append(kept, mirror)
+		}
+	}
+	if len(removed) == 0 {
+		if location != "" {
+			return fmt.Errorf("device isn't mirroring history to a %s backend at %s", backendType, location)
+		}
+		return fmt.Errorf("device isn't mirroring history to a %s backend", backendType)
+	}
+	if len(removed) > 1 {
+		var descriptions []string
+		for _, mirror := range removed {
+			descriptions = append(descriptions, DescribeBackend(mirror))
+		}
+		return fmt.Errorf("device is mirroring history to several %s backends (%s), so specify which one to remove", backendType, strings.Join(descriptions, ", "))
+	}
+	config.MirrorBackends = kept
+	if err := hctx.SetConfig(config); err != nil {
+		return fmt.Errorf("failed to persist config: %w", err)
+	}
+
+	for _, mirror := range removed {
+		mirrorConfig := *config
+		mirrorConfig.MirrorBackends = nil
+		applyBackendConfig(mirror, &mirrorConfig)
+		b, err := newSyncBackend(ctx, &mirrorConfig)
+		if err == nil {
+			err = b.Uninstall(ctx, data.UserId(config.UserSecret), config.DeviceId)
+		}
+		if err != nil {
+			// The device no longer syncs via the mirror, so this is only worth a warning
+			hctx.GetLogger().Warnf("RemoveMirrorBackend: failed to uninstall device from the %s backend: %v", backendType, err)
+		}
+	}
+	return nil
+}
+
+// BackendStatus is the result of checking whether one of the backends that the device syncs via is reachable
+type BackendStatus struct {
+	// A description of the backend, e.g. "s3 (bucket: foo, region: us-east-1)"
+	Description string
+	// Whether the backend is the primary backend rather than a mirror
+	IsPrimary bool
+	// The error from pinging the backend, or nil if it is reachable
+	Err error
+	// How long the ping took
+	Latency time.Duration
+}
+
+// GetBackendStatuses pings each of the backends that the device syncs via. Returns an error if the backends
+// are misconfigured.
+func GetBackendStatuses(ctx context.Context) ([]BackendStatus, error) {
+	config := hctx.GetConf(ctx)
+	b, err := newSyncBackend(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	configs := append([]hctx.BackendConfig{getBackendConfig(config)}, config.MirrorBackends...)
+	backends := backend.Backends(b)
+	statuses := make([]BackendStatus, len(backends))
+	for i, b := range backends {
+		start := time.Now()
+		err := b.Ping(ctx)
+		statuses[i] = BackendStatus{
+			Description: DescribeBackend(configs[i]),
+			IsPrimary:   i == 0,
+			Err:         err,
+			Latency:     time.Since(start),
+		}
+	}
+	return statuses, nil
+}
+
+// DescribeBackend returns a human readable description of the backend configured in c
+func DescribeBackend(c hctx.BackendConfig) string {
+	description := BackendTypeOrDefault(c.BackendType)
+	switch backend.BackendType(description) {
+	case backend.BackendTypeHTTP:
+		description += fmt.Sprintf(" (server: %s)", GetServerHostname())
+	case backend.BackendTypeS3:
+		if c.S3Config != nil {
+			description += fmt.Sprintf(" (bucket: %s, region: %s)", c.S3Config.Bucket, c.S3Config.Region)
+		}
+	case backend.BackendTypeDir:
+		if c.DirConfig != nil {
+			description += fmt.Sprintf(" (path: %s)", c.DirConfig.Path)
+		}
+	case backend.BackendTypeWebDAV:
+		if c.WebDAVConfig != nil {
+			description += fmt.Sprintf(" (url: %s)", c.WebDAVConfig.URL)
+		}
+	case backend.BackendTypeGit:
+		if c.GitConfig != nil {
+			description += fmt.Sprintf(" (remote: %s)", c.GitConfig.Remote)
+		}
+	}
+	return description
+}
@@ -0,0 +1,137 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RemoteOrigin describes where a command was run from, beyond just the hostname
+type RemoteOrigin struct {
+	// The address of the SSH client, if the command was run over SSH
+	SshClient string
+	// The container runtime, if the command was run inside of a container
+	Container string
+	// The active kubectl context and namespace
+	KubeContext   string
+	KubeNamespace string
+}
+
+// GetRemoteOrigin detects whether the current shell is running over SSH, inside of a container, and
+// which kubectl context is active. This is all done in-process since it runs for every recorded command.
+func GetRemoteOrigin(homedir string) (RemoteOrigin, error) {
+	origin := RemoteOrigin{
+		SshClient: parseSshConnection(os.Getenv("SSH_CONNECTION")),
+		Container: detectContainer("/"),
+	}
+	kubeContext, kubeNamespace, err := getKubeContext(getKubeconfigPaths(homedir))
+	if err != nil {
+		return origin, err
+	}
+	origin.KubeContext = kubeContext
+	origin.KubeNamespace = kubeNamespace
+	return origin, nil
+}
+
+// SSH_CONNECTION is of the form "client_ip client_port server_ip server_port"
+func parseSshConnection(sshConnection string) string {
+	fields := strings.Fields(sshConnection)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// Returns the name of the container runtime that root is running inside of, or an empty string
+func detectContainer(root string) string {
+	if _, err := os.Stat(filepath.Join(root, ".dockerenv")); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat(filepath.Join(root, "run/.containerenv")); err == nil {
+		return "podman"
+	}
+	cgroup, err := os.ReadFile(filepath.Join(root, "proc/1/cgroup"))
+	if err != nil {
+		return ""
+	}
+	// Note that kubepods must be checked first, since pods are also run via docker or containerd
+	cgroupMarkers := []struct{ marker, runtime string }{
+		{"kubepods", "kubernetes"},
+		{"docker", "docker"},
+		{"containerd", "containerd"},
+		{"lxc", "lxc"},
+	}
+	for _, m := range cgroupMarkers {
+		if strings.Contains(string(cgroup), m.marker) {
+			return m.runtime
+		}
+	}
+	return ""
+}
+
+func getKubeconfigPaths(homedir string) []string {
+	if kubeconfig := os.Getenv("KUBECONFIG"); kubeconfig != "" {
+		return filepath.SplitList(kubeconfig)
+	}
+	return []string{filepath.Join(homedir, ".kube", "config")}
+}
+
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Contexts       []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Namespace string `yaml:"namespace"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+// Returns the current kubectl context and namespace. Like kubectl, when multiple kubeconfig files are
+// specified the first file to set a value wins.
+func getKubeContext(kubeconfigPaths []string) (string, string, error) {
+	configs := make([]kubeconfig, 0)
+	for _, p := range kubeconfigPaths {
+		if p == "" {
+			continue
+		}
+		contents, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read kubeconfig %#v: %w", p, err)
+		}
+		var config kubeconfig
+		err = yaml.Unmarshal(contents, &config)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to parse kubeconfig %#v: %w", p, err)
+		}
+		configs = append(configs, config)
+	}
+	currentContext := ""
+	for _, config := range configs {
+		if config.CurrentContext != "" {
+			currentContext = config.CurrentContext
+			break
+		}
+	}
+	if currentContext == "" {
+		return "", "", nil
+	}
+	for _, config := range configs {
+		for _, c := range config.Contexts {
+			if c.Name == currentContext {
+				namespace := c.Context.Namespace
+				if namespace == "" {
+					namespace = "default"
+				}
+				return currentContext, namespace, nil
+			}
+		}
+	}
+	return currentContext, "default", nil
+}
@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/shared"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SaveQueryHistory records a query that was run in the TUI so that it can be recalled later. If query
+// history syncing is enabled, it is marked to be uploaded by UploadPendingQueryHistory so that it can be
+// recalled on other devices. The upload is deferred so that the TUI never waits on the network to exit.
+func SaveQueryHistory(ctx context.Context, query string) error {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+	config := hctx.GetConf(ctx)
+	entry := data.QueryHistoryEntry{
+		Query:         query,
+		Timestamp:     time.Now().UTC(),
+		DeviceId:      config.DeviceId,
+		EntryId:       uuid.Must(uuid.NewRandom()).String(),
+		PendingUpload: config.SyncQueryHistory,
+	}
+	db := hctx.GetDb(ctx)
+	err := RetryingDbFunction(func() error {
+		return db.Create(&entry).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save query history: %w", err)
+	}
+	return nil
+}
+
+// UploadPendingQueryHistory uploads queries saved by SaveQueryHistory that haven't been uploaded yet.
+// Failures due to being offline are ignored, and the queries are retried on the next call.
+func UploadPendingQueryHistory(ctx context.Context) error {
+	config := hctx.GetConf(ctx)
+	if !config.SyncQueryHistory || config.IsOffline {
+		return nil
+	}
+	db := hctx.GetDb(ctx)
+	var entries []data.QueryHistoryEntry
+	err := RetryingDbFunction(func() error {
+		return db.Where("pending_upload = ?", true).Find(&entries).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve query history that hasn't been uploaded yet: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	encEntries := make([]*shared.EncHistoryEntry, 0, len(entries))
+	entryIds := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		encEntry, err := data.EncryptQueryHistoryEntry(config.UserSecret, entry)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt query history entry: %w", err)
+		}
+		encEntries = append(encEntries, &encEntry)
+		entryIds = append(entryIds, entry.EntryId)
+	}
+	b, ctx := GetSyncBackend(ctx)
+	_, err = b.SubmitEntries(ctx, encEntries, config.DeviceId)
+	if err != nil {
+		if IsOfflineError(ctx, err) {
+			return nil
+		}
+		return fmt.Errorf("failed to upload query history: %w", err)
+	}
+	return RetryingDbFunction(func() error {
+		return db.Model(&data.QueryHistoryEntry{}).Where("entry_id IN ?", entryIds).Update("pending_upload", false).Error
+	})
+}
+
+// GetQueryHistory returns previously run queries, with the most recently run query first. Each
+// distinct query is only returned once.
+func GetQueryHistory(ctx context.Context, limit int) ([]string, error) {
+	db := hctx.GetDb(ctx)
+	var queries []string
+	err := RetryingDbFunction(func() error {
+		return db.Model(&data.QueryHistoryEntry{}).
+			Select("query").
+			Group("query").
+			Order("MAX(timestamp) DESC").
+			Limit(limit).
+			Pluck("query", &queries).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve query history: %w", err)
+	}
+	return queries, nil
+}
+
+// ErrUndecryptableEntry is returned by AddEncryptedEntryToDbIfNew for entries that can't be decrypted, e.g.
+// because they are corrupt. Callers should skip these rather than failing, so that a single bad entry
+// doesn't block syncing.
+var ErrUndecryptableEntry = errors.New("failed to decrypt entry from the sync backend")
+
+// AddEncryptedEntryToDbIfNew decrypts an entry retrieved from the sync backend and stores it in the
+// local DB. Since query history is synced alongside history entries, this handles both. Control messages
+// are also synced alongside history entries, but aren't stored.
+func AddEncryptedEntryToDbIfNew(db *gorm.DB, userSecret string, entry shared.EncHistoryEntry) error {
+	if data.IsEncryptedControlMessage(entry) {
+		return nil
+	}
+	if data.IsEncryptedQueryHistoryEntry(entry) {
+		decEntry, err := data.DecryptQueryHistoryEntry(userSecret, entry)
+		if err != nil {
+			return fmt.Errorf("%w: query history entry %s: %w", ErrUndecryptableEntry, entry.EncryptedId, err)
+		}
+		return RetryingDbFunction(func() error {
+			var count int64
+			if err := db.Model(&data.QueryHistoryEntry{}).Where("entry_id = ?", decEntry.EntryId).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+			return db.Create(&decEntry).Error
+		})
+	}
+	decEntry, err := data.DecryptHistoryEntry(userSecret, entry)
+	if err != nil {
+		return fmt.Errorf("%w: history entry %s: %w", ErrUndecryptableEntry, entry.EncryptedId, err)
+	}
+	AddToDbIfNew(db, decEntry)
+	return nil
+}
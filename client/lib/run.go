@@ -0,0 +1,244 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// The directory containing the results of `hishtory run` that haven't yet been attached to a history entry
+const runResultsDirPath = ".run"
+
+// TailBuffer is an io.Writer that only retains the last maxBytes bytes written to it
+type TailBuffer struct {
+	mu        sync.Mutex
+	maxBytes  int
+	buf       []byte
+	truncated bool
+}
+
+func NewTailBuffer(maxBytes int) *TailBuffer {
+	return &TailBuffer{maxBytes: maxBytes}
+}
+
+func (t *TailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.maxBytes {
+		t.buf = t.buf[len(t.buf)-t.maxBytes:]
+		t.truncated = true
+	}
+	return len(p), nil
+}
+
+// String returns the retained output. If earlier output was dropped, the output starts at a rune boundary.
+func (t *TailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b := t.buf
+	if t.truncated {
+		for len(b) > 0 && !utf8.RuneStart(b[0]) {
+			b = b[1:]
+		}
+	}
+	return strings.ToValidUTF8(string(b), "")
+}
+
+// RunResult describes a command that was run via `hishtory run`
+type RunResult struct {
+	StartTime time.Time `json:"start_time"`
+	ExitCode  int       `json:"exit_code"`
+	// The tail of the combined stdout and stderr
+	Output   string        `json:"output"`
+	WallTime time.Duration `json:"wall_time"`
+	// The total user and system CPU time of the command and its children
+	CpuTime  time.Duration `json:"cpu_time"`
+	MaxRssKb int64         `json:"max_rss_kb"`
+}
+
+// RunCommand runs the given command with the current stdin, streaming its output to stdout and stderr while
+// retaining the last maxOutputBytes of it. Returns an error if the command couldn't be started. Note that the
+// command's stdout and stderr are pipes rather than the terminal, so interactive commands may not work.
+func RunCommand(args []string, stdout, stderr io.Writer, maxOutputBytes int) (RunResult, error) {
+	if len(args) == 0 {
+		return RunResult{}, fmt.Errorf("no command specified")
+	}
+	tail := NewTailBuffer(maxOutputBytes)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = io.MultiWriter(stdout, tail)
+	cmd.Stderr = io.MultiWriter(stderr, tail)
+
+	// The command receives signals from the terminal (e.g. for Ctrl+C) directly, so ignore them here so
+	// that the command is still recorded after it is interrupted
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	start := time.Now()
+	err := cmd.Start()
+	if err != nil {
+		return RunResult{}, err
+	}
+	err = cmd.Wait()
+	result := RunResult{
+		StartTime: start.UTC(),
+		WallTime:  time.Since(start),
+		Output:    tail.String(),
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return result, err
+	}
+	result.ExitCode = getExitCode(cmd.ProcessState)
+	if rusage, ok := cmd.ProcessState.SysUsage().(*syscall.Rusage); ok {
+		result.CpuTime = time.Duration(rusage.Utime.Nano() + rusage.Stime.Nano())
+		result.MaxRssKb = int64(rusage.Maxrss)
+		if runtime.GOOS == "darwin" {
+			// macOS reports the max RSS in bytes rather than kilobytes
+			result.MaxRssKb /= 1024
+		}
+	}
+	return result, nil
+}
+
+func getRunResultsDir(homedir string) string {
+	return path.Join(homedir, data.GetHishtoryPath(), runResultsDirPath)
+}
+
+// SaveRunResult stores the result of `hishtory run` so that it can be attached to the history entry for the
+// command line that it was run from, once that command line finishes
+func SaveRunResult(homedir, sessionId string, result RunResult) error {
+	if sessionId == "" || path.Base(sessionId) != sessionId || strings.HasPrefix(sessionId, ".") {
+		return fmt.Errorf("invalid session ID %#v", sessionId)
+	}
+	dir := getRunResultsDir(homedir)
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create run results directory: %w", err)
+	}
+	contents, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	// Each process writes its own file since multiple commands can be run from one command line (e.g. in a
+	// pipeline), and the file is renamed into place so that it is never read while partially written
+	resultPath := path.Join(dir, fmt.Sprintf("%s.%d.json", sessionId, os.Getpid()))
+	tmpPath := resultPath + ".tmp"
+	err = os.WriteFile(tmpPath, contents, 0o600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, resultPath)
+}
+
+// ConsumeRunResults returns and deletes all of the stored results for the given session, ordered by start time
+func ConsumeRunResults(homedir, sessionId string) ([]RunResult, error) {
+	if sessionId == "" || path.Base(sessionId) != sessionId || strings.HasPrefix(sessionId, ".") {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(path.Join(getRunResultsDir(homedir), sessionId+".*.json"))
+	if err != nil {
+		return nil, err
+	}
+	results := make([]RunResult, 0, len(matches))
+	for _, m := range matches {
+		contents, err := os.ReadFile(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read run result: %w", err)
+		}
+		err = os.Remove(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove run result: %w", err)
+		}
+		var result RunResult
+		err = json.Unmarshal(contents, &result)
+		if err != nil {
+			hctx.GetLogger().Warnf("skipping malformed run result %#v: %v", m, err)
+			continue
+		}
+		results = append(results, result)
+	}
+	slices.SortFunc(results, func(a, b RunResult) int { return a.StartTime.Compare(b.StartTime) })
+	return results, nil
+}
+
+// MergeRunResults combines the results of every command that was run via `hishtory run` from a single command
+// line. Results that started before since are ignored since they're left over from an earlier command line.
+// Returns nil if there are no results.
+func MergeRunResults(results []RunResult, since time.Time, maxOutputBytes int) *RunResult {
+	var merged *RunResult
+	var end time.Time
+	output := NewTailBuffer(maxOutputBytes)
+	for _, r := range results {
+		if r.StartTime.Before(since) {
+			continue
+		}
+		if merged == nil {
+			merged = &RunResult{StartTime: r.StartTime}
+		}
+		merged.ExitCode = r.ExitCode
+		merged.CpuTime += r.CpuTime
+		merged.MaxRssKb = max(merged.MaxRssKb, r.MaxRssKb)
+		if rEnd := r.StartTime.Add(r.WallTime); rEnd.After(end) {
+			end = rEnd
+		}
+		output.Write([]byte(r.Output))
+	}
+	if merged == nil {
+		return nil
+	}
+	merged.WallTime = end.Sub(merged.StartTime)
+	merged.Output = output.String()
+	return merged
+}
+
+// Returns the exit code in the same way that shells report it, including for commands killed by a signal
+func getExitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// FormatMaxRss formats a max RSS in kilobytes for display
+func FormatMaxRss(kb int64) string {
+	switch {
+	case kb >= 1024*1024:
+		return fmt.Sprintf("%.1f GiB", float64(kb)/(1024*1024))
+	case kb >= 1024:
+		return fmt.Sprintf("%.1f MiB", float64(kb)/1024)
+	default:
+		return fmt.Sprintf("%d KiB", kb)
+	}
+}
+
+// ShellQuoteArgs joins the given arguments into a command that could be pasted into a shell
+func ShellQuoteArgs(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,+@%") == "" {
+			quoted = append(quoted, arg)
+		} else {
+			quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+		}
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/ddworken/hishtory/client/hctx"
+)
+
+// The policies for handling commands that contain secrets
+var SUPPORTED_SECRET_POLICIES = []string{"mask", "skip", "off"}
+
+// The replacement for secrets when the secret policy is "mask"
+const SECRET_MASK = "[REDACTED]"
+
+type secretRule struct {
+	name  string
+	regex *regexp.Regexp
+}
+
+// The built-in secret detection rules. If a rule contains a capture group, only the first group is
+// treated as the secret so that the surrounding context (e.g. the flag name) is preserved.
+var builtinSecretRules = []secretRule{
+	{"aws-access-key-id", regexp.MustCompile(`\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`)},
+	{"aws-secret-access-key", regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[=:]\s*["']?([A-Za-z0-9/+=]{40})`)},
+	{"github-token", regexp.MustCompile(`\b((?:ghp|gho|ghu|ghs|ghr)_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+	{"jwt", regexp.MustCompile(`\b(eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+)`)},
+	{"password-flag", regexp.MustCompile(`(?i)--(?:password|passwd|token|secret|api-key|apikey)(?:=|\s+)("[^"]*"|'[^']*'|[^\s"']+)`)},
+	{"url-credentials", regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`)},
+}
+
+// ValidateSecretPattern checks that a user-provided secret pattern is a valid regex
+func ValidateSecretPattern(pattern string) error {
+	_, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid secret pattern %#v: %w", pattern, err)
+	}
+	return nil
+}
+
+func ValidateSecretPolicy(policy string) error {
+	if !slices.Contains(SUPPORTED_SECRET_POLICIES, policy) {
+		return fmt.Errorf("unsupported secret policy %#v, must be one of: %s", policy, strings.Join(SUPPORTED_SECRET_POLICIES, ", "))
+	}
+	return nil
+}
+
+func getSecretRules(ctx context.Context) ([]secretRule, error) {
+	rules := slices.Clone(builtinSecretRules)
+	for _, pattern := range hctx.GetConf(ctx).SecretPatterns {
+		r, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %#v: %w", pattern, err)
+		}
+		rules = append(rules, secretRule{"custom", r})
+	}
+	return rules, nil
+}
+
+// MaskSecrets returns the command with every detected secret replaced by SECRET_MASK, along with
+// the names of the rules that matched.
+func MaskSecrets(ctx context.Context, command string) (string, []string, error) {
+	rules, err := getSecretRules(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	matchedRules := make([]string, 0)
+	for _, rule := range rules {
+		matches := rule.regex.FindAllStringSubmatchIndex(command, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		matchedRules = append(matchedRules, rule.name)
+		// Replace from the end so that earlier indices remain valid
+		for i := len(matches) - 1; i >= 0; i-- {
+			start, end := matches[i][0], matches[i][1]
+			if len(matches[i]) >= 4 && matches[i][2] >= 0 {
+				start, end = matches[i][2], matches[i][3]
+			}
+			command = command[:start] + SECRET_MASK + command[end:]
+		}
+	}
+	return command, matchedRules, nil
+}
+
+// ApplySecretPolicy scans the command for secrets and applies the configured secret policy. Returns the
+// command that should be recorded, and whether the command should instead be skipped entirely.
+func ApplySecretPolicy(ctx context.Context, command string) (string, bool, error) {
+	policy := hctx.GetConf(ctx).SecretPolicy
+	if policy == "" || policy == "off" {
+		return command, false, nil
+	}
+	masked, matchedRules, err := MaskSecrets(ctx, command)
+	if err != nil {
+		return "", false, err
+	}
+	if len(matchedRules) == 0 {
+		return command, false, nil
+	}
+	hctx.GetLogger().Infof("Detected secrets in a command (rules=%v), applying secret policy %#v", matchedRules, policy)
+	if policy == "skip" {
+		return "", true, nil
+	}
+	return masked, false, nil
+}
+
+// ApplySecretPolicyToOutput scans the output of a command for secrets. Secrets are masked for the "mask"
+// policy, and the output is dropped entirely for the "skip" policy.
+func ApplySecretPolicyToOutput(ctx context.Context, output string) (string, error) {
+	policy := hctx.GetConf(ctx).SecretPolicy
+	if policy == "" || policy == "off" {
+		return output, nil
+	}
+	masked, matchedRules, err := MaskSecrets(ctx, output)
+	if err != nil {
+		return "", err
+	}
+	if len(matchedRules) == 0 {
+		return output, nil
+	}
+	hctx.GetLogger().Infof("Detected secrets in the output of a command (rules=%v), applying secret policy %#v", matchedRules, policy)
+	if policy == "skip" {
+		return "", nil
+	}
+	return masked, nil
+}
@@ -38,6 +38,24 @@ type Model struct {
 	hcol    int
 	hstep   int
 	hcursor int
+
+	// The maximum number of lines to render for rows other than the selected row. If zero,
+	// every row is rendered on a single line.
+	maxLinesPerRow int
+	// The line of the viewport that the selected row starts on. Only used when rows may
+	// span multiple lines.
+	cursorScreenLine int
+
+	// When the cursor moves to within this many rows of the last row, a NearEndMsg is sent so
+	// that additional rows can be loaded. If zero, no NearEndMsg is ever sent.
+	loadMoreThreshold int
+}
+
+// NearEndMsg is sent when the cursor moves close to the last row of the table, so that the
+// caller can lazily load additional rows.
+type NearEndMsg struct {
+	// The number of rows currently in the table
+	NumRows int
 }
 
 // CellPosition holds row and column indexes.
@@ -54,6 +72,10 @@ type Row []string
 type Column struct {
 	Title string
 	Width int
+	// The horizontal alignment of values in the column. Defaults to lipgloss.Left.
+	Align lipgloss.Position
+	// Truncates values that are wider than the column. If nil, values are truncated on the right.
+	Truncate func(value string, width int) string
 }
 
 // KeyMap defines keybindings. It satisfies to the help.KeyMap interface, which
@@ -244,6 +266,23 @@ func WithKeyMap(km KeyMap) Option {
 	}
 }
 
+// WithMaxLinesPerRow enables rendering cells that contain newlines across multiple lines.
+// Unselected rows are limited to n lines, while the selected row is expanded to show the
+// full value.
+func WithMaxLinesPerRow(n int) Option {
+	return func(m *Model) {
+		m.maxLinesPerRow = n
+	}
+}
+
+// WithLoadMoreThreshold enables sending a NearEndMsg whenever the cursor moves to within n
+// rows of the end of the table.
+func WithLoadMoreThreshold(n int) Option {
+	return func(m *Model) {
+		m.loadMoreThreshold = n
+	}
+}
+
 // Update is the Bubble Tea update loop.
 func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 	if !m.focus {
@@ -254,6 +293,7 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		prevCursor := m.cursor
 		switch {
 		case key.Matches(msg, m.KeyMap.LineUp):
 			m.MoveUp(1)
@@ -278,6 +318,12 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 		case key.Matches(msg, m.KeyMap.MoveRight):
 			m.MoveRight(m.hstep)
 		}
+		if m.loadMoreThreshold > 0 && m.cursor != prevCursor && len(m.rows)-m.cursor <= m.loadMoreThreshold {
+			numRows := len(m.rows)
+			cmds = append(cmds, func() tea.Msg {
+				return NearEndMsg{NumRows: numRows}
+			})
+		}
 	}
 
 	return m, tea.Batch(cmds...)
@@ -320,13 +366,49 @@ func (m *Model) UpdateViewport() {
 		m.start = 0
 	}
 	m.end = clamp(m.cursor+m.viewport.Height, m.cursor, len(m.rows))
+	cursorTop := 0
+	cursorHeight := 1
 	for i := m.start; i < m.end; i++ {
-		renderedRows = append(renderedRows, m.renderRow(i))
+		renderedRow := m.renderRow(i)
+		if i < m.cursor {
+			cursorTop += lipgloss.Height(renderedRow)
+		} else if i == m.cursor {
+			cursorHeight = lipgloss.Height(renderedRow)
+		}
+		renderedRows = append(renderedRows, renderedRow)
 	}
 
 	m.viewport.SetContent(
 		lipgloss.JoinVertical(lipgloss.Left, renderedRows...),
 	)
+
+	if m.isMultiLine() {
+		// Rows have variable heights, so position the viewport based on the rendered height of
+		// the rows rather than on the row indexes. The selected row keeps its position on the
+		// screen where possible, and is always fully visible if it fits.
+		m.cursorScreenLine = clamp(m.cursorScreenLine, 0, max(m.viewport.Height-cursorHeight, 0))
+		m.cursorScreenLine = min(m.cursorScreenLine, cursorTop)
+		m.viewport.SetYOffset(cursorTop - m.cursorScreenLine)
+	}
+}
+
+func (m *Model) isMultiLine() bool {
+	return m.maxLinesPerRow > 0
+}
+
+// Returns the number of lines that the given row will be rendered with
+func (m *Model) rowHeight(rowID int, isRowSelected bool) int {
+	if !m.isMultiLine() {
+		return 1
+	}
+	height := 1
+	for _, value := range m.rows[rowID] {
+		height = max(height, strings.Count(value, "\n")+1)
+	}
+	if isRowSelected {
+		return min(height, max(m.viewport.Height, 1))
+	}
+	return min(height, m.maxLinesPerRow)
 }
 
 // SelectedRow returns the selected row.
@@ -443,7 +525,7 @@ func (m *Model) MaxHScroll() int {
 	index := m.ColIndex(m.hcol)
 	for _, row := range m.rows {
 		for _, value := range row {
-			maxWidth = max(RuneWidthWithCache(value), maxWidth)
+			maxWidth = max(cellWidth(value), maxWidth)
 		}
 	}
 	return max(maxWidth-m.cols[index].Width+2, 0)
@@ -485,6 +567,15 @@ func (m *Model) SetCursor(n int) {
 // MoveUp moves the selection up by any number of row.
 // It can not go above the first row.
 func (m *Model) MoveUp(n int) {
+	if m.isMultiLine() {
+		newCursor := clamp(m.cursor-n, 0, len(m.rows)-1)
+		for i := newCursor; i < m.cursor; i++ {
+			m.cursorScreenLine -= m.rowHeight(i, false)
+		}
+		m.cursor = newCursor
+		m.UpdateViewport()
+		return
+	}
 	m.cursor = clamp(m.cursor-n, 0, len(m.rows)-1)
 	switch {
 	case m.start == 0:
@@ -500,6 +591,15 @@ func (m *Model) MoveUp(n int) {
 // MoveDown moves the selection down by any number of row.
 // It can not go below the last row.
 func (m *Model) MoveDown(n int) {
+	if m.isMultiLine() {
+		newCursor := clamp(m.cursor+n, 0, len(m.rows)-1)
+		for i := max(m.cursor, 0); i < newCursor; i++ {
+			m.cursorScreenLine += m.rowHeight(i, false)
+		}
+		m.cursor = newCursor
+		m.UpdateViewport()
+		return
+	}
 	m.cursor = clamp(m.cursor+n, 0, len(m.rows)-1)
 	m.UpdateViewport()
 
@@ -555,7 +655,7 @@ func (m *Model) FromValues(value, separator string) {
 func (m Model) headersView() string {
 	s := make([]string, 0, len(m.cols))
 	for _, col := range m.cols {
-		style := lipgloss.NewStyle().Width(col.Width).MaxWidth(col.Width).Inline(true)
+		style := lipgloss.NewStyle().Width(col.Width).MaxWidth(col.Width).Inline(true).Align(col.Align)
 		renderedCell := style.Render(RuneTruncateWithCache(col.Title, col.Width, "…"))
 		s = append(s, m.styles.Header.Render(renderedCell))
 	}
@@ -565,7 +665,7 @@ func (m Model) headersView() string {
 func (m *Model) columnNeedsScrolling(columnIdxToCheck int) bool {
 	for rowIdx := m.start; rowIdx < m.end; rowIdx++ {
 		for columnIdx, value := range m.rows[rowIdx] {
-			if columnIdx == columnIdxToCheck && RuneWidthWithCache(value) > m.cols[columnIdx].Width {
+			if columnIdx == columnIdxToCheck && cellWidth(value) > m.cols[columnIdx].Width {
 				return true
 			}
 		}
@@ -575,27 +675,37 @@ func (m *Model) columnNeedsScrolling(columnIdxToCheck int) bool {
 
 func (m *Model) renderRow(rowID int) string {
 	isRowSelected := rowID == m.cursor
+	height := m.rowHeight(rowID, isRowSelected)
 	s := make([]string, 0, len(m.cols))
 	for i, value := range m.rows[rowID] {
-		style := lipgloss.NewStyle().Width(m.cols[i].Width).MaxWidth(m.cols[i].Width).Inline(true)
-
 		position := CellPosition{
 			RowID:         rowID,
 			Column:        i,
 			IsRowSelected: isRowSelected,
 		}
 
-		var renderedCell string
-		if m.columnNeedsScrolling(i) && m.hcursor > 0 {
-			renderedCell = style.Render(RuneTruncateWithCache(runewidth.TruncateLeft(value, m.hcursor, "…"), m.cols[i].Width, "…"))
-		} else {
-			renderedCell = style.Render(RuneTruncateWithCache(value, m.cols[i].Width, "…"))
+		if !m.isMultiLine() {
+			s = append(s, m.renderCellLine(value, i, position))
+			continue
+		}
+
+		lines := strings.Split(value, "\n")
+		if len(lines) > height {
+			lines = lines[:height]
+			lines[height-1] += "…"
+		}
+		renderedLines := make([]string, 0, height)
+		for lineIdx := 0; lineIdx < height; lineIdx++ {
+			line := ""
+			if lineIdx < len(lines) {
+				line = lines[lineIdx]
+			}
+			renderedLines = append(renderedLines, m.renderCellLine(line, i, position))
 		}
-		renderedCell = m.styles.renderCell(*m, renderedCell, position)
-		s = append(s, renderedCell)
+		s = append(s, lipgloss.JoinVertical(lipgloss.Left, renderedLines...))
 	}
 
-	row := lipgloss.JoinHorizontal(lipgloss.Left, s...)
+	row := lipgloss.JoinHorizontal(lipgloss.Top, s...)
 
 	if isRowSelected {
 		return m.styles.Selected.Render(row)
@@ -604,6 +714,35 @@ func (m *Model) renderRow(rowID int) string {
 	return row
 }
 
+// Renders a single line of the cell in the given column
+func (m *Model) renderCellLine(value string, columnIdx int, position CellPosition) string {
+	col := m.cols[columnIdx]
+	width := col.Width
+	style := lipgloss.NewStyle().Width(width).MaxWidth(width).Inline(true).Align(col.Align)
+
+	var renderedCell string
+	if m.columnNeedsScrolling(columnIdx) && m.hcursor > 0 {
+		renderedCell = style.Render(RuneTruncateWithCache(runewidth.TruncateLeft(value, m.hcursor, "…"), width, "…"))
+	} else if col.Truncate != nil {
+		renderedCell = style.Render(col.Truncate(value, width))
+	} else {
+		renderedCell = style.Render(RuneTruncateWithCache(value, width, "…"))
+	}
+	return m.styles.renderCell(*m, renderedCell, position)
+}
+
+// Returns the display width of a cell, which is the width of its widest line
+func cellWidth(value string) int {
+	if !strings.Contains(value, "\n") {
+		return RuneWidthWithCache(value)
+	}
+	width := 0
+	for _, line := range strings.Split(value, "\n") {
+		width = max(width, RuneWidthWithCache(line))
+	}
+	return width
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -3,9 +3,13 @@
 package table
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/ddworken/hishtory/shared/testutils"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 )
 
 func TestFromValues(t *testing.T) {
@@ -65,6 +69,86 @@ func TestHScoll(t *testing.T) {
 	testutils.CompareGoldens(t, table.View(), "unittestTable-truncatedTable-right2")
 }
 
+func TestMultiLineRows(t *testing.T) {
+	table := New(
+		WithColumns([]Column{{Title: "Column1", Width: 10}, {Title: "Column2", Width: 20}}),
+		WithRows([]Row{
+			{"a1", "for i in 1 2 3\ndo\n    echo $i\ndone"},
+			{"b1", "b23"},
+			{"c1", "if true\nthen\n    echo yes\nfi"},
+		}),
+		WithHeight(10),
+		WithMaxLinesPerRow(2),
+	)
+	testutils.CompareGoldens(t, table.View(), "unittestTable-multiLine")
+	table.MoveDown(1)
+	testutils.CompareGoldens(t, table.View(), "unittestTable-multiLine-down1")
+	table.MoveDown(1)
+	testutils.CompareGoldens(t, table.View(), "unittestTable-multiLine-down2")
+	table.MoveUp(2)
+	testutils.CompareGoldens(t, table.View(), "unittestTable-multiLine")
+}
+
+func TestColumnAlignmentAndTruncation(t *testing.T) {
+	truncateLeft := func(value string, width int) string {
+		if len(value) <= width {
+			return value
+		}
+		return "…" + value[len(value)-width+1:]
+	}
+	table := New(
+		WithColumns([]Column{
+			{Title: "Exit Code", Width: 10, Align: lipgloss.Right},
+			{Title: "CWD", Width: 10, Truncate: truncateLeft},
+			{Title: "Host", Width: 10, Align: lipgloss.Center},
+		}),
+		WithRows([]Row{
+			{"0", "/home/user/src/project", "laptop"},
+			{"127", "~/", "server"},
+		}),
+		WithHeight(5),
+	)
+	testutils.CompareGoldens(t, table.View(), "unittestTable-alignmentAndTruncation")
+}
+
+func TestNearEndMsg(t *testing.T) {
+	rows := make([]Row, 0)
+	for i := 0; i < 10; i++ {
+		rows = append(rows, Row{fmt.Sprintf("row%d", i)})
+	}
+	table := New(
+		WithColumns([]Column{{Title: "Column1", Width: 10}}),
+		WithRows(rows),
+		WithFocused(true),
+		WithLoadMoreThreshold(3),
+	)
+	down := tea.KeyMsg{Type: tea.KeyDown}
+
+	// Far from the end of the table, so no additional rows are requested
+	for i := 0; i < 6; i++ {
+		var cmd tea.Cmd
+		table, cmd = table.Update(down)
+		if cmd != nil {
+			if _, ok := cmd().(NearEndMsg); ok {
+				t.Fatalf("unexpected NearEndMsg at cursor=%d", table.Cursor())
+			}
+		}
+	}
+
+	// And once we get close to the end, additional rows are requested
+	table, cmd := table.Update(down)
+	if table.Cursor() != 7 {
+		t.Fatalf("expected cursor to be at 7, got %d", table.Cursor())
+	}
+	if cmd == nil {
+		t.Fatal("expected a NearEndMsg to be sent")
+	}
+	msg, ok := cmd().(NearEndMsg)
+	if !ok || msg.NumRows != 10 {
+		t.Fatalf("expected a NearEndMsg for 10 rows, got %#v", msg)
+	}
+}
+
 func deepEqual(a, b []Row) bool {
 	if len(a) != len(b) {
 		return false
@@ -391,9 +391,8 @@ func configureS3Backend(t testing.TB) {
 	// Add bootstrapped entries to the local database
 	db := hctx.GetDb(ctx)
 	for _, entry := range retrievedEntries {
-		decEntry, err := data.DecryptHistoryEntry(config.UserSecret, *entry)
+		err := lib.AddEncryptedEntryToDbIfNew(db, config.UserSecret, *entry)
 		require.NoError(t, err, "failed to decrypt history entry from S3")
-		lib.AddToDbIfNew(db, decEntry)
 	}
 }
 
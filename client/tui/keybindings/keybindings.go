@@ -25,6 +25,9 @@ type SerializableKeyMap struct {
 	JumpEndOfInput          []string
 	WordLeft                []string
 	WordRight               []string
+	PreviousQuery           []string
+	NextQuery               []string
+	Inspect                 []string
 }
 
 func prettifyKeyBinding(kb string) string {
@@ -126,6 +129,18 @@ func (s SerializableKeyMap) ToKeyMap() KeyMap {
 			key.WithKeys(s.WordRight...),
 			key.WithHelp(prettifyKeyBinding(s.WordRight[0]), "jump right one word "),
 		),
+		PreviousQuery: key.NewBinding(
+			key.WithKeys(s.PreviousQuery...),
+			key.WithHelp(prettifyKeyBinding(s.PreviousQuery[0]), "recall the previous search query "),
+		),
+		NextQuery: key.NewBinding(
+			key.WithKeys(s.NextQuery...),
+			key.WithHelp(prettifyKeyBinding(s.NextQuery[0]), "recall the next search query "),
+		),
+		Inspect: key.NewBinding(
+			key.WithKeys(s.Inspect...),
+			key.WithHelp(prettifyKeyBinding(s.Inspect[0]), "inspect the highlighted entry "),
+		),
 	}
 }
 
@@ -181,6 +196,15 @@ func (s SerializableKeyMap) WithDefaults() SerializableKeyMap {
 	if len(s.WordRight) == 0 {
 		s.WordRight = DefaultKeyMap.WordRight.Keys()
 	}
+	if len(s.PreviousQuery) == 0 {
+		s.PreviousQuery = DefaultKeyMap.PreviousQuery.Keys()
+	}
+	if len(s.NextQuery) == 0 {
+		s.NextQuery = DefaultKeyMap.NextQuery.Keys()
+	}
+	if len(s.Inspect) == 0 {
+		s.Inspect = DefaultKeyMap.Inspect.Keys()
+	}
 	return s
 }
 
@@ -202,6 +226,9 @@ type KeyMap struct {
 	JumpEndOfInput          key.Binding
 	WordLeft                key.Binding
 	WordRight               key.Binding
+	PreviousQuery           key.Binding
+	NextQuery               key.Binding
+	Inspect                 key.Binding
 }
 
 func (k KeyMap) ToSerializable() SerializableKeyMap {
@@ -223,6 +250,9 @@ func (k KeyMap) ToSerializable() SerializableKeyMap {
 		JumpEndOfInput:          k.JumpEndOfInput.Keys(),
 		WordLeft:                k.WordLeft.Keys(),
 		WordRight:               k.WordRight.Keys(),
+		PreviousQuery:           k.PreviousQuery.Keys(),
+		NextQuery:               k.NextQuery.Keys(),
+		Inspect:                 k.Inspect.Keys(),
 	}
 }
 
@@ -323,4 +353,16 @@ var DefaultKeyMap = KeyMap{
 		key.WithKeys("ctrl+right"),
 		key.WithHelp("ctrl+right", "jump right one word "),
 	),
+	PreviousQuery: key.NewBinding(
+		key.WithKeys("alt+up"),
+		key.WithHelp("alt+↑ ", "recall the previous search query "),
+	),
+	NextQuery: key.NewBinding(
+		key.WithKeys("alt+down"),
+		key.WithHelp("alt+↓ ", "recall the next search query "),
+	),
+	Inspect: key.NewBinding(
+		key.WithKeys("ctrl+o"),
+		key.WithHelp("ctrl+o", "inspect the highlighted entry "),
+	),
 }
@@ -8,9 +8,11 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/ddworken/hishtory/client/ai"
 	"github.com/ddworken/hishtory/client/data"
@@ -26,6 +28,7 @@ import (
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-runewidth"
 	"github.com/muesli/termenv"
 	"golang.org/x/term"
 )
@@ -74,6 +77,8 @@ type model struct {
 	table *table.Model
 	// The entries in the table
 	tableEntries []*data.HistoryEntry
+	// Whether the inspector for the highlighted entry is displayed in place of the table.
+	inspecting bool
 	// Whether the user has hit enter to select an entry and the TUI is thus about to quit.
 	selected SelectStatus
 
@@ -99,6 +104,22 @@ type model struct {
 
 	// Whether we've finished the first load of results. If we haven't, we refuse to run additional queries to avoid race conditions with how we handle invalid initial queries.
 	hasFinishedFirstLoad bool
+
+	// The ID of the query that populated the table, used to discard pages of results for outdated queries.
+	tableQueryId int
+	// The full search query (including the default filter) that populated the table.
+	tableSearchQuery string
+	// The number of search results that have been loaded so far. Used as the offset when loading the next page of results.
+	searchOffset int
+	// The total number of entries matching the current query, or -1 if it is unknown (e.g. for AI suggestions).
+	totalMatches lib.SearchResultCounts
+	// Whether an additional page of results is currently being loaded.
+	isLoadingMore bool
+
+	// Previously run search queries, most recent first. Nil until the first time a query is recalled.
+	queryHistory []string
+	// The index into queryHistory of the currently recalled query, or -1 if no query has been recalled.
+	queryHistoryIdx int
 }
 
 type (
@@ -125,6 +146,24 @@ type asyncQueryFinishedMsg struct {
 	overriddenSearchQuery *string
 
 	isFirstQuery bool
+
+	// The full search query that was run and the number of results that were requested, used for loading further pages
+	searchQuery string
+	numEntries  int
+	// The total number of entries matching the query, or -1 if unknown
+	totalMatches lib.SearchResultCounts
+}
+
+type asyncPageLoadedMsg struct {
+	// The ID of the query that this is an additional page of results for
+	queryId int
+	// The additional table rows and entries
+	rows    []table.Row
+	entries []*data.HistoryEntry
+	// The number of search results that were requested for this page
+	numEntries int
+	// An error from searching, if one occurred
+	searchErr error
 }
 
 func initialModel(ctx context.Context, shellName, initialQuery string) model {
@@ -154,7 +193,7 @@ func initialModel(ctx context.Context, shellName, initialQuery string) model {
 		queryInput.SetValue(initialQuery)
 	}
 	CURRENT_QUERY_FOR_HIGHLIGHTING = initialQuery
-	return model{ctx: ctx, spinner: s, isLoading: true, table: nil, tableEntries: []*data.HistoryEntry{}, runQuery: &initialQuery, queryInput: queryInput, help: help.New(), shellName: shellName, hasFinishedFirstLoad: false}
+	return model{ctx: ctx, spinner: s, isLoading: true, table: nil, tableEntries: []*data.HistoryEntry{}, runQuery: &initialQuery, queryInput: queryInput, help: help.New(), shellName: shellName, hasFinishedFirstLoad: false, totalMatches: unknownSearchResultCounts, queryHistoryIdx: -1}
 }
 
 func (m model) Init() tea.Cmd {
@@ -217,21 +256,50 @@ func runQueryAndUpdateTable(m model, forceUpdateTable, maintainCursor bool) tea.
 			defaultFilter = ""
 		}
 
+		numEntries := getNumEntriesNeeded(m.ctx)
+		if maintainCursor {
+			// Reload all the pages that were previously loaded, so that the cursor can stay in place
+			numEntries = max(numEntries, m.searchOffset)
+		}
+
 		// Kick off an async query to getRows() so that we can start our DB query in the background
 		// before bubbletea actually invokes our tea.Msg. This reduces latency between key presses
 		// and results being displayed.
 		go func() {
-			_, _, _ = getRows(m.ctx, conf.DisplayedColumns, m.shellName, defaultFilter, query, getNumEntriesNeeded(m.ctx))
+			_, _, _ = getRows(m.ctx, conf.DisplayedColumns, m.shellName, defaultFilter, query, numEntries)
 		}()
 
 		return func() tea.Msg {
-			rows, entries, searchErr := getRows(m.ctx, conf.DisplayedColumns, m.shellName, defaultFilter, query, getNumEntriesNeeded(m.ctx))
-			return asyncQueryFinishedMsg{queryId, rows, entries, searchErr, forceUpdateTable, maintainCursor, nil, false}
+			rows, entries, totalMatches, searchErr := getRowsAndCount(m.ctx, conf.DisplayedColumns, m.shellName, defaultFilter, query, numEntries)
+			return asyncQueryFinishedMsg{
+				queryId:          queryId,
+				rows:             rows,
+				entries:          entries,
+				searchErr:        searchErr,
+				forceUpdateTable: forceUpdateTable,
+				maintainCursor:   maintainCursor,
+				searchQuery:      defaultFilter + " " + query,
+				numEntries:       numEntries,
+				totalMatches:     totalMatches,
+			}
 		}
 	}
 	return nil
 }
 
+func loadMoreRows(m model) tea.Cmd {
+	conf := hctx.GetConf(m.ctx)
+	queryId := m.tableQueryId
+	searchQuery := m.tableSearchQuery
+	offset := m.searchOffset
+	existingEntries := m.tableEntries
+	numEntries := getNumEntriesNeeded(m.ctx)
+	return func() tea.Msg {
+		rows, entries, err := getMoreRows(m.ctx, conf.DisplayedColumns, searchQuery, offset, numEntries, existingEntries)
+		return asyncPageLoadedMsg{queryId: queryId, rows: rows, entries: entries, numEntries: numEntries, searchErr: err}
+	}
+}
+
 func sanitizeEscapeCodes(input string) string {
 	re := regexp.MustCompile(`\d\d;rgb:[0-9a-f]{4}/[0-9a-f]{4}/[0-9a-f]{4}`)
 	return re.ReplaceAllString(input, "")
@@ -243,8 +311,17 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch {
 		case key.Matches(msg, loadedKeyBindings.Quit):
+			if m.inspecting {
+				m.inspecting = false
+				return m, nil
+			}
 			m.quitting = true
 			return m, tea.Quit
+		case key.Matches(msg, loadedKeyBindings.Inspect):
+			if len(m.tableEntries) != 0 && m.table != nil {
+				m.inspecting = !m.inspecting
+			}
+			return m, nil
 		case key.Matches(msg, loadedKeyBindings.SelectEntry):
 			if len(m.tableEntries) != 0 && m.table != nil {
 				m.selected = Selected
@@ -267,6 +344,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			cmd := runQueryAndUpdateTable(m, true, true)
 			preventTableOverscrolling(m)
 			return m, cmd
+		case key.Matches(msg, loadedKeyBindings.PreviousQuery), key.Matches(msg, loadedKeyBindings.Up) && shouldRecallQueryOnUp(m):
+			return recallQuery(m, m.queryHistoryIdx+1)
+		case key.Matches(msg, loadedKeyBindings.NextQuery):
+			return recallQuery(m, m.queryHistoryIdx-1)
 		case key.Matches(msg, loadedKeyBindings.Help):
 			m.help.ShowAll = !m.help.ShowAll
 			return m, nil
@@ -314,6 +395,10 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			i, cmd2 := m.queryInput.Update(msg)
 			m.queryInput = i
+			if m.queryHistoryIdx >= 0 && m.queryInput.Value() != m.queryHistory[m.queryHistoryIdx] {
+				// The query was edited, so we're no longer browsing through previous queries
+				m.queryHistoryIdx = -1
+			}
 			searchQuery := m.queryInput.Value()
 			m.runQuery = &searchQuery
 			CURRENT_QUERY_FOR_HIGHLIGHTING = searchQuery
@@ -339,6 +424,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.queryId > LAST_PROCESSED_QUERY_ID {
 			LAST_PROCESSED_QUERY_ID = msg.queryId
 			m = updateTable(m, msg.rows, msg.entries, msg.searchErr, msg.forceUpdateTable, msg.maintainCursor)
+			if msg.searchErr == nil {
+				m.tableQueryId = msg.queryId
+				m.tableSearchQuery = msg.searchQuery
+				m.searchOffset = msg.numEntries
+				m.totalMatches = msg.totalMatches
+				m.isLoadingMore = false
+			}
 			if msg.overriddenSearchQuery != nil {
 				m.queryInput.SetValue(*msg.overriddenSearchQuery)
 			}
@@ -347,6 +439,34 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.hasFinishedFirstLoad = true
 		}
 		return m, nil
+	case table.NearEndMsg:
+		if m.isLoadingMore || m.totalMatches.Entries < 0 || int64(m.searchOffset) >= m.totalMatches.Entries {
+			return m, nil
+		}
+		m.isLoadingMore = true
+		return m, loadMoreRows(m)
+	case asyncPageLoadedMsg:
+		if m.table == nil || msg.queryId != m.tableQueryId {
+			// The table has since been updated for a different query, so this page is outdated
+			return m, nil
+		}
+		m.isLoadingMore = false
+		if msg.searchErr != nil {
+			m.searchErr = msg.searchErr
+			return m, nil
+		}
+		m.searchOffset += msg.numEntries
+		// Drop the empty rows that pad out the table before appending the new page
+		rows := slices.Concat(m.table.Rows()[:len(m.tableEntries)], msg.rows)
+		m.tableEntries = slices.Concat(m.tableEntries, msg.entries)
+		m.table.SetRows(rows)
+		if len(msg.entries) == 0 && m.totalMatches.Entries >= 0 && int64(m.searchOffset) < m.totalMatches.Entries {
+			// The whole page was filtered out (e.g. as duplicates), so no NearEndMsg will be sent for it. Load
+			// the next page right away so that loading doesn't stall.
+			m.isLoadingMore = true
+			return m, loadMoreRows(m)
+		}
+		return m, nil
 	default:
 		var cmd tea.Cmd
 		if m.isLoading {
@@ -363,6 +483,42 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 }
 
+// Whether pressing up should recall a previous query rather than scrolling the table. This is the case
+// when the cursor is already at the top of the table and the query is either empty or was itself recalled.
+func shouldRecallQueryOnUp(m model) bool {
+	if m.table != nil && m.table.Cursor() > 0 {
+		return false
+	}
+	return m.queryInput.Value() == "" || m.queryHistoryIdx >= 0
+}
+
+// Replaces the current query with the query at the given index of the query history
+func recallQuery(m model, idx int) (model, tea.Cmd) {
+	if m.queryHistory == nil {
+		queryHistory, err := lib.GetQueryHistory(m.ctx, 100)
+		if err != nil {
+			m.searchErr = err
+			return m, nil
+		}
+		m.queryHistory = queryHistory
+	}
+	if idx >= len(m.queryHistory) {
+		return m, nil
+	}
+	query := ""
+	if idx >= 0 {
+		query = m.queryHistory[idx]
+	} else {
+		idx = -1
+	}
+	m.queryHistoryIdx = idx
+	m.queryInput.SetValue(query)
+	m.queryInput.CursorEnd()
+	m.runQuery = &query
+	CURRENT_QUERY_FOR_HIGHLIGHTING = query
+	return m, runQueryAndUpdateTable(m, false, false)
+}
+
 func calculateWordBoundaries(input string) []int {
 	ret := make([]int, 0)
 	ret = append(ret, 0)
@@ -417,6 +573,9 @@ func (m model) View() string {
 	if m.searchErr != nil {
 		additionalMessages = append(additionalMessages, fmt.Sprintf("Warning: failed to search: %v", m.searchErr))
 	}
+	if m.totalMatches.Entries > int64(m.searchOffset) {
+		additionalMessages = append(additionalMessages, fmt.Sprintf("Loaded %d of %d matching entries, scroll down to load more", len(m.tableEntries), numMatchingRows(m.ctx, m.totalMatches)))
+	}
 	if LAST_PROCESSED_QUERY_ID < LAST_DISPATCHED_QUERY_ID && time.Since(LAST_DISPATCHED_QUERY_TIMESTAMP) > time.Second {
 		additionalMessages = append(additionalMessages, fmt.Sprintf("%s Executing search query...", m.spinner.View()))
 	}
@@ -432,7 +591,28 @@ func (m model) View() string {
 	if isCompactHeightMode(m.ctx) {
 		additionalSpacing = ""
 	}
-	return fmt.Sprintf("%s%s%s%sSearch Query: %s\n%s%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, m.queryInput.View(), additionalSpacing, renderNullableTable(m, helpView)) + helpView
+	return fmt.Sprintf("%s%s%s%s%s%s\n%s%s\n", additionalSpacing, additionalMessagesStr, m.banner, additionalSpacing, searchQueryLabel(m), m.queryInput.View(), additionalSpacing, renderNullableTable(m, helpView)) + helpView
+}
+
+// Returns the label for the search query, which includes the total number of matching rows once it is known
+func searchQueryLabel(m model) string {
+	numRows := numMatchingRows(m.ctx, m.totalMatches)
+	switch {
+	case numRows < 0:
+		return "Search Query: "
+	case numRows == 1:
+		return "Search Query (1 match): "
+	default:
+		return fmt.Sprintf("Search Query (%d matches): ", numRows)
+	}
+}
+
+// Returns the number of table rows that the matching entries are displayed as, or -1 if it is unknown
+func numMatchingRows(ctx context.Context, counts lib.SearchResultCounts) int64 {
+	if hctx.GetConf(ctx).FilterDuplicateCommands {
+		return counts.DistinctCommands
+	}
+	return counts.Entries
 }
 
 func isExtraCompactHeightMode(ctx context.Context) bool {
@@ -471,13 +651,71 @@ func renderNullableTable(m model, helpText string) string {
 	}
 	helpTextLen := strings.Count(helpText, "\n")
 	baseStyle := getBaseStyle(*hctx.GetConf(m.ctx))
+	tableView := m.table.View()
+	if m.inspecting && m.table.Cursor() < len(m.tableEntries) {
+		// Render the inspector with the same dimensions as the table so that the layout doesn't jump around
+		tableLines := strings.Split(tableView, "\n")
+		inspectorLines := buildInspectorLines(m.tableEntries[m.table.Cursor()], hctx.GetConf(m.ctx).TimestampFormat, lipgloss.Width(tableLines[0]), len(tableLines))
+		tableView = strings.Join(inspectorLines, "\n")
+	}
 	if isCompactHeightMode(m.ctx) && helpTextLen > 1 {
 		// If the help text is expanded, and this is a small window, then we truncate the table so that the help text displays on top of it
-		lines := strings.Split(baseStyle.Render(m.table.View()), "\n")
+		lines := strings.Split(baseStyle.Render(tableView), "\n")
 		truncated := lines[:len(lines)-helpTextLen]
 		return strings.Join(truncated, "\n")
 	}
-	return baseStyle.Render(m.table.View())
+	return baseStyle.Render(tableView)
+}
+
+var ansiEscapeRegex = regexp.MustCompile(`\x1b(\[[0-9;?]*[ -/]*[@-~]|\][^\x07\x1b]*(\x07|\x1b\\)|[@-Z\\-_])`)
+
+// Builds exactly height lines of at most width cells describing the given entry, including the tail of its
+// output if it was recorded via `hishtory run`
+func buildInspectorLines(entry *data.HistoryEntry, timestampFormat string, width, height int) []string {
+	lines := []string{
+		"Command:    " + strings.ReplaceAll(entry.Command, "\n", "\\n"),
+		"Directory:  " + entry.CurrentWorkingDirectory,
+		"Host:       " + entry.LocalUsername + "@" + entry.Hostname,
+		"Started:    " + entry.StartTime.Local().Format(timestampFormat),
+		"Exit code:  " + strconv.Itoa(entry.ExitCode),
+	}
+	if entry.WallTime > 0 {
+		lines = append(lines, fmt.Sprintf("Resources:  wall time %s, CPU time %s, max RSS %s",
+			entry.WallTime.Round(time.Millisecond), entry.CpuTime.Round(time.Millisecond), lib.FormatMaxRss(entry.MaxRssKb)))
+	} else if entry.EndTime.UnixMilli() != 0 {
+		lines = append(lines, "Runtime:    "+entry.EndTime.Sub(entry.StartTime).Round(time.Millisecond).String())
+	}
+	if entry.Output == "" {
+		lines = append(lines, "", "Output was not recorded. Run commands via `hishtory run` to record their output.")
+	} else {
+		// Show as much of the end of the output as fits
+		output := ansiEscapeRegex.ReplaceAllString(entry.Output, "")
+		output = strings.ReplaceAll(strings.ReplaceAll(output, "\r\n", "\n"), "\t", "    ")
+		outputLines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+		available := max(height-len(lines)-2, 0)
+		if len(outputLines) > available {
+			outputLines = outputLines[len(outputLines)-available:]
+		}
+		lines = append(lines, "", "Output:")
+		lines = append(lines, outputLines...)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	for i, line := range lines {
+		line = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, line)
+		line = runewidth.Truncate(line, width, "…")
+		lines[i] = line + strings.Repeat(" ", max(width-runewidth.StringWidth(line), 0))
+	}
+	for len(lines) < height {
+		lines = append(lines, strings.Repeat(" ", width))
+	}
+	return lines
 }
 
 func getRowsFromAiSuggestions(ctx context.Context, columnNames []string, shellName, query string) ([]table.Row, []*data.HistoryEntry, error) {
@@ -526,9 +764,56 @@ func getRows(ctx context.Context, columnNames []string, shellName, defaultFilter
 	if err != nil {
 		return nil, nil, err
 	}
+	return buildRows(ctx, columnNames, searchResults, numEntries, make(map[string]bool))
+}
+
+// The search result counts used when they are unknown
+var unknownSearchResultCounts = lib.SearchResultCounts{Entries: -1, DistinctCommands: -1}
+
+// Runs the search for getRows() along with a query for the total number of matching entries. The totals
+// are -1 if they are unknown, for example since the query is for AI suggestions.
+func getRowsAndCount(ctx context.Context, columnNames []string, shellName, defaultFilter, query string, numEntries int) ([]table.Row, []*data.HistoryEntry, lib.SearchResultCounts, error) {
+	config := hctx.GetConf(ctx)
+	if config.AiCompletion && strings.HasPrefix(query, "?") && len(query) > 1 {
+		rows, entries, err := getRowsFromAiSuggestions(ctx, columnNames, shellName, query)
+		return rows, entries, unknownSearchResultCounts, err
+	}
+	totalMatchesChan := make(chan lib.SearchResultCounts, 1)
+	go func() {
+		totalMatches, err := lib.CountSearchResults(ctx, hctx.GetDb(ctx), defaultFilter+" "+query)
+		if err != nil {
+			hctx.GetLogger().Warnf("failed to count search results for query=%#v: %v", query, err)
+			totalMatches = unknownSearchResultCounts
+		}
+		totalMatchesChan <- totalMatches
+	}()
+	rows, entries, err := getRows(ctx, columnNames, shellName, defaultFilter, query, numEntries)
+	totalMatches := <-totalMatchesChan
+	return rows, entries, totalMatches, err
+}
+
+// Retrieves the next page of rows for the given search query, starting at the given offset into the search results
+func getMoreRows(ctx context.Context, columnNames []string, searchQuery string, offset, numEntries int, existingEntries []*data.HistoryEntry) ([]table.Row, []*data.HistoryEntry, error) {
+	searchResults, err := lib.SearchWithOffset(ctx, hctx.GetDb(ctx), searchQuery, numEntries, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+	seenCommands := make(map[string]bool)
+	for _, entry := range existingEntries {
+		seenCommands[strings.TrimSpace(entry.Command)] = true
+	}
+	return buildRows(ctx, columnNames, searchResults, len(searchResults), seenCommands)
+}
+
+// Builds the table rows for the given search results, padding the table with empty rows up to numEntries
+func buildRows(ctx context.Context, columnNames []string, searchResults []*data.HistoryEntry, numEntries int, seenCommands map[string]bool) ([]table.Row, []*data.HistoryEntry, error) {
+	config := hctx.GetConf(ctx)
 	var rows []table.Row
 	var filteredData []*data.HistoryEntry
-	seenCommands := make(map[string]bool)
+	commandRenderer := commandEscaper
+	if config.MultiLineCommands {
+		commandRenderer = multiLineCommandRenderer
+	}
 
 	for i := 0; i < numEntries; i++ {
 		if i < len(searchResults) {
@@ -542,7 +827,7 @@ func getRows(ctx context.Context, columnNames []string, shellName, defaultFilter
 				seenCommands[cmd] = true
 			}
 
-			row, err := lib.BuildTableRow(ctx, columnNames, *entry, commandEscaper)
+			row, err := lib.BuildTableRow(ctx, columnNames, *entry, commandRenderer)
 			if err != nil {
 				return nil, nil, fmt.Errorf("failed to build row for entry=%#v: %w", entry, err)
 			}
@@ -563,11 +848,19 @@ func commandEscaper(cmd string) string {
 	return fmt.Sprintf("%#v", cmd)
 }
 
+// Renders a command so that it can be displayed across multiple lines in the table. Tabs are
+// expanded so that indentation is preserved when rendered.
+func multiLineCommandRenderer(cmd string) string {
+	return strings.TrimRight(strings.ReplaceAll(cmd, "\t", "    "), "\n")
+}
+
 func calculateColumnWidths(rows []table.Row, numColumns int) []int {
 	neededColumnWidth := make([]int, numColumns)
 	for _, row := range rows {
 		for i, v := range row {
-			neededColumnWidth[i] = max(neededColumnWidth[i], len(v))
+			for _, line := range strings.Split(v, "\n") {
+				neededColumnWidth[i] = max(neededColumnWidth[i], len(line))
+			}
 		}
 	}
 	return neededColumnWidth
@@ -600,9 +893,13 @@ func makeTableColumns(ctx context.Context, shellName string, columnNames []strin
 
 	// Calculate the minimum amount of space that we need for each column for the current actual search
 	columnWidths := calculateColumnWidths(rows, len(columnNames))
+	columnSettings := make([]hctx.ColumnSettings, len(columnNames))
+	headers := make([]string, len(columnNames))
 	totalWidth := (len(columnWidths) + 1) * 2 // The amount of space needed for the table padding
 	for i, name := range columnNames {
-		columnWidths[i] = max(columnWidths[i], len(name))
+		columnSettings[i] = lib.GetColumnSettings(ctx, name)
+		headers[i] = lib.GetColumnHeader(ctx, name)
+		columnWidths[i] = clampColumnWidth(max(columnWidths[i], len(headers[i])), columnSettings[i])
 		totalWidth += columnWidths[i]
 	}
 
@@ -624,7 +921,7 @@ func makeTableColumns(ctx context.Context, shellName string, columnNames []strin
 	for totalWidth < (terminalWidth - len(columnNames)) {
 		prevTotalWidth := totalWidth
 		for i := range columnNames {
-			if columnWidths[i] < maximumColumnWidths[i]+5 {
+			if columnWidths[i] < maximumColumnWidths[i]+5 && (columnSettings[i].MaxWidth == 0 || columnWidths[i] < columnSettings[i].MaxWidth) {
 				columnWidths[i] += 1
 				totalWidth += 1
 			}
@@ -639,23 +936,64 @@ func makeTableColumns(ctx context.Context, shellName string, columnNames []strin
 		largestColumnIdx := -1
 		largestColumnSize := -1
 		for i := range columnNames {
-			if columnWidths[i] > largestColumnSize {
+			if columnWidths[i] > largestColumnSize && columnWidths[i] > columnSettings[i].MinWidth {
 				largestColumnIdx = i
 				largestColumnSize = columnWidths[i]
 			}
 		}
+		if largestColumnIdx == -1 {
+			// Every column is already at its configured minimum width
+			break
+		}
 		columnWidths[largestColumnIdx] -= 1
 		totalWidth -= 1
 	}
 
 	// And finally, create some actual columns!
 	columns := make([]table.Column, 0)
-	for i, name := range columnNames {
-		columns = append(columns, table.Column{Title: name, Width: columnWidths[i]})
+	for i := range columnNames {
+		columns = append(columns, table.Column{
+			Title:    headers[i],
+			Width:    columnWidths[i],
+			Align:    getColumnAlignment(columnSettings[i]),
+			Truncate: getColumnTruncator(columnSettings[i]),
+		})
 	}
 	return columns, nil
 }
 
+func clampColumnWidth(width int, settings hctx.ColumnSettings) int {
+	if settings.MaxWidth > 0 {
+		width = min(width, settings.MaxWidth)
+	}
+	if settings.MinWidth > 0 {
+		width = max(width, settings.MinWidth)
+	}
+	return width
+}
+
+func getColumnAlignment(settings hctx.ColumnSettings) lipgloss.Position {
+	switch settings.Alignment {
+	case "right":
+		return lipgloss.Right
+	case "center":
+		return lipgloss.Center
+	default:
+		return lipgloss.Left
+	}
+}
+
+func getColumnTruncator(settings hctx.ColumnSettings) func(string, int) string {
+	if settings.Truncation == "" || settings.Truncation == "right" {
+		// Use the table's default (cached) truncation
+		return nil
+	}
+	strategy := settings.Truncation
+	return func(value string, width int) string {
+		return lib.TruncateColumnValue(value, width, strategy)
+	}
+}
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -723,13 +1061,18 @@ func makeTable(ctx context.Context, shellName string, rows []table.Row) (table.M
 		tuiSize -= 3
 	}
 	tableHeight := min(getTableHeight(ctx), terminalHeight-tuiSize)
-	t := table.New(
+	tableOptions := []table.Option{
 		table.WithColumns(columns),
 		table.WithRows(rows),
 		table.WithFocused(true),
 		table.WithHeight(tableHeight),
 		table.WithKeyMap(km),
-	)
+		table.WithLoadMoreThreshold(tableHeight),
+	}
+	if config.MultiLineCommands {
+		tableOptions = append(tableOptions, table.WithMaxLinesPerRow(config.MaxLinesPerRow))
+	}
+	t := table.New(tableOptions...)
 
 	s := table.DefaultStyles()
 	s.Header = s.Header.
@@ -955,20 +1298,21 @@ func TuiQuery(ctx context.Context, shellName string, initialQueryArray []string)
 	go func() {
 		queryId := allocateQueryId()
 		conf := hctx.GetConf(ctx)
-		rows, entries, err := getRows(ctx, conf.DisplayedColumns, shellName, conf.DefaultFilter, initialQueryWithEscaping, getNumEntriesNeeded(ctx))
+		numEntries := getNumEntriesNeeded(ctx)
+		rows, entries, totalMatches, err := getRowsAndCount(ctx, conf.DisplayedColumns, shellName, conf.DefaultFilter, initialQueryWithEscaping, numEntries)
 		if err == nil || initialQueryWithEscaping == "" {
 			if err != nil {
 				panic(err)
 			}
-			p.Send(asyncQueryFinishedMsg{queryId: queryId, rows: rows, entries: entries, searchErr: err, forceUpdateTable: true, maintainCursor: false, overriddenSearchQuery: nil, isFirstQuery: true})
+			p.Send(asyncQueryFinishedMsg{queryId: queryId, rows: rows, entries: entries, searchErr: err, forceUpdateTable: true, maintainCursor: false, overriddenSearchQuery: nil, isFirstQuery: true, searchQuery: conf.DefaultFilter + " " + initialQueryWithEscaping, numEntries: numEntries, totalMatches: totalMatches})
 		} else {
 			// The initial query is likely invalid in some way, let's just drop it
 			emptyQuery := ""
-			rows, entries, err := getRows(ctx, hctx.GetConf(ctx).DisplayedColumns, shellName, conf.DefaultFilter, emptyQuery, getNumEntriesNeeded(ctx))
+			rows, entries, totalMatches, err := getRowsAndCount(ctx, hctx.GetConf(ctx).DisplayedColumns, shellName, conf.DefaultFilter, emptyQuery, numEntries)
 			if err != nil {
 				panic(err)
 			}
-			p.Send(asyncQueryFinishedMsg{queryId: allocateQueryId(), rows: rows, entries: entries, searchErr: err, forceUpdateTable: true, maintainCursor: false, overriddenSearchQuery: &emptyQuery, isFirstQuery: true})
+			p.Send(asyncQueryFinishedMsg{queryId: allocateQueryId(), rows: rows, entries: entries, searchErr: err, forceUpdateTable: true, maintainCursor: false, overriddenSearchQuery: &emptyQuery, isFirstQuery: true, searchQuery: conf.DefaultFilter + " " + emptyQuery, numEntries: numEntries, totalMatches: totalMatches})
 		}
 	}()
 	// Async: Retrieve additional entries from the backend
@@ -999,7 +1343,7 @@ func TuiQuery(ctx context.Context, shellName string, initialQueryArray []string)
 		p.Send(bannerMsg{banner: string(banner)})
 	}()
 	// Blocking: Start the TUI
-	_, err = p.Run()
+	finalModel, err := p.Run()
 	if err != nil {
 		return err
 	}
@@ -1008,6 +1352,13 @@ func TuiQuery(ctx context.Context, shellName string, initialQueryArray []string)
 		SELECTED_COMMAND = strings.Join(initialQueryArray, " ")
 	}
 	fmt.Printf("%s\n", SELECTED_COMMAND)
+	// Only saved locally, and uploaded by the next sync, so that this doesn't delay returning the selected command
+	if m, ok := finalModel.(model); ok {
+		err = lib.SaveQueryHistory(ctx, m.queryInput.Value())
+		if err != nil {
+			hctx.GetLogger().Warnf("failed to save query history: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -1,8 +1,17 @@
 package tui
 
 import (
+	"context"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ddworken/hishtory/client/data"
+	"github.com/ddworken/hishtory/client/hctx"
+	"github.com/ddworken/hishtory/client/lib"
+	"github.com/ddworken/hishtory/client/table"
+
+	"github.com/mattn/go-runewidth"
 	"github.com/stretchr/testify/require"
 )
 
@@ -21,3 +30,79 @@ func TestSanitizeEscapeCodes(t *testing.T) {
 	require.Equal(t, "", sanitizeEscapeCodes("11;rgb:1c1c/1c1c/1c1c"))
 	require.Equal(t, "foo  bar", sanitizeEscapeCodes("foo 11;rgb:1c1c/1c1c/1c1c bar"))
 }
+
+func TestMultiLineCommandRenderer(t *testing.T) {
+	require.Equal(t, "ls", multiLineCommandRenderer("ls"))
+	require.Equal(t, "for i in 1 2\ndo\n    echo $i\ndone", multiLineCommandRenderer("for i in 1 2\ndo\n\techo $i\ndone\n"))
+	require.Equal(t, []int{2, 12}, calculateColumnWidths([]table.Row{{"a1", "for i in 1 2\ndo\n    echo $i\ndone"}, {"b1", "ls"}}, 2))
+}
+
+func TestBuildInspectorLines(t *testing.T) {
+	entry := &data.HistoryEntry{
+		LocalUsername:           "david",
+		Hostname:                "laptop",
+		Command:                 "make test",
+		CurrentWorkingDirectory: "~/code/",
+		ExitCode:                2,
+		StartTime:               time.Unix(1700000000, 0),
+		EndTime:                 time.Unix(1700000003, 0),
+	}
+
+	// Entries without recorded output
+	lines := buildInspectorLines(entry, "2006", 40, 10)
+	require.Len(t, lines, 10)
+	for _, line := range lines {
+		require.Equal(t, 40, runewidth.StringWidth(line))
+	}
+	require.Equal(t, "Command:    make test", strings.TrimSpace(lines[0]))
+	require.Equal(t, "Host:       david@laptop", strings.TrimSpace(lines[2]))
+	require.Equal(t, "Exit code:  2", strings.TrimSpace(lines[4]))
+	require.Equal(t, "Runtime:    3s", strings.TrimSpace(lines[5]))
+	require.Equal(t, "Output was not recorded. Run commands v…", lines[7])
+
+	// Only the end of the output is shown, with escape codes stripped
+	entry.Output = "line 1\nline 2\n\x1b[31mline 3\x1b[0m\n"
+	entry.WallTime = 3 * time.Second
+	entry.CpuTime = 1500 * time.Millisecond
+	entry.MaxRssKb = 2048
+	lines = buildInspectorLines(entry, "2006", 60, 10)
+	require.Len(t, lines, 10)
+	require.Equal(t, "Resources:  wall time 3s, CPU time 1.5s, max RSS 2.0 MiB", strings.TrimSpace(lines[5]))
+	require.Equal(t, "Output:", strings.TrimSpace(lines[7]))
+	require.Equal(t, "line 2", strings.TrimSpace(lines[8]))
+	require.Equal(t, "line 3", strings.TrimSpace(lines[9]))
+}
+
+func TestSearchQueryLabel(t *testing.T) {
+	config := hctx.ClientConfig{}
+	m := model{ctx: context.WithValue(context.Background(), hctx.ConfigCtxKey, &config), totalMatches: unknownSearchResultCounts}
+	require.Equal(t, "Search Query: ", searchQueryLabel(m))
+	m.totalMatches = lib.SearchResultCounts{Entries: 1, DistinctCommands: 1}
+	require.Equal(t, "Search Query (1 match): ", searchQueryLabel(m))
+	m.totalMatches = lib.SearchResultCounts{Entries: 12, DistinctCommands: 10}
+	require.Equal(t, "Search Query (12 matches): ", searchQueryLabel(m))
+
+	// The count matches the number of rows shown when duplicate commands are filtered out
+	config.FilterDuplicateCommands = true
+	require.Equal(t, "Search Query (10 matches): ", searchQueryLabel(m))
+}
+
+func TestPageOfOnlyDuplicatesLoadsNextPage(t *testing.T) {
+	config := hctx.ClientConfig{FilterDuplicateCommands: true}
+	tbl := table.New()
+	m := model{ctx: context.WithValue(context.Background(), hctx.ConfigCtxKey, &config), table: &tbl, tableQueryId: 1, searchOffset: 100, totalMatches: lib.SearchResultCounts{Entries: 300, DistinctCommands: 10}, isLoadingMore: true}
+
+	// A page whose entries were all filtered out immediately loads the next one
+	updated, cmd := m.Update(asyncPageLoadedMsg{queryId: 1, numEntries: 100})
+	m = updated.(model)
+	require.NotNil(t, cmd)
+	require.True(t, m.isLoadingMore)
+	require.Equal(t, 200, m.searchOffset)
+
+	// Until all matching entries have been loaded
+	updated, cmd = m.Update(asyncPageLoadedMsg{queryId: 1, numEntries: 100})
+	m = updated.(model)
+	require.Nil(t, cmd)
+	require.False(t, m.isLoadingMore)
+	require.Equal(t, 300, m.searchOffset)
+}
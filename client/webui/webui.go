@@ -25,9 +25,30 @@ var templateFiles embed.FS
 var staticFiles embed.FS
 
 type webUiData struct {
-	SearchQuery   string
-	SearchResults [][]string
-	ColumnNames   []string
+	SearchQuery      string
+	SearchResults    [][]string
+	ColumnNames      []string
+	ColumnAlignments []string
+}
+
+func makeWebUiData(ctx context.Context, searchQuery string, tableRows [][]string) webUiData {
+	columnNames := hctx.GetConf(ctx).DisplayedColumns
+	headers := make([]string, 0, len(columnNames))
+	alignments := make([]string, 0, len(columnNames))
+	for _, name := range columnNames {
+		headers = append(headers, lib.GetColumnHeader(ctx, name))
+		alignment := lib.GetColumnSettings(ctx, name).Alignment
+		if alignment == "" {
+			alignment = "left"
+		}
+		alignments = append(alignments, alignment)
+	}
+	return webUiData{
+		SearchQuery:      searchQuery,
+		SearchResults:    tableRows,
+		ColumnNames:      headers,
+		ColumnAlignments: alignments,
+	}
 }
 
 func getTableRowsForDisplay(ctx context.Context, searchQuery string) ([][]string, error) {
@@ -47,11 +68,7 @@ func htmx_resultsTable(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Header().Add("Content-Type", "text/html")
 	w.Header().Add("HX-Replace-Url", getNewUrl(r, searchQuery))
-	err = getTemplates().ExecuteTemplate(w, "resultsTable.html", webUiData{
-		SearchQuery:   searchQuery,
-		SearchResults: tableRows,
-		ColumnNames:   hctx.GetConf(r.Context()).DisplayedColumns,
-	})
+	err = getTemplates().ExecuteTemplate(w, "resultsTable.html", makeWebUiData(r.Context(), searchQuery, tableRows))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		panic(err)
@@ -83,11 +100,7 @@ func webuiHandler(w http.ResponseWriter, r *http.Request) {
 		panic(err)
 	}
 	w.Header().Add("Content-Type", "text/html")
-	err = getTemplates().ExecuteTemplate(w, "webui.html", webUiData{
-		SearchQuery:   searchQuery,
-		SearchResults: tableRows,
-		ColumnNames:   hctx.GetConf(r.Context()).DisplayedColumns,
-	})
+	err = getTemplates().ExecuteTemplate(w, "webui.html", makeWebUiData(r.Context(), searchQuery, tableRows))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		panic(err)
@@ -106,6 +119,10 @@ func buildTableRows(ctx context.Context, entries []*data.HistoryEntry) ([][]stri
 		if err != nil {
 			return nil, err
 		}
+		for i, columnName := range columnNames {
+			settings := lib.GetColumnSettings(ctx, columnName)
+			row[i] = lib.TruncateColumnValue(row[i], settings.MaxWidth, settings.Truncation)
+		}
 		ret = append(ret, row)
 	}
 	return ret, nil
@@ -0,0 +1,133 @@
+package shared
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings supported for sync payloads
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// MaxDecompressedRequestSize bounds the size of decompressed request bodies on the server, to guard against
+// decompression bombs. It is well above the size of a batch of submitted entries, and clients send any larger
+// bodies (i.e. dumps of very large histories) uncompressed.
+const MaxDecompressedRequestSize = 32 * 1024 * 1024
+
+// Limits for zstd decoding, to guard against decompression bombs. Our encoders use 8MiB windows, and the zstd
+// spec recommends that decoders support windows of at least that size. Payloads that are decoded in memory
+// (i.e. S3 objects, which are at most a segment of entries) are much smaller than the in-memory limit.
+const (
+	zstdMaxWindowSize  = 16 * 1024 * 1024
+	zstdMaxDecodedSize = 512 * 1024 * 1024
+)
+
+// AcceptEncoding is the value of the Accept-Encoding header sent by clients, and by the server to advertise which
+// encodings it accepts for request bodies (see RFC 7694). Encodings are listed in order of preference.
+const AcceptEncoding = EncodingZstd + ", " + EncodingGzip
+
+// IsSupportedEncoding returns whether encoding is a content encoding that Compress and Decompress support.
+func IsSupportedEncoding(encoding string) bool {
+	return encoding == EncodingGzip || encoding == EncodingZstd
+}
+
+// NegotiateEncoding returns the most preferred supported encoding that is accepted by the given Accept-Encoding
+// header value, or "" if none are.
+func NegotiateEncoding(acceptEncoding string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		encoding, params, _ := strings.Cut(part, ";")
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(encoding))] = true
+	}
+	for _, encoding := range []string{EncodingZstd, EncodingGzip} {
+		if accepted[encoding] || accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// zstd encoders and decoders are expensive to create, but safe for concurrent use via EncodeAll and DecodeAll
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0),
+		zstd.WithDecoderMaxWindow(zstdMaxWindowSize), zstd.WithDecoderMaxMemory(zstdMaxDecodedSize))
+)
+
+// Compress compresses data with the given encoding.
+func Compress(encoding string, data []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case EncodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// Decompress decompresses data that was compressed with the given encoding.
+func Decompress(encoding string, data []byte) ([]byte, error) {
+	if encoding == EncodingZstd {
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+	r, err := NewDecompressingReader(encoding, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// NewDecompressingReader returns a reader that decompresses r, which was compressed with the given encoding.
+func NewDecompressingReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case EncodingZstd:
+		// For streams, the max memory is the max window size rather than a limit on the decompressed size, so
+		// callers need to limit how much they read
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(zstdMaxWindowSize), zstd.WithDecoderMaxMemory(zstdMaxWindowSize))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// NewCompressingWriter returns a writer that compresses data written to it with the given encoding and writes
+// it to w. It must be closed to flush the compressed data.
+func NewCompressingWriter(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	require.Equal(t, EncodingZstd, NegotiateEncoding(AcceptEncoding))
+	require.Equal(t, EncodingZstd, NegotiateEncoding("gzip, deflate, br, zstd"))
+	require.Equal(t, EncodingGzip, NegotiateEncoding("gzip, deflate, br"))
+	require.Equal(t, EncodingGzip, NegotiateEncoding("GZIP;q=0.5"))
+	require.Equal(t, EncodingGzip, NegotiateEncoding("zstd;q=0, gzip"))
+	require.Equal(t, EncodingZstd, NegotiateEncoding("*"))
+	require.Equal(t, "", NegotiateEncoding("gzip;q=0.0"))
+	require.Equal(t, "", NegotiateEncoding("identity"))
+	require.Equal(t, "", NegotiateEncoding(""))
+}
+
+func TestCompress(t *testing.T) {
+	data := []byte(strings.Repeat(`{"EncryptedData":"dGVzdA=="}`, 100))
+	for _, encoding := range []string{EncodingGzip, EncodingZstd} {
+		compressed, err := Compress(encoding, data)
+		require.NoError(t, err)
+		require.Less(t, len(compressed), len(data))
+		decompressed, err := Decompress(encoding, compressed)
+		require.NoError(t, err)
+		require.Equal(t, data, decompressed)
+
+		var buf bytes.Buffer
+		w, err := NewCompressingWriter(encoding, &buf)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		r, err := NewDecompressingReader(encoding, &buf)
+		require.NoError(t, err)
+		decompressed, err = io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, data, decompressed)
+
+		_, err = Decompress(encoding, data)
+		require.Error(t, err)
+	}
+
+	_, err := Compress("br", data)
+	require.Error(t, err)
+	_, err = Decompress("br", data)
+	require.Error(t, err)
+}
+
+func TestDecompressRejectsLargeWindows(t *testing.T) {
+	// Frames that require a window beyond zstdMaxWindowSize are rejected, rather than allocating it
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf, zstd.WithWindowSize(4*zstdMaxWindowSize))
+	require.NoError(t, err)
+	_, err = w.Write(bytes.Repeat([]byte("a"), 2*zstdMaxWindowSize))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := NewDecompressingReader(EncodingZstd, bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	require.Error(t, err)
+	require.NoError(t, r.Close())
+	_, err = Decompress(EncodingZstd, buf.Bytes())
+	require.Error(t, err)
+}
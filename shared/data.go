@@ -5,6 +5,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -26,6 +27,47 @@ type EncHistoryEntry struct {
 	IsFromSameDevice bool `json:"is_from_same_device"`
 }
 
+// The prefix for EncHistoryEntry.EncryptedId that marks an encrypted TUI query rather than a history entry
+const QueryHistoryIdPrefix = "query-history-"
+
+// The prefix for EncHistoryEntry.EncryptedId that marks an encrypted control message sent between devices
+const ControlMessageIdPrefix = "control-"
+
+// The first client version that understands synced query history and control messages. Older clients store
+// anything that they fail to decrypt as a blank history entry, so these must never be sent to them.
+var MinQueryHistoryVersion = ParsedVersion{MajorVersion: 0, MinorVersion: 336}
+
+// ClientSupportsEntry returns whether a client running the given version (e.g. "v0.336") is able to process
+// the given entry.
+func ClientSupportsEntry(version string, entry *EncHistoryEntry) bool {
+	if !strings.HasPrefix(entry.EncryptedId, QueryHistoryIdPrefix) && !strings.HasPrefix(entry.EncryptedId, ControlMessageIdPrefix) {
+		return true
+	}
+	return ClientVersionAtLeast(version, MinQueryHistoryVersion)
+}
+
+// ClientVersionAtLeast returns whether the given client version (e.g. "v0.336") is at least minVersion. Empty
+// versions are treated as old clients, while unparseable versions (e.g. "v0.Unknown" which is used by dev
+// builds and tests) are treated as new clients.
+func ClientVersionAtLeast(version string, minVersion ParsedVersion) bool {
+	if version == "" {
+		return false
+	}
+	pv, err := ParseVersionString(version)
+	return err != nil || !pv.LessThan(minVersion)
+}
+
+// FilterEntriesForClient returns the entries that a client running the given version is able to process
+func FilterEntriesForClient(version string, entries []*EncHistoryEntry) []*EncHistoryEntry {
+	filtered := make([]*EncHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		if ClientSupportsEntry(version, entry) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
 // Represents a request to get all history entries from a given device. Used as part of bootstrapping
 // a new device.
 type DumpRequest struct {
@@ -160,6 +202,6 @@ type SyncBackend interface {
 	// Ping checks if the backend is reachable.
 	Ping(ctx context.Context) error
 
-	// Type returns the backend type identifier ("http" or "s3").
+	// Type returns the backend type identifier ("http", "s3", "dir", "webdav", or "git").
 	Type() string
 }
@@ -86,6 +86,9 @@ func BackupAndRestoreWithId(t testing.TB, id string) func() {
 		path.Join(homedir, data.GetHishtoryPath(), "config.sh"),
 		path.Join(homedir, data.GetHishtoryPath(), "config.zsh"),
 		path.Join(homedir, data.GetHishtoryPath(), "config.fish"),
+		path.Join(homedir, data.GetHishtoryPath(), "config.nu"),
+		path.Join(homedir, data.GetHishtoryPath(), "config.ps1"),
+		path.Join(homedir, data.GetHishtoryPath(), ".custom_columns_cache.json"),
 		path.Join(homedir, data.GetHishtoryPath(), "hishtory"),
 		path.Join(homedir, ".bash_history"),
 		path.Join(homedir, ".zsh_history"),